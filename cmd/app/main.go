@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"log"
 	"net/http"
 	"os"
@@ -9,6 +10,7 @@ import (
 
 	"upskill/internal/config"
 	"upskill/internal/db"
+	"upskill/internal/mentorship"
 	"upskill/internal/server"
 )
 
@@ -29,7 +31,18 @@ func main() {
 		}
 	}
 
-	srv := server.New(cfg, pool)
+	go mentorship.NewScheduler(cfg, pool).Run(context.Background())
+
+	srv := server.New(context.Background(), cfg, pool)
+
+	if cfg.DebugAddr != "" {
+		go func() {
+			log.Printf("debug listener on %s", cfg.DebugAddr)
+			if err := http.ListenAndServe(cfg.DebugAddr, server.Debug()); err != nil {
+				log.Printf("debug listener stopped: %v", err)
+			}
+		}()
+	}
 
 	addr := ":" + strconv.Itoa(cfg.Port)
 	log.Printf("UpSkill listening on %s (env=%s)", addr, cfg.Env)
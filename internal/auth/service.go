@@ -8,7 +8,9 @@ import (
 	"database/sql"
 	"encoding/pem"
 	"errors"
+	mrand "math/rand/v2"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
@@ -16,6 +18,7 @@ import (
 	"github.com/go-chi/chi/v5"
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"golang.org/x/crypto/bcrypt"
 	"golang.org/x/oauth2"
@@ -98,13 +101,8 @@ func (s *Service) Register(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "bad input", http.StatusBadRequest)
 		return
 	}
-	hash, _ := bcrypt.GenerateFromPassword([]byte(in.Password), bcrypt.DefaultCost)
-	var id int64
-	if err := s.db.QueryRow(r.Context(), `
-		INSERT INTO users(email,password_hash,first_name,last_name)
-		VALUES($1,$2,$3,$4)
-		RETURNING id
-	`, strings.ToLower(in.Email), string(hash), nullIfEmpty(in.FirstName), nullIfEmpty(in.LastName)).Scan(&id); err != nil {
+	id, err := CreateUser(r.Context(), s.db, in.Email, in.Password, in.FirstName, in.LastName)
+	if err != nil {
 		http.Error(w, "email exists?", http.StatusConflict)
 		return
 	}
@@ -142,8 +140,8 @@ func (s *Service) Login(w http.ResponseWriter, r *http.Request) {
 func (s *Service) Me(w http.ResponseWriter, r *http.Request) {
 	uid := UserID(r)
 	var email string
-	var first, last sql.NullString
-	if err := s.db.QueryRow(r.Context(), `SELECT email, first_name, last_name FROM users WHERE id=$1`, uid).Scan(&email, &first, &last); err != nil {
+	var first, last, handle sql.NullString
+	if err := s.db.QueryRow(r.Context(), `SELECT email, first_name, last_name, handle FROM users WHERE id=$1`, uid).Scan(&email, &first, &last, &handle); err != nil {
 		http.Error(w, "not found", http.StatusNotFound)
 		return
 	}
@@ -160,11 +158,148 @@ func (s *Service) Me(w http.ResponseWriter, r *http.Request) {
 		roles = append(roles, role)
 	}
 	web.JSON(w, http.StatusOK, map[string]any{
-		"user":  map[string]any{"id": uid, "email": email, "firstName": first.String, "lastName": last.String},
+		"user":  map[string]any{"id": uid, "email": email, "firstName": first.String, "lastName": last.String, "handle": handle.String},
 		"roles": roles,
 	})
 }
 
+// Querier is satisfied by both *pgxpool.Pool and pgx.Tx. Begin on a pgx.Tx
+// opens a savepoint.
+type Querier interface {
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+	Begin(ctx context.Context) (pgx.Tx, error)
+}
+
+// CreateUser inserts a password user. It fails if the email is taken.
+func CreateUser(ctx context.Context, q Querier, email, password, first, last string) (int64, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return 0, err
+	}
+	var id int64
+	err = q.QueryRow(ctx, `
+		INSERT INTO users(email,password_hash,first_name,last_name)
+		VALUES($1,$2,$3,$4)
+		RETURNING id
+	`, strings.ToLower(email), string(hash), nullIfEmpty(first), nullIfEmpty(last)).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+	return id, assignHandle(ctx, q, id, email)
+}
+
+// ValidHandle reports whether h can be a user handle: 3 to 30 lowercase
+// letters, digits or underscores. Chat mentions are @ plus a handle.
+func ValidHandle(h string) bool {
+	if len(h) < 3 || len(h) > 30 {
+		return false
+	}
+	for _, c := range h {
+		if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+// handleBase derives a handle from the local part of an email address.
+func handleBase(email string) string {
+	local, _, _ := strings.Cut(strings.ToLower(email), "@")
+	var b strings.Builder
+	for _, c := range local {
+		if c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '_' {
+			b.WriteRune(c)
+		}
+	}
+	h := b.String()
+	if len(h) > 24 {
+		h = h[:24]
+	}
+	if len(h) < 3 {
+		h += "_user"
+	}
+	return h
+}
+
+// assignHandle gives a new user the handle derived from their email, or
+// that handle suffixed with their id when it is taken. Each attempt runs in
+// a savepoint so a lost race leaves the caller's transaction usable; after
+// the id, fresh random digits are tried.
+func assignHandle(ctx context.Context, q Querier, uid int64, email string) error {
+	base := handleBase(email)
+	suffix := strconv.FormatInt(uid, 10)
+	for attempt := 0; ; attempt++ {
+		h := base
+		if attempt > 0 {
+			if attempt > 1 {
+				suffix = strconv.FormatInt(uid, 10) + strconv.Itoa(mrand.IntN(10000))
+			}
+			if n := 29 - len(suffix); len(h) > n {
+				h = h[:n]
+			}
+			h += "_" + suffix
+		}
+		err := setHandle(ctx, q, uid, h)
+		if !uniqueViolation(err) || attempt == 5 {
+			return err
+		}
+	}
+}
+
+func setHandle(ctx context.Context, q Querier, uid int64, h string) error {
+	tx, err := q.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+	if _, err := tx.Exec(ctx, `UPDATE users SET handle=$2 WHERE id=$1`, uid, h); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+func uniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505"
+}
+
+// SetHandle changes the caller's handle.
+func (s *Service) SetHandle(w http.ResponseWriter, r *http.Request) {
+	uid := UserID(r)
+	var in struct {
+		Handle string `json:"handle"`
+	}
+	if err := web.DecodeJSON(r, &in); err != nil {
+		http.Error(w, "bad input", http.StatusBadRequest)
+		return
+	}
+	h := strings.ToLower(strings.TrimPrefix(strings.TrimSpace(in.Handle), "@"))
+	if !ValidHandle(h) {
+		http.Error(w, "handle must be 3-30 letters, digits or underscores", http.StatusBadRequest)
+		return
+	}
+	var taken bool
+	if err := s.db.QueryRow(r.Context(), `SELECT EXISTS(SELECT 1 FROM users WHERE handle=$1 AND id<>$2)`, h, uid).Scan(&taken); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if taken {
+		http.Error(w, "handle taken", http.StatusConflict)
+		return
+	}
+	if _, err := s.db.Exec(r.Context(), `UPDATE users SET handle=$2 WHERE id=$1`, uid, h); uniqueViolation(err) {
+		http.Error(w, "handle taken", http.StatusConflict)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	web.JSON(w, http.StatusOK, map[string]any{"handle": h})
+}
+
+// IssueToken returns an access token for uid, as handed out by Login.
+func (s *Service) IssueToken(uid int64) (string, error) { return s.issueJWT(uid) }
+
 func (s *Service) issueJWT(uid int64) (string, error) {
 	claims := jwt.MapClaims{
 		"sub": uid,
@@ -274,6 +409,9 @@ func (s *Service) upsertUserGoogle(ctx context.Context, sub, email, name, avatar
 		`, email, nullIfEmpty(first), nullIfEmpty(last), nullIfEmpty(avatar)).Scan(&uid); err != nil {
 			return 0, err
 		}
+		if err := assignHandle(ctx, s.db, uid, email); err != nil {
+			return 0, err
+		}
 	}
 	_, err := s.db.Exec(ctx, `
 		INSERT INTO user_providers(user_id, provider, subject, email)
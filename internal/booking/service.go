@@ -0,0 +1,712 @@
+package booking
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"upskill/internal/auth"
+	"upskill/internal/chat"
+	"upskill/internal/config"
+	"upskill/internal/web"
+)
+
+// Service manages mentor availability and one-on-one session booking.
+// Double booking is prevented by exclusion constraints on mentor_sessions;
+// slot checks here only make sure a booking matches the published schedule.
+type Service struct {
+	cfg  config.Config
+	db   *pgxpool.Pool
+	chat *chat.Service
+}
+
+func NewService(cfg config.Config, db *pgxpool.Pool, chatSvc *chat.Service) *Service {
+	return &Service{cfg: cfg, db: db, chat: chatSvc}
+}
+
+func (s *Service) GetAvailability(w http.ResponseWriter, r *http.Request) {
+	mid := auth.UserID(r)
+	tz, slotMin, err := s.settings(r.Context(), mid)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	rows, err := s.db.Query(r.Context(), `
+		SELECT weekday, to_char(start_time,'HH24:MI'), to_char(end_time,'HH24:MI')
+		FROM mentor_availability WHERE mentor_id=$1
+		ORDER BY weekday, start_time
+	`, mid)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	defer rows.Close()
+	type Win struct {
+		Weekday int    `json:"weekday"`
+		Start   string `json:"start"`
+		End     string `json:"end"`
+	}
+	weekly := []Win{}
+	for rows.Next() {
+		var it Win
+		if err := rows.Scan(&it.Weekday, &it.Start, &it.End); err == nil {
+			weekly = append(weekly, it)
+		}
+	}
+
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		loc = time.UTC
+	}
+	today := dayStart(time.Now(), loc)
+	exceptions, err := s.loadExceptions(r.Context(), mid, today, today.AddDate(1, 0, 0))
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	type Ex struct {
+		ID     int64  `json:"id"`
+		Date   string `json:"date"`
+		Kind   string `json:"kind"`
+		Start  string `json:"start,omitempty"`
+		End    string `json:"end,omitempty"`
+		Reason string `json:"reason,omitempty"`
+	}
+	exOut := []Ex{}
+	for _, list := range exceptions {
+		for _, ex := range list {
+			it := Ex{ID: ex.ID, Date: ex.Date, Kind: ex.Kind, Reason: ex.Reason}
+			if ex.Window != nil {
+				it.Start, it.End = formatClock(ex.Window.Start), formatClock(ex.Window.End)
+			}
+			exOut = append(exOut, it)
+		}
+	}
+	sort.Slice(exOut, func(i, j int) bool {
+		if exOut[i].Date != exOut[j].Date {
+			return exOut[i].Date < exOut[j].Date
+		}
+		return exOut[i].Start < exOut[j].Start
+	})
+	web.JSON(w, 200, map[string]any{
+		"timezone": tz, "slotMinutes": slotMin, "weekly": weekly, "exceptions": exOut,
+	})
+}
+
+// PutAvailability replaces the caller's weekly schedule.
+func (s *Service) PutAvailability(w http.ResponseWriter, r *http.Request) {
+	mid := auth.UserID(r)
+	if !s.isMentor(r.Context(), mid) {
+		http.Error(w, "forbidden", 403)
+		return
+	}
+	var in struct {
+		Timezone    string `json:"timezone"`
+		SlotMinutes int    `json:"slotMinutes"`
+		Weekly      []struct {
+			Weekday int    `json:"weekday"`
+			Start   string `json:"start"`
+			End     string `json:"end"`
+		} `json:"weekly"`
+	}
+	if err := web.DecodeJSON(r, &in); err != nil {
+		http.Error(w, "bad input", 400)
+		return
+	}
+	if in.Timezone == "" {
+		in.Timezone = "UTC"
+	}
+	if _, err := time.LoadLocation(in.Timezone); err != nil {
+		http.Error(w, "bad timezone", 400)
+		return
+	}
+	if in.SlotMinutes == 0 {
+		in.SlotMinutes = 60
+	}
+	if in.SlotMinutes < 15 || in.SlotMinutes > 240 {
+		http.Error(w, "slotMinutes must be between 15 and 240", 400)
+		return
+	}
+	type win struct {
+		day int
+		w   window
+	}
+	wins := make([]win, 0, len(in.Weekly))
+	for _, it := range in.Weekly {
+		st, err1 := parseClock(it.Start)
+		en, err2 := parseClock(it.End)
+		if err1 != nil || err2 != nil || it.Weekday < 0 || it.Weekday > 6 || en <= st {
+			http.Error(w, "bad weekly window", 400)
+			return
+		}
+		wins = append(wins, win{it.Weekday, window{st, en}})
+	}
+
+	tx, err := s.db.Begin(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	defer tx.Rollback(r.Context())
+	if _, err := tx.Exec(r.Context(), `
+		INSERT INTO mentor_schedules(mentor_id, timezone, slot_minutes)
+		VALUES($1,$2,$3)
+		ON CONFLICT (mentor_id) DO UPDATE SET timezone=EXCLUDED.timezone, slot_minutes=EXCLUDED.slot_minutes, updated_at=now()
+	`, mid, in.Timezone, in.SlotMinutes); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if _, err := tx.Exec(r.Context(), `DELETE FROM mentor_availability WHERE mentor_id=$1`, mid); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	for _, it := range wins {
+		if _, err := tx.Exec(r.Context(), `
+			INSERT INTO mentor_availability(mentor_id, weekday, start_time, end_time)
+			VALUES($1,$2,$3,$4)
+		`, mid, it.day, formatClock(it.w.Start), formatClock(it.w.End)); err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+	}
+	if err := tx.Commit(r.Context()); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	web.JSON(w, 200, map[string]any{"ok": true})
+}
+
+func (s *Service) AddException(w http.ResponseWriter, r *http.Request) {
+	mid := auth.UserID(r)
+	if !s.isMentor(r.Context(), mid) {
+		http.Error(w, "forbidden", 403)
+		return
+	}
+	var in struct {
+		Date   string `json:"date"`
+		Kind   string `json:"kind"`
+		Start  string `json:"start"`
+		End    string `json:"end"`
+		Reason string `json:"reason"`
+	}
+	if err := web.DecodeJSON(r, &in); err != nil {
+		http.Error(w, "bad input", 400)
+		return
+	}
+	if _, err := time.Parse("2006-01-02", in.Date); err != nil {
+		http.Error(w, "bad date", 400)
+		return
+	}
+	if in.Kind == "" {
+		in.Kind = "unavailable"
+	}
+	if in.Kind != "unavailable" && in.Kind != "available" {
+		http.Error(w, "kind must be unavailable or available", 400)
+		return
+	}
+	var start, end any
+	if in.Start != "" || in.End != "" {
+		st, err1 := parseClock(in.Start)
+		en, err2 := parseClock(in.End)
+		if err1 != nil || err2 != nil || en <= st {
+			http.Error(w, "bad time range", 400)
+			return
+		}
+		start, end = formatClock(st), formatClock(en)
+	} else if in.Kind == "available" {
+		http.Error(w, "available exceptions need start and end", 400)
+		return
+	}
+	var id int64
+	if err := s.db.QueryRow(r.Context(), `
+		INSERT INTO availability_exceptions(mentor_id, date, kind, start_time, end_time, reason)
+		VALUES($1,$2,$3,$4,$5,$6) RETURNING id
+	`, mid, in.Date, in.Kind, start, end, in.Reason).Scan(&id); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	web.JSON(w, 201, map[string]any{"id": id})
+}
+
+func (s *Service) DeleteException(w http.ResponseWriter, r *http.Request) {
+	mid := auth.UserID(r)
+	id, err := web.ParamInt64(r, "id")
+	if err != nil {
+		http.Error(w, "bad id", 400)
+		return
+	}
+	res, err := s.db.Exec(r.Context(), `DELETE FROM availability_exceptions WHERE id=$1 AND mentor_id=$2`, id, mid)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if res.RowsAffected() == 0 {
+		http.Error(w, "not found", 404)
+		return
+	}
+	web.JSON(w, 200, map[string]any{"ok": true})
+}
+
+// Slots lists bookable slots of a mentor. from/to are dates in the mentor's
+// timezone; the range defaults to the next two weeks.
+func (s *Service) Slots(w http.ResponseWriter, r *http.Request) {
+	mentorID, err := web.ParamInt64(r, "id")
+	if err != nil {
+		http.Error(w, "bad id", 400)
+		return
+	}
+	sc, err := s.loadSchedule(r.Context(), mentorID)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	from := dayStart(time.Now(), sc.Loc)
+	if v := r.URL.Query().Get("from"); v != "" {
+		d, err := time.ParseInLocation("2006-01-02", v, sc.Loc)
+		if err != nil {
+			http.Error(w, "bad from", 400)
+			return
+		}
+		from = d
+	}
+	to := from.AddDate(0, 0, 14)
+	if v := r.URL.Query().Get("to"); v != "" {
+		d, err := time.ParseInLocation("2006-01-02", v, sc.Loc)
+		if err != nil || !d.After(from) || d.After(from.AddDate(0, 0, 62)) {
+			http.Error(w, "bad to", 400)
+			return
+		}
+		to = d
+	}
+	slots, err := s.freeSlots(r.Context(), mentorID, sc, from, to, 0)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if slots == nil {
+		slots = []Slot{}
+	}
+	web.JSON(w, 200, map[string]any{
+		"mentorId": mentorID, "timezone": sc.Loc.String(), "slotMinutes": int(sc.SlotLen / time.Minute), "items": slots,
+	})
+}
+
+func (s *Service) Book(w http.ResponseWriter, r *http.Request) {
+	uid := auth.UserID(r)
+	var in struct {
+		MentorID int64  `json:"mentorId"`
+		Start    string `json:"start"`
+		Topic    string `json:"topic"`
+	}
+	if err := web.DecodeJSON(r, &in); err != nil || in.MentorID <= 0 {
+		http.Error(w, "bad input", 400)
+		return
+	}
+	start, err := time.Parse(time.RFC3339, in.Start)
+	if err != nil {
+		http.Error(w, "bad start", 400)
+		return
+	}
+	var mentorshipID int64
+	if err := s.db.QueryRow(r.Context(), `
+		SELECT id FROM mentorships WHERE student_id=$1 AND mentor_id=$2 AND status='active'
+	`, uid, in.MentorID).Scan(&mentorshipID); err != nil {
+		http.Error(w, "no active mentorship", 403)
+		return
+	}
+	slot, code, msg := s.checkSlot(r.Context(), in.MentorID, start, 0)
+	if code != 0 {
+		http.Error(w, msg, code)
+		return
+	}
+
+	var id int64
+	topic := strings.TrimSpace(in.Topic)
+	err = s.db.QueryRow(r.Context(), `
+		INSERT INTO mentor_sessions(mentorship_id, mentor_id, student_id, starts_at, ends_at, topic)
+		VALUES($1,$2,$3,$4,$5,$6) RETURNING id
+	`, mentorshipID, in.MentorID, uid, slot.Start, slot.End, topic).Scan(&id)
+	if err != nil {
+		if isOverlap(err) {
+			http.Error(w, "slot already booked", 409)
+			return
+		}
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if s.chat != nil {
+		meta := map[string]any{"sessionId": id, "mentorshipId": mentorshipID, "start": slot.Start.UTC(), "end": slot.End.UTC()}
+		if topic != "" {
+			meta["topic"] = topic
+		}
+		if _, err := s.chat.PostSystem(r.Context(), uid, in.MentorID, uid, chat.EventSessionBooked, "Session booked", meta); err != nil {
+			log.Printf("book session %d: system message: %v", id, err)
+		}
+	}
+	web.JSON(w, 201, map[string]any{"sessionId": id, "start": slot.Start.UTC(), "end": slot.End.UTC()})
+}
+
+// ListSessions returns sessions where the caller is the mentor or the
+// student. By default only sessions that have not ended yet are listed.
+func (s *Service) ListSessions(w http.ResponseWriter, r *http.Request) {
+	uid := auth.UserID(r)
+	from := time.Now()
+	if v := r.URL.Query().Get("from"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			http.Error(w, "bad from", 400)
+			return
+		}
+		from = t
+	}
+	status := web.QueryString(r, "status", "booked")
+	if status != "booked" && status != "cancelled" && status != "rescheduled" && status != "all" {
+		http.Error(w, "bad status", 400)
+		return
+	}
+	rows, err := s.db.Query(r.Context(), `
+		SELECT ms.id, ms.mentorship_id, ms.mentor_id, ms.student_id,
+		       CASE WHEN ms.mentor_id=$1 THEN COALESCE(su.first_name,'')||' '||COALESCE(su.last_name,'')
+		            ELSE COALESCE(mu.first_name,'')||' '||COALESCE(mu.last_name,'') END as peer,
+		       ms.starts_at, ms.ends_at, COALESCE(ms.topic,''), ms.status
+		FROM mentor_sessions ms
+		LEFT JOIN users su ON su.id = ms.student_id
+		LEFT JOIN users mu ON mu.id = ms.mentor_id
+		WHERE (ms.mentor_id=$1 OR ms.student_id=$1) AND ms.ends_at >= $2
+		  AND ($3 = 'all' OR ms.status = $3)
+		ORDER BY ms.starts_at
+		LIMIT 500
+	`, uid, from, status)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	defer rows.Close()
+	type Item struct {
+		ID           int64     `json:"id"`
+		MentorshipID int64     `json:"mentorshipId"`
+		MentorID     int64     `json:"mentorId"`
+		StudentID    int64     `json:"studentId"`
+		Peer         string    `json:"peer"`
+		Start        time.Time `json:"start"`
+		End          time.Time `json:"end"`
+		Topic        string    `json:"topic,omitempty"`
+		Status       string    `json:"status"`
+	}
+	var items []Item
+	for rows.Next() {
+		var it Item
+		if err := rows.Scan(&it.ID, &it.MentorshipID, &it.MentorID, &it.StudentID, &it.Peer, &it.Start, &it.End, &it.Topic, &it.Status); err == nil {
+			items = append(items, it)
+		}
+	}
+	web.JSON(w, 200, map[string]any{"items": items})
+}
+
+// Cancel cancels a booked session. Students must cancel at least the cut-off
+// before the start; mentors can cancel any session that has not started.
+func (s *Service) Cancel(w http.ResponseWriter, r *http.Request) {
+	uid := auth.UserID(r)
+	id, err := web.ParamInt64(r, "id")
+	if err != nil {
+		http.Error(w, "bad id", 400)
+		return
+	}
+	sess, err := s.loadSession(r.Context(), s.db, id, false)
+	if err != nil {
+		http.Error(w, "not found", 404)
+		return
+	}
+	if uid != sess.StudentID && uid != sess.MentorID {
+		http.Error(w, "forbidden", 403)
+		return
+	}
+	if sess.Status != "booked" {
+		http.Error(w, "bad state", 409)
+		return
+	}
+	if !time.Now().Before(sess.Start) {
+		http.Error(w, "session already started", 409)
+		return
+	}
+	if uid == sess.StudentID && time.Until(sess.Start) < s.cfg.CancelCutoff {
+		http.Error(w, "too late to cancel", 409)
+		return
+	}
+	res, err := s.db.Exec(r.Context(), `
+		UPDATE mentor_sessions SET status='cancelled', cancelled_by=$2, cancelled_at=now()
+		WHERE id=$1 AND status='booked'
+	`, id, uid)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if res.RowsAffected() == 0 {
+		http.Error(w, "bad state", 409)
+		return
+	}
+	web.JSON(w, 200, map[string]any{"ok": true})
+}
+
+// Reschedule moves a booked session to another free slot. Both parties are
+// bound by the cut-off; the old session is kept with status "rescheduled".
+func (s *Service) Reschedule(w http.ResponseWriter, r *http.Request) {
+	uid := auth.UserID(r)
+	id, err := web.ParamInt64(r, "id")
+	if err != nil {
+		http.Error(w, "bad id", 400)
+		return
+	}
+	var in struct {
+		Start string `json:"start"`
+	}
+	if err := web.DecodeJSON(r, &in); err != nil {
+		http.Error(w, "bad input", 400)
+		return
+	}
+	start, err := time.Parse(time.RFC3339, in.Start)
+	if err != nil {
+		http.Error(w, "bad start", 400)
+		return
+	}
+
+	tx, err := s.db.Begin(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	defer tx.Rollback(r.Context())
+
+	sess, err := s.loadSession(r.Context(), tx, id, true)
+	if err != nil {
+		http.Error(w, "not found", 404)
+		return
+	}
+	if uid != sess.StudentID && uid != sess.MentorID {
+		http.Error(w, "forbidden", 403)
+		return
+	}
+	if sess.Status != "booked" {
+		http.Error(w, "bad state", 409)
+		return
+	}
+	if time.Until(sess.Start) < s.cfg.CancelCutoff {
+		http.Error(w, "too late to reschedule", 409)
+		return
+	}
+	var active bool
+	if err := tx.QueryRow(r.Context(), `SELECT status='active' FROM mentorships WHERE id=$1`, sess.MentorshipID).Scan(&active); err != nil || !active {
+		http.Error(w, "no active mentorship", 403)
+		return
+	}
+	slot, code, msg := s.checkSlot(r.Context(), sess.MentorID, start, id)
+	if code != 0 {
+		http.Error(w, msg, code)
+		return
+	}
+
+	if _, err := tx.Exec(r.Context(), `
+		UPDATE mentor_sessions SET status='rescheduled', cancelled_by=$2, cancelled_at=now() WHERE id=$1
+	`, id, uid); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	var newID int64
+	err = tx.QueryRow(r.Context(), `
+		INSERT INTO mentor_sessions(mentorship_id, mentor_id, student_id, starts_at, ends_at, topic, rescheduled_from)
+		VALUES($1,$2,$3,$4,$5,$6,$7) RETURNING id
+	`, sess.MentorshipID, sess.MentorID, sess.StudentID, slot.Start, slot.End, sess.Topic, id).Scan(&newID)
+	if err != nil {
+		if isOverlap(err) {
+			http.Error(w, "slot already booked", 409)
+			return
+		}
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if err := tx.Commit(r.Context()); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	web.JSON(w, 200, map[string]any{"sessionId": newID, "start": slot.Start.UTC(), "end": slot.End.UTC()})
+}
+
+// --- helpers ---
+
+type session struct {
+	ID           int64
+	MentorshipID int64
+	MentorID     int64
+	StudentID    int64
+	Start, End   time.Time
+	Topic        string
+	Status       string
+}
+
+func (s *Service) loadSession(ctx context.Context, q pgxQuerier, id int64, lock bool) (session, error) {
+	sql := `
+		SELECT id, mentorship_id, mentor_id, student_id, starts_at, ends_at, COALESCE(topic,''), status
+		FROM mentor_sessions WHERE id=$1`
+	if lock {
+		sql += ` FOR UPDATE`
+	}
+	var ss session
+	err := q.QueryRow(ctx, sql, id).Scan(&ss.ID, &ss.MentorshipID, &ss.MentorID, &ss.StudentID, &ss.Start, &ss.End, &ss.Topic, &ss.Status)
+	return ss, err
+}
+
+type pgxQuerier interface {
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+// checkSlot makes sure start is a free slot of the mentor within the booking
+// window. ignoreSession lets a reschedule overlap the session being moved.
+func (s *Service) checkSlot(ctx context.Context, mentorID int64, start time.Time, ignoreSession int64) (Slot, int, string) {
+	now := time.Now()
+	if start.Before(now.Add(s.cfg.BookingNotice)) {
+		return Slot{}, 409, "too short notice"
+	}
+	if s.cfg.BookingHorizon > 0 && start.After(now.Add(s.cfg.BookingHorizon)) {
+		return Slot{}, 409, "too far ahead"
+	}
+	sc, err := s.loadSchedule(ctx, mentorID)
+	if err != nil {
+		return Slot{}, 500, err.Error()
+	}
+	day := dayStart(start, sc.Loc)
+	slots, err := s.freeSlots(ctx, mentorID, sc, day, day.AddDate(0, 0, 1), ignoreSession)
+	if err != nil {
+		return Slot{}, 500, err.Error()
+	}
+	for _, sl := range slots {
+		if sl.Start.Equal(start) {
+			return sl, 0, ""
+		}
+	}
+	return Slot{}, 409, "slot not available"
+}
+
+func (s *Service) freeSlots(ctx context.Context, mentorID int64, sc schedule, from, to time.Time, ignoreSession int64) ([]Slot, error) {
+	rows, err := s.db.Query(ctx, `
+		SELECT starts_at, ends_at FROM mentor_sessions
+		WHERE mentor_id=$1 AND status='booked' AND id<>$4
+		  AND tstzrange(starts_at, ends_at) && tstzrange($2, $3)
+	`, mentorID, from, to, ignoreSession)
+	if err != nil {
+		return nil, err
+	}
+	var busy []Slot
+	for rows.Next() {
+		var b Slot
+		if err := rows.Scan(&b.Start, &b.End); err == nil {
+			busy = append(busy, b)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if sc.Exceptions, err = s.loadExceptions(ctx, mentorID, from, to); err != nil {
+		return nil, err
+	}
+	return sc.freeSlots(from, to, time.Now().Add(s.cfg.BookingNotice), busy), nil
+}
+
+func (s *Service) settings(ctx context.Context, mentorID int64) (string, int, error) {
+	tz, slotMin := "UTC", 60
+	err := s.db.QueryRow(ctx, `SELECT timezone, slot_minutes FROM mentor_schedules WHERE mentor_id=$1`, mentorID).Scan(&tz, &slotMin)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return "", 0, err
+	}
+	return tz, slotMin, nil
+}
+
+func (s *Service) loadSchedule(ctx context.Context, mentorID int64) (schedule, error) {
+	tz, slotMin, err := s.settings(ctx, mentorID)
+	if err != nil {
+		return schedule{}, err
+	}
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		loc = time.UTC
+	}
+	sc := schedule{Loc: loc, SlotLen: time.Duration(slotMin) * time.Minute, Weekly: map[time.Weekday][]window{}}
+	// exceptions depend on the requested range and are loaded by freeSlots
+
+	rows, err := s.db.Query(ctx, `
+		SELECT weekday, to_char(start_time,'HH24:MI'), to_char(end_time,'HH24:MI')
+		FROM mentor_availability WHERE mentor_id=$1
+	`, mentorID)
+	if err != nil {
+		return sc, err
+	}
+	for rows.Next() {
+		var wd int
+		var st, en string
+		if err := rows.Scan(&wd, &st, &en); err != nil {
+			continue
+		}
+		a, err1 := parseClock(st)
+		b, err2 := parseClock(en)
+		if err1 == nil && err2 == nil {
+			sc.Weekly[time.Weekday(wd)] = append(sc.Weekly[time.Weekday(wd)], window{a, b})
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return sc, err
+	}
+	return sc, nil
+}
+
+func (s *Service) loadExceptions(ctx context.Context, mentorID int64, from, to time.Time) (map[string][]exception, error) {
+	rows, err := s.db.Query(ctx, `
+		SELECT id, to_char(date,'YYYY-MM-DD'), kind,
+		       COALESCE(to_char(start_time,'HH24:MI'),''), COALESCE(to_char(end_time,'HH24:MI'),''),
+		       COALESCE(reason,'')
+		FROM availability_exceptions
+		WHERE mentor_id=$1 AND date >= $2::date AND date < $3::date
+		ORDER BY date, start_time NULLS FIRST
+	`, mentorID, from.Format("2006-01-02"), to.Format("2006-01-02"))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	res := map[string][]exception{}
+	for rows.Next() {
+		var ex exception
+		var st, en string
+		if err := rows.Scan(&ex.ID, &ex.Date, &ex.Kind, &st, &en, &ex.Reason); err != nil {
+			continue
+		}
+		if st != "" {
+			a, err1 := parseClock(st)
+			b, err2 := parseClock(en)
+			if err1 != nil || err2 != nil {
+				continue
+			}
+			ex.Window = &window{a, b}
+		}
+		res[ex.Date] = append(res[ex.Date], ex)
+	}
+	return res, rows.Err()
+}
+
+func (s *Service) isMentor(ctx context.Context, uid int64) bool {
+	var ok bool
+	_ = s.db.QueryRow(ctx, `SELECT EXISTS(SELECT 1 FROM user_roles WHERE user_id=$1 AND role='mentor')`, uid).Scan(&ok)
+	return ok
+}
+
+func isOverlap(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23P01"
+}
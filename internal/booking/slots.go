@@ -0,0 +1,124 @@
+package booking
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// window is a time range within a day, in minutes after midnight.
+type window struct{ Start, End int }
+
+func (w window) overlaps(o window) bool { return w.Start < o.End && o.Start < w.End }
+
+type exception struct {
+	ID     int64
+	Date   string // 2006-01-02 in the mentor's timezone
+	Kind   string // unavailable | available
+	Window *window
+	Reason string
+}
+
+type Slot struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+}
+
+// schedule is a mentor's weekly availability with the exceptions that apply
+// to the requested range.
+type schedule struct {
+	Loc        *time.Location
+	SlotLen    time.Duration
+	Weekly     map[time.Weekday][]window
+	Exceptions map[string][]exception
+}
+
+// freeSlots cuts the schedule into slot-sized pieces for every local date in
+// [from, to), dropping slots blocked by an exception, overlapping a busy
+// range or starting before notBefore.
+func (sc schedule) freeSlots(from, to, notBefore time.Time, busy []Slot) []Slot {
+	slotMin := int(sc.SlotLen / time.Minute)
+	if slotMin <= 0 {
+		return nil
+	}
+	seen := map[int64]struct{}{}
+	var res []Slot
+	for d := from; d.Before(to); d = d.AddDate(0, 0, 1) {
+		key := d.Format("2006-01-02")
+		windows := append([]window(nil), sc.Weekly[d.Weekday()]...)
+		var blocked []window
+		wholeDay := false
+		for _, ex := range sc.Exceptions[key] {
+			switch {
+			case ex.Kind == "available" && ex.Window != nil:
+				windows = append(windows, *ex.Window)
+			case ex.Kind == "unavailable" && ex.Window == nil:
+				wholeDay = true
+			case ex.Kind == "unavailable":
+				blocked = append(blocked, *ex.Window)
+			}
+		}
+		if wholeDay {
+			continue
+		}
+		for _, w := range windows {
+			for m := w.Start; m+slotMin <= w.End; m += slotMin {
+				piece := window{m, m + slotMin}
+				if overlapsAny(piece, blocked) {
+					continue
+				}
+				st := time.Date(d.Year(), d.Month(), d.Day(), m/60, m%60, 0, 0, sc.Loc)
+				sl := Slot{Start: st, End: st.Add(sc.SlotLen)}
+				if sl.Start.Before(notBefore) || busyAt(sl, busy) {
+					continue
+				}
+				if _, dup := seen[st.Unix()]; dup {
+					continue
+				}
+				seen[st.Unix()] = struct{}{}
+				res = append(res, sl)
+			}
+		}
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Start.Before(res[j].Start) })
+	return res
+}
+
+func overlapsAny(w window, list []window) bool {
+	for _, o := range list {
+		if w.overlaps(o) {
+			return true
+		}
+	}
+	return false
+}
+
+func busyAt(sl Slot, busy []Slot) bool {
+	for _, b := range busy {
+		if sl.Start.Before(b.End) && b.Start.Before(sl.End) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseClock parses "HH:MM" into minutes after midnight; "24:00" is allowed
+// as an end of day.
+func parseClock(v string) (int, error) {
+	var h, m int
+	if _, err := fmt.Sscanf(v, "%d:%d", &h, &m); err != nil {
+		return 0, err
+	}
+	if h < 0 || m < 0 || m > 59 || h > 24 || (h == 24 && m != 0) {
+		return 0, fmt.Errorf("bad time %q", v)
+	}
+	return h*60 + m, nil
+}
+
+func formatClock(min int) string { return fmt.Sprintf("%02d:%02d", min/60, min%60) }
+
+// dayStart returns local midnight of t in loc.
+func dayStart(t time.Time, loc *time.Location) time.Time {
+	t = t.In(loc)
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)
+}
@@ -9,6 +9,7 @@ import (
 
 type Item struct {
 	ID    int64
+	UID   string // defaults to upskill-<ID>@upskill
 	Title string
 	Desc  string
 	Start time.Time
@@ -20,7 +21,11 @@ func BuildICS(items []Item) []byte {
 	b.WriteString("BEGIN:VCALENDAR\r\nVERSION:2.0\r\nPRODID:-//UpSkill//EN\r\n")
 	for _, t := range items {
 		b.WriteString("BEGIN:VEVENT\r\n")
-		b.WriteString("UID:upskill-" + strconv.FormatInt(t.ID, 10) + "@upskill\r\n")
+		uid := t.UID
+		if uid == "" {
+			uid = "upskill-" + strconv.FormatInt(t.ID, 10) + "@upskill"
+		}
+		b.WriteString("UID:" + uid + "\r\n")
 		b.WriteString("DTSTART:" + t.Start.UTC().Format("20060102T150405Z") + "\r\n")
 		b.WriteString("DTEND:" + t.End.UTC().Format("20060102T150405Z") + "\r\n")
 		b.WriteString("SUMMARY:" + escapeICS(t.Title) + "\r\n")
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
@@ -17,6 +18,7 @@ import (
 
 	"upskill/internal/auth"
 	"upskill/internal/config"
+	"upskill/internal/planner"
 	"upskill/internal/web"
 )
 
@@ -24,14 +26,16 @@ type Service struct {
 	cfg   config.Config
 	db    *pgxpool.Pool
 	auth  *auth.Service
+	plans *planner.Service
 	oauth *oauth2.Config
 }
 
-func NewService(cfg config.Config, db *pgxpool.Pool, authSvc *auth.Service) *Service {
+func NewService(cfg config.Config, db *pgxpool.Pool, authSvc *auth.Service, plans *planner.Service) *Service {
 	return &Service{
-		cfg:  cfg,
-		db:   db,
-		auth: authSvc,
+		cfg:   cfg,
+		db:    db,
+		auth:  authSvc,
+		plans: plans,
 		oauth: &oauth2.Config{
 			ClientID:     cfg.GoogleClientID,
 			ClientSecret: cfg.GoogleClientSecret,
@@ -142,32 +146,58 @@ func (s *Service) Sync(w http.ResponseWriter, r *http.Request) {
 	web.JSON(w, 200, map[string]any{"synced": true, "events": len(tasks)})
 }
 
+// ICS exports the caller's booked mentor sessions, plus the tasks of a plan
+// when planId is given. The plan may be the caller's own or one shared with
+// them.
 func (s *Service) ICS(w http.ResponseWriter, r *http.Request) {
 	uid := auth.UserID(r)
 	planIDStr := r.URL.Query().Get("planId")
-	if planIDStr == "" {
-		http.Error(w, "planId required", 400); return
-	}
-	var owner int64
-	if err := s.db.QueryRow(r.Context(), `SELECT user_id FROM plans WHERE id=$1`, planIDStr).Scan(&owner); err != nil {
-		http.Error(w, "not found", 404); return
+	var items []Item
+	if planIDStr != "" {
+		pid, err := strconv.ParseInt(planIDStr, 10, 64)
+		if err != nil { http.Error(w, "bad planId", 400); return }
+		ok, err := s.plans.CanView(r.Context(), uid, pid)
+		if err != nil { http.Error(w, err.Error(), 500); return }
+		if !ok { http.Error(w, "not found", 404); return }
+
+		rows, err := s.db.Query(r.Context(), `
+			SELECT id, title, COALESCE(description,''), start_time, end_time
+			FROM plan_tasks WHERE plan_id=$1 ORDER BY start_time ASC
+		`, pid)
+		if err != nil { http.Error(w, err.Error(), 500); return }
+		for rows.Next() {
+			var it Item
+			if err := rows.Scan(&it.ID, &it.Title, &it.Desc, &it.Start, &it.End); err == nil {
+				items = append(items, it)
+			}
+		}
+		rows.Close()
 	}
-	if owner != uid { http.Error(w, "forbidden", 403); return }
 
 	rows, err := s.db.Query(r.Context(), `
-		SELECT id, title, COALESCE(description,''), start_time, end_time
-		FROM plan_tasks WHERE plan_id=$1 ORDER BY start_time ASC
-	`, planIDStr)
+		SELECT ms.id, COALESCE(ms.topic,''),
+		       CASE WHEN ms.mentor_id=$1 THEN COALESCE(su.first_name,'')||' '||COALESCE(su.last_name,'')
+		            ELSE COALESCE(mu.first_name,'')||' '||COALESCE(mu.last_name,'') END,
+		       ms.starts_at, ms.ends_at
+		FROM mentor_sessions ms
+		LEFT JOIN users su ON su.id = ms.student_id
+		LEFT JOIN users mu ON mu.id = ms.mentor_id
+		WHERE (ms.mentor_id=$1 OR ms.student_id=$1) AND ms.status='booked'
+		ORDER BY ms.starts_at ASC
+	`, uid)
 	if err != nil { http.Error(w, err.Error(), 500); return }
 	defer rows.Close()
-
-	var items []Item
 	for rows.Next() {
 		var it Item
-		if err := rows.Scan(&it.ID, &it.Title, &it.Desc, &it.Start, &it.End); err == nil {
+		var topic, peer string
+		if err := rows.Scan(&it.ID, &topic, &peer, &it.Start, &it.End); err == nil {
+			it.UID = "upskill-session-" + strconv.FormatInt(it.ID, 10) + "@upskill"
+			it.Title = "Mentoring session with " + strings.TrimSpace(peer)
+			it.Desc = topic
 			items = append(items, it)
 		}
 	}
+
 	ics := BuildICS(items)
 	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
 	w.Header().Set("Content-Disposition", "attachment; filename=upskill.ics")
@@ -0,0 +1,453 @@
+package chat
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+
+	"upskill/internal/auth"
+	"upskill/internal/storage"
+	"upskill/internal/web"
+)
+
+// Attachments are uploaded to a conversation first and then attached to a
+// message by id ("attachmentIds" on send). The content type is sniffed from
+// the data, never taken from the client. Images get a JPEG thumbnail.
+//
+// Message frames carry attachment metadata only. Downloads go through
+// short-lived URLs signed for one member, available from
+// GET /chat/attachments/{id}/url and in History; the download endpoint
+// checks the signature and that the user is still a member.
+
+const (
+	maxAttachments = 10
+	thumbMaxSide   = 320
+	maxImagePixels = 40_000_000
+	maxFilenameLen = 255
+	uploadOverhead = 64 << 10
+)
+
+// uploadTypes are the sniffed content types accepted for upload.
+var uploadTypes = map[string]bool{
+	"image/png":                 true,
+	"image/jpeg":                true,
+	"image/gif":                 true,
+	"image/webp":                true,
+	"application/pdf":           true,
+	"application/zip":           true,
+	"application/x-gzip":        true,
+	"text/plain; charset=utf-8": true,
+}
+
+var errBadAttachments = invalidFrame("unknown or already used attachment")
+
+// checkAttachmentIDs checks the ids sent with a message and drops repeats.
+func checkAttachmentIDs(ids []int64) ([]int64, error) {
+	if len(ids) > maxAttachments {
+		return nil, invalidFrame("too many attachments")
+	}
+	seen := make(map[int64]bool, len(ids))
+	var res []int64
+	for _, id := range ids {
+		if id <= 0 {
+			return nil, invalidFrame("bad attachment id")
+		}
+		if !seen[id] {
+			seen[id] = true
+			res = append(res, id)
+		}
+	}
+	return res, nil
+}
+
+type attachment struct {
+	ID           int64  `json:"id"`
+	Filename     string `json:"filename"`
+	ContentType  string `json:"contentType"`
+	Size         int64  `json:"size"`
+	Width        *int   `json:"width,omitempty"`
+	Height       *int   `json:"height,omitempty"`
+	HasThumbnail bool   `json:"hasThumbnail"`
+	URL          string `json:"url,omitempty"`
+	ThumbnailURL string `json:"thumbnailUrl,omitempty"`
+	messageID    int64
+}
+
+const attachmentCols = `a.id, a.filename, a.content_type, a.size_bytes, a.width, a.height, a.thumb_key IS NOT NULL, COALESCE(a.message_id,0)`
+
+func scanAttachment(row pgx.Row) (attachment, error) {
+	var a attachment
+	err := row.Scan(&a.ID, &a.Filename, &a.ContentType, &a.Size, &a.Width, &a.Height, &a.HasThumbnail, &a.messageID)
+	return a, err
+}
+
+// sniffType returns the content type stored for data. Markup is kept as
+// plain text so shared source files are never rendered by the browser.
+func sniffType(data []byte) string {
+	ct := http.DetectContentType(data)
+	if strings.HasPrefix(ct, "text/html") || strings.HasPrefix(ct, "text/xml") {
+		return "text/plain; charset=utf-8"
+	}
+	return ct
+}
+
+func cleanFilename(name string) string {
+	name = strings.TrimSpace(filepath.Base(strings.ReplaceAll(name, "\\", "/")))
+	name = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f || r == '"' {
+			return -1
+		}
+		return r
+	}, name)
+	if name == "" || name == "." || name == "/" {
+		return "file"
+	}
+	for utf8.RuneCountInString(name) > maxFilenameLen {
+		_, size := utf8.DecodeLastRuneInString(name)
+		name = name[:len(name)-size]
+	}
+	return name
+}
+
+// thumbnail scales an image to fit thumbMaxSide, averaging the source
+// pixels behind each thumbnail pixel, and returns it with the size of the
+// original. ok is false for data that is not a decodable image or is too
+// large to decode safely.
+func thumbnail(data []byte) (thumb []byte, width, height int, ok bool) {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil || cfg.Width <= 0 || cfg.Height <= 0 || cfg.Width*cfg.Height > maxImagePixels {
+		return nil, 0, 0, false
+	}
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, 0, 0, false
+	}
+	b := src.Bounds()
+	tw, th := b.Dx(), b.Dy()
+	if tw > thumbMaxSide || th > thumbMaxSide {
+		if tw >= th {
+			tw, th = thumbMaxSide, max(1, th*thumbMaxSide/b.Dx())
+		} else {
+			tw, th = max(1, tw*thumbMaxSide/b.Dy()), thumbMaxSide
+		}
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, tw, th))
+	for y := 0; y < th; y++ {
+		y0, y1 := b.Min.Y+y*b.Dy()/th, b.Min.Y+max((y+1)*b.Dy()/th, y*b.Dy()/th+1)
+		for x := 0; x < tw; x++ {
+			x0, x1 := b.Min.X+x*b.Dx()/tw, b.Min.X+max((x+1)*b.Dx()/tw, x*b.Dx()/tw+1)
+			var r, g, bl, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					c := color.RGBA64Model.Convert(src.At(sx, sy)).(color.RGBA64)
+					r, g, bl, a, n = r+uint64(c.R), g+uint64(c.G), bl+uint64(c.B), a+uint64(c.A), n+1
+				}
+			}
+			dst.SetRGBA64(x, y, color.RGBA64{uint16(r / n), uint16(g / n), uint16(bl / n), uint16(a / n)})
+		}
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, dst, &jpeg.Options{Quality: 80}); err != nil {
+		return nil, 0, 0, false
+	}
+	return buf.Bytes(), b.Dx(), b.Dy(), true
+}
+
+// UploadAttachment stores the multipart "file" field for a later message in
+// the conversation.
+func (s *Service) UploadAttachment(w http.ResponseWriter, r *http.Request) {
+	uid := auth.UserID(r)
+	convID, err := web.ParamInt64(r, "id")
+	if err != nil {
+		http.Error(w, "bad id", 400)
+		return
+	}
+	conv, err := s.getConversation(r.Context(), convID)
+	if err != nil {
+		http.Error(w, "not found", 404)
+		return
+	}
+	if conv.authorType(uid) == "" {
+		http.Error(w, "forbidden", 403)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, s.maxUpload+uploadOverhead)
+	mr, err := r.MultipartReader()
+	if err != nil {
+		http.Error(w, "multipart body required", 400)
+		return
+	}
+	var name string
+	var data []byte
+	for {
+		part, err := mr.NextPart()
+		if err != nil {
+			var tooBig *http.MaxBytesError
+			if errors.As(err, &tooBig) {
+				http.Error(w, "file too large", 413)
+			} else {
+				http.Error(w, "file required", 400)
+			}
+			return
+		}
+		if part.FormName() != "file" {
+			continue
+		}
+		name = cleanFilename(part.FileName())
+		data, err = io.ReadAll(io.LimitReader(part, s.maxUpload+1))
+		if err != nil {
+			http.Error(w, "file too large", 413)
+			return
+		}
+		break
+	}
+	if int64(len(data)) > s.maxUpload {
+		http.Error(w, "file too large", 413)
+		return
+	}
+	if len(data) == 0 {
+		http.Error(w, "empty file", 400)
+		return
+	}
+	ctype := sniffType(data)
+	if !uploadTypes[ctype] {
+		http.Error(w, "unsupported file type "+ctype, 415)
+		return
+	}
+
+	ctx := r.Context()
+	key := fmt.Sprintf("attachments/%d/%s", convID, uuid.NewString())
+	if err := s.store.Put(ctx, key, bytes.NewReader(data), int64(len(data)), ctype); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	var thumbKey *string
+	var width, height *int
+	if strings.HasPrefix(ctype, "image/") {
+		if thumb, tw, th, ok := thumbnail(data); ok {
+			k := key + ".thumb.jpg"
+			if err := s.store.Put(ctx, k, bytes.NewReader(thumb), int64(len(thumb)), "image/jpeg"); err != nil {
+				log.Printf("chat: thumbnail %s: %v", k, err)
+			} else {
+				thumbKey = &k
+			}
+			width, height = &tw, &th
+		}
+	}
+
+	a, err := scanAttachment(s.db.QueryRow(ctx, `
+		INSERT INTO attachments AS a(conversation_id, uploader_id, filename, content_type, size_bytes, storage_key, thumb_key, width, height)
+		VALUES($1,$2,$3,$4,$5,$6,$7,$8,$9)
+		RETURNING `+attachmentCols,
+		convID, uid, name, ctype, len(data), key, thumbKey, width, height))
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	s.signAttachment(&a, uid, time.Now())
+	web.JSON(w, 200, a)
+}
+
+// attachToMessage links the caller's unused uploads to a new message.
+func attachToMessage(ctx context.Context, tx pgx.Tx, convID, msgID, uid int64, ids []int64) ([]attachment, error) {
+	rows, err := tx.Query(ctx, `
+		UPDATE attachments a SET message_id=$1
+		WHERE a.id = ANY($2) AND a.conversation_id=$3 AND a.uploader_id=$4 AND a.message_id IS NULL
+		RETURNING `+attachmentCols, msgID, ids, convID, uid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var res []attachment
+	for rows.Next() {
+		a, err := scanAttachment(rows)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, a)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(res) != len(ids) {
+		return nil, errBadAttachments
+	}
+	return res, nil
+}
+
+// messageAttachments returns the attachments of the given messages, keyed
+// by message id. Deleted messages have none.
+func (s *Service) messageAttachments(ctx context.Context, msgIDs []int64) (map[int64][]attachment, error) {
+	res := map[int64][]attachment{}
+	if len(msgIDs) == 0 {
+		return res, nil
+	}
+	rows, err := s.db.Query(ctx, `
+		SELECT `+attachmentCols+`
+		FROM attachments a JOIN messages m ON m.id = a.message_id
+		WHERE a.message_id = ANY($1) AND m.deleted_at IS NULL
+		ORDER BY a.id
+	`, msgIDs)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		a, err := scanAttachment(rows)
+		if err != nil {
+			return nil, err
+		}
+		res[a.messageID] = append(res[a.messageID], a)
+	}
+	return res, rows.Err()
+}
+
+// withAttachments adds attachment metadata to a message frame.
+func withAttachments(f map[string]any, atts []attachment) map[string]any {
+	if len(atts) > 0 {
+		f["attachments"] = atts
+	}
+	return f
+}
+
+func (s *Service) urlSig(id, uid int64, variant string, exp int64) string {
+	h := hmac.New(sha256.New, s.urlKey)
+	fmt.Fprintf(h, "%d|%d|%s|%d", id, uid, variant, exp)
+	return base64.RawURLEncoding.EncodeToString(h.Sum(nil))
+}
+
+func (s *Service) signedURL(id, uid int64, variant string, exp int64) string {
+	q := fmt.Sprintf("u=%d&exp=%d&sig=%s", uid, exp, s.urlSig(id, uid, variant, exp))
+	if variant != "" {
+		q += "&variant=" + variant
+	}
+	return fmt.Sprintf("/api/chat/attachments/%d/download?%s", id, q)
+}
+
+// signAttachment fills in the download URLs of a for uid.
+func (s *Service) signAttachment(a *attachment, uid int64, now time.Time) {
+	exp := now.Add(s.urlTTL).Unix()
+	a.URL = s.signedURL(a.ID, uid, "", exp)
+	if a.HasThumbnail {
+		a.ThumbnailURL = s.signedURL(a.ID, uid, "thumb", exp)
+	}
+}
+
+// AttachmentURL issues fresh download URLs to a conversation member.
+func (s *Service) AttachmentURL(w http.ResponseWriter, r *http.Request) {
+	uid := auth.UserID(r)
+	id, err := web.ParamInt64(r, "id")
+	if err != nil {
+		http.Error(w, "bad id", 400)
+		return
+	}
+	var a attachment
+	var convID int64
+	err = s.db.QueryRow(r.Context(), `
+		SELECT `+attachmentCols+`, a.conversation_id
+		FROM attachments a LEFT JOIN messages m ON m.id = a.message_id
+		WHERE a.id=$1 AND m.deleted_at IS NULL
+	`, id).Scan(&a.ID, &a.Filename, &a.ContentType, &a.Size, &a.Width, &a.Height, &a.HasThumbnail, &a.messageID, &convID)
+	if err != nil {
+		http.Error(w, "not found", 404)
+		return
+	}
+	if ok, _ := s.isMember(r, convID, uid); !ok {
+		http.Error(w, "forbidden", 403)
+		return
+	}
+	now := time.Now()
+	s.signAttachment(&a, uid, now)
+	web.JSON(w, 200, map[string]any{"url": a.URL, "thumbnailUrl": a.ThumbnailURL, "expiresAt": now.Add(s.urlTTL).UTC()})
+}
+
+// DownloadAttachment serves a signed URL. It is mounted outside the JWT
+// group so the URLs work in <img> tags and plain links.
+func (s *Service) DownloadAttachment(w http.ResponseWriter, r *http.Request) {
+	id, err := web.ParamInt64(r, "id")
+	if err != nil {
+		http.Error(w, "bad id", 400)
+		return
+	}
+	q := r.URL.Query()
+	uid, _ := strconv.ParseInt(q.Get("u"), 10, 64)
+	exp, _ := strconv.ParseInt(q.Get("exp"), 10, 64)
+	variant := q.Get("variant")
+	if !hmac.Equal([]byte(q.Get("sig")), []byte(s.urlSig(id, uid, variant, exp))) {
+		http.Error(w, "forbidden", 403)
+		return
+	}
+	if time.Now().Unix() > exp {
+		http.Error(w, "link expired", 410)
+		return
+	}
+
+	var convID, size int64
+	var key, ctype, name string
+	var thumbKey *string
+	err = s.db.QueryRow(r.Context(), `
+		SELECT a.conversation_id, a.storage_key, a.thumb_key, a.content_type, a.filename, a.size_bytes
+		FROM attachments a LEFT JOIN messages m ON m.id = a.message_id
+		WHERE a.id=$1 AND m.deleted_at IS NULL
+	`, id).Scan(&convID, &key, &thumbKey, &ctype, &name, &size)
+	if err != nil {
+		http.Error(w, "not found", 404)
+		return
+	}
+	if ok, _ := s.isMember(r, convID, uid); !ok {
+		http.Error(w, "forbidden", 403)
+		return
+	}
+	disposition := "attachment"
+	if variant == "thumb" {
+		if thumbKey == nil {
+			http.Error(w, "not found", 404)
+			return
+		}
+		key, ctype, size, disposition = *thumbKey, "image/jpeg", -1, "inline"
+	} else if strings.HasPrefix(ctype, "image/") {
+		disposition = "inline"
+	}
+	body, err := s.store.Open(r.Context(), key)
+	if errors.Is(err, storage.ErrNotFound) {
+		http.Error(w, "not found", 404)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	defer body.Close()
+
+	h := w.Header()
+	h.Set("Content-Type", ctype)
+	h.Set("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": name}))
+	h.Set("X-Content-Type-Options", "nosniff")
+	h.Set("Cache-Control", "private, max-age="+strconv.FormatInt(exp-time.Now().Unix(), 10))
+	if size >= 0 {
+		h.Set("Content-Length", strconv.FormatInt(size, 10))
+	}
+	_, _ = io.Copy(w, body)
+}
@@ -0,0 +1,41 @@
+package chat
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+func TestThumbnail(t *testing.T) {
+	var src bytes.Buffer
+	_ = png.Encode(&src, image.NewRGBA(image.Rect(0, 0, 1000, 500)))
+	thumb, w, h, ok := thumbnail(src.Bytes())
+	if !ok || w != 1000 || h != 500 {
+		t.Fatalf("thumbnail: ok=%v size=%dx%d", ok, w, h)
+	}
+	img, err := jpeg.Decode(bytes.NewReader(thumb))
+	if err != nil {
+		t.Fatalf("decode thumbnail: %v", err)
+	}
+	if b := img.Bounds(); b.Dx() != thumbMaxSide || b.Dy() != thumbMaxSide/2 {
+		t.Fatalf("thumbnail is %v", b)
+	}
+	if _, _, _, ok := thumbnail([]byte("not an image")); ok {
+		t.Fatal("thumbnail of text")
+	}
+}
+
+func TestSniffType(t *testing.T) {
+	cases := map[string]string{
+		"package main\n\nfunc main() {}\n":  "text/plain; charset=utf-8",
+		"<!DOCTYPE html><script>x</script>": "text/plain; charset=utf-8",
+		"%PDF-1.7\n":                        "application/pdf",
+	}
+	for in, want := range cases {
+		if got := sniffType([]byte(in)); got != want {
+			t.Errorf("sniffType(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
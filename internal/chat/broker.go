@@ -0,0 +1,174 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Broker carries room broadcasts between API instances. Publish must reach
+// the subscribers of every instance, including the publishing one: the hub
+// fans out to its local sockets only from what the broker delivers.
+type Broker interface {
+	Publish(ctx context.Context, room string, payload []byte) error
+	Subscribe(deliver func(room string, payload []byte))
+}
+
+// MemoryBroker connects hubs within one process. It is the broker for
+// single-instance setups and lets tests run several hubs side by side.
+type MemoryBroker struct {
+	mu   sync.RWMutex
+	subs []func(room string, payload []byte)
+}
+
+func NewMemoryBroker() *MemoryBroker { return &MemoryBroker{} }
+
+func (b *MemoryBroker) Publish(_ context.Context, room string, payload []byte) error {
+	b.mu.RLock()
+	subs := b.subs
+	b.mu.RUnlock()
+	for _, deliver := range subs {
+		deliver(room, payload)
+	}
+	return nil
+}
+
+func (b *MemoryBroker) Subscribe(deliver func(room string, payload []byte)) {
+	b.mu.Lock()
+	b.subs = append(b.subs, deliver)
+	b.mu.Unlock()
+}
+
+// pgChannel is the LISTEN/NOTIFY channel shared by all instances.
+const pgChannel = "chat_broadcast"
+
+// pgInlineLimit keeps notifications under Postgres' 8000 byte payload cap
+// with room for the envelope. Larger payloads are stored in
+// chat_broker_payloads and the notification carries only their id.
+const pgInlineLimit = 7000
+
+// pgPayloadTTL is how long stored payloads are kept for listeners to fetch.
+const pgPayloadTTL = 5 * time.Minute
+
+type pgEnvelope struct {
+	Room    string          `json:"r"`
+	Payload json.RawMessage `json:"p,omitempty"`
+	Ref     int64           `json:"ref,omitempty"`
+}
+
+// PGBroker fans out through Postgres LISTEN/NOTIFY on the application pool.
+// One pooled connection is held for LISTEN and re-established if it drops.
+type PGBroker struct {
+	db   *pgxpool.Pool
+	mu   sync.RWMutex
+	subs []func(room string, payload []byte)
+}
+
+// NewPGBroker starts listening until ctx is done.
+func NewPGBroker(ctx context.Context, db *pgxpool.Pool) *PGBroker {
+	b := &PGBroker{db: db}
+	go b.listen(ctx)
+	return b
+}
+
+func (b *PGBroker) Subscribe(deliver func(room string, payload []byte)) {
+	b.mu.Lock()
+	b.subs = append(b.subs, deliver)
+	b.mu.Unlock()
+}
+
+func (b *PGBroker) Publish(ctx context.Context, room string, payload []byte) error {
+	env := pgEnvelope{Room: room, Payload: payload}
+	if len(payload) > pgInlineLimit {
+		if err := b.db.QueryRow(ctx, `
+			INSERT INTO chat_broker_payloads(room, payload) VALUES($1,$2) RETURNING id
+		`, room, payload).Scan(&env.Ref); err != nil {
+			return err
+		}
+		env.Payload = nil
+	}
+	msg, err := json.Marshal(env)
+	if err != nil {
+		return err
+	}
+	_, err = b.db.Exec(ctx, `SELECT pg_notify($1, $2)`, pgChannel, string(msg))
+	return err
+}
+
+func (b *PGBroker) listen(ctx context.Context) {
+	cleanup := time.NewTicker(pgPayloadTTL)
+	defer cleanup.Stop()
+	listenPG(ctx, b.db, pgChannel, "chat broker", func(msg string) {
+		var env pgEnvelope
+		if err := json.Unmarshal([]byte(msg), &env); err != nil {
+			log.Printf("chat broker: bad notification: %v", err)
+			return
+		}
+		payload := []byte(env.Payload)
+		if env.Ref != 0 {
+			if err := b.db.QueryRow(ctx, `SELECT payload FROM chat_broker_payloads WHERE id=$1`, env.Ref).Scan(&payload); err != nil {
+				log.Printf("chat broker: payload %d: %v", env.Ref, err)
+				return
+			}
+		}
+		b.mu.RLock()
+		subs := b.subs
+		b.mu.RUnlock()
+		for _, deliver := range subs {
+			deliver(env.Room, payload)
+		}
+		select {
+		case <-cleanup.C:
+			_, _ = b.db.Exec(ctx, `
+				DELETE FROM chat_broker_payloads WHERE created_at < now() - make_interval(secs => $1)
+			`, pgPayloadTTL.Seconds())
+		default:
+		}
+	})
+}
+
+// listenPG hands the notifications on channel to handle until ctx is done,
+// re-establishing the LISTEN connection when it drops. name prefixes its
+// log lines.
+func listenPG(ctx context.Context, db *pgxpool.Pool, channel, name string, handle func(payload string)) {
+	backoff := time.Second
+	for ctx.Err() == nil {
+		err := listenOnce(ctx, db, channel, handle)
+		if ctx.Err() != nil {
+			return
+		}
+		log.Printf("%s: listen: %v; retrying in %v", name, err, backoff)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		if backoff < 30*time.Second {
+			backoff *= 2
+		}
+	}
+}
+
+func listenOnce(ctx context.Context, db *pgxpool.Pool, channel string, handle func(payload string)) error {
+	pooled, err := db.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	// A connection that was LISTENing must not go back to the pool.
+	conn := pooled.Hijack()
+	defer conn.Close(context.Background())
+	if _, err := conn.Exec(ctx, `LISTEN `+channel); err != nil {
+		return err
+	}
+	for {
+		n, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+		handle(n.Payload)
+	}
+}
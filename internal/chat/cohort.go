@@ -0,0 +1,161 @@
+package chat
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"upskill/internal/auth"
+	"upskill/internal/web"
+)
+
+// Cohort rooms: every cohort mentor and enrolled student can read and post.
+// Messages can @mention other cohort members; see mentions.go.
+
+func (s *Service) isCohortMember(ctx context.Context, cohortID, uid int64) (bool, error) {
+	var ok bool
+	err := s.db.QueryRow(ctx, `
+		SELECT EXISTS(SELECT 1 FROM cohort_mentors WHERE cohort_id=$1 AND mentor_id=$2)
+		    OR EXISTS(SELECT 1 FROM cohort_members WHERE cohort_id=$1 AND student_id=$2 AND status='enrolled')
+	`, cohortID, uid).Scan(&ok)
+	return ok, err
+}
+
+func (s *Service) CohortHistory(w http.ResponseWriter, r *http.Request) {
+	uid := auth.UserID(r)
+	cohortID, err := web.ParamInt64(r, "id")
+	if err != nil {
+		http.Error(w, "bad id", 400)
+		return
+	}
+	if ok, _ := s.isCohortMember(r.Context(), cohortID, uid); !ok {
+		http.Error(w, "forbidden", 403)
+		return
+	}
+	p, err := parsePage(r)
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	args := web.Args{cohortID}
+	rows, err := s.db.Query(r.Context(), `
+		SELECT cm.id, cm.author_id, COALESCE(u.first_name,'')||' '||COALESCE(u.last_name,'') as author,
+		       cm.body, cm.created_at
+		FROM cohort_messages cm
+		LEFT JOIN users u ON u.id = cm.author_id
+		WHERE cm.cohort_id=$1`+p.clause("cm.id", &args), args...)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	defer rows.Close()
+	type Msg struct {
+		ID        int64     `json:"id"`
+		AuthorID  int64     `json:"authorId"`
+		Author    string    `json:"author"`
+		Body      string    `json:"body"`
+		CreatedAt time.Time `json:"createdAt"`
+		Mentions  []mention `json:"mentions,omitempty"`
+	}
+	items := []Msg{}
+	for rows.Next() {
+		var m Msg
+		if err := rows.Scan(&m.ID, &m.AuthorID, &m.Author, &m.Body, &m.CreatedAt); err == nil {
+			items = append(items, m)
+		}
+	}
+	items, more := finishPage(p, items, func(m Msg) int64 { return m.ID })
+	ids := make([]int64, len(items))
+	for i, m := range items {
+		ids[i] = m.ID
+	}
+	byMsg, err := s.messageMentions(r.Context(), "cohort", ids)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	for i := range items {
+		items[i].Mentions = byMsg[items[i].ID]
+	}
+	web.JSON(w, 200, map[string]any{"items": items, "hasMore": more})
+}
+
+func (s *Service) CohortPost(w http.ResponseWriter, r *http.Request) {
+	uid := auth.UserID(r)
+	cohortID, err := web.ParamInt64(r, "id")
+	if err != nil {
+		http.Error(w, "bad id", 400)
+		return
+	}
+	if ok, _ := s.isCohortMember(r.Context(), cohortID, uid); !ok {
+		http.Error(w, "forbidden", 403)
+		return
+	}
+	var in struct {
+		Body string `json:"body"`
+	}
+	if err := web.DecodeJSON(r, &in); err != nil || in.Body == "" {
+		http.Error(w, "bad body", 400)
+		return
+	}
+	tx, err := s.db.Begin(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	defer tx.Rollback(r.Context())
+	var id int64
+	if err := tx.QueryRow(r.Context(), `
+		INSERT INTO cohort_messages(cohort_id, author_id, body) VALUES($1,$2,$3) RETURNING id
+	`, cohortID, uid, in.Body).Scan(&id); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	ref := mentionRef{scope: "cohort", cohortID: cohortID, messageID: id}
+	ms, notified, err := s.saveMentions(r.Context(), tx, ref, uid, in.Body)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if err := tx.Commit(r.Context()); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	payload := withMentions(map[string]any{
+		"type": "message", "scope": "cohort", "cohortId": cohortID, "id": id, "authorId": uid, "body": in.Body, "createdAt": time.Now().UTC(),
+	}, ms)
+	s.hub.Broadcast(cohortRoom(cohortID), payload)
+	s.pushMentions(ref, uid, in.Body, notified)
+	web.JSON(w, 200, payload)
+}
+
+func (s *Service) CohortWS(w http.ResponseWriter, r *http.Request) {
+	uid := auth.UserID(r)
+	cohortID, err := web.ParamInt64(r, "id")
+	if err != nil {
+		http.Error(w, "bad id", 400)
+		return
+	}
+	if ok, _ := s.isCohortMember(r.Context(), cohortID, uid); !ok {
+		http.Error(w, "forbidden", 403)
+		return
+	}
+	conn, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	c := newClient(conn, uid)
+	room := cohortRoom(cohortID)
+	s.hub.Join(room, c)
+	defer func() { s.hub.Leave(room, c); c.close() }()
+
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			return
+		}
+	}
+}
+
+func cohortRoom(cohortID int64) string { return "cohort:" + strconv.FormatInt(cohortID, 10) }
@@ -0,0 +1,428 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	"upskill/internal/auth"
+	"upskill/internal/web"
+)
+
+// Edits and deletes. Authors can edit a message within the edit window; the
+// body it replaces is kept in message_revisions. Deleting is soft: the row
+// stays so ids and cursors remain stable, its body moves to
+// message_revisions and clients render it as "message deleted". Moderators
+// can delete any message. Both are broadcast to the message's room:
+//
+//	{"v":1,"type":"edited","conversationId":3,"id":7,"body":"...","editedAt":"...","editedBy":1}
+//	{"v":1,"type":"deleted","conversationId":3,"id":7,"deletedAt":"...","deletedBy":1}
+//
+// Global messages carry "scope":"global" instead of a conversationId.
+
+var (
+	errMessageNotFound = &frameError{codeNotFound, "message not found"}
+	errNotAuthor       = &frameError{codeForbidden, "only the author can edit a message"}
+	errCannotDelete    = &frameError{codeForbidden, "only the author or a moderator can delete a message"}
+	errEditWindow      = &frameError{codeConflict, "edit window has passed"}
+	errMessageDeleted  = &frameError{codeConflict, "message deleted"}
+)
+
+// msgScope is the table a message lives in and the room its events go to.
+type msgScope struct {
+	name   string // message_revisions.scope
+	table  string
+	room   string
+	convID int64
+}
+
+var globalScope = msgScope{name: "global", table: "global_messages", room: "global"}
+
+func convScope(convID int64) msgScope {
+	return msgScope{name: "conversation", table: "messages", room: roomName(convID), convID: convID}
+}
+
+// where matches message id within sc.
+func (sc msgScope) where(id int64) (string, []any) {
+	if sc.convID != 0 {
+		return ` WHERE id=$1 AND conversation_id=$2`, []any{id, sc.convID}
+	}
+	return ` WHERE id=$1`, []any{id}
+}
+
+// lookup selects cols of message id in sc, locking the row.
+func (sc msgScope) lookup(ctx context.Context, tx pgx.Tx, id int64, cols string, dest ...any) error {
+	where, args := sc.where(id)
+	err := tx.QueryRow(ctx, `SELECT `+cols+` FROM `+sc.table+where+` FOR UPDATE`, args...).Scan(dest...)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return errMessageNotFound
+	}
+	return err
+}
+
+// checkNotSystem rejects changes to a system message; see system.go.
+func (sc msgScope) checkNotSystem(ctx context.Context, tx pgx.Tx, id int64) error {
+	if sc.convID == 0 {
+		return nil
+	}
+	var system bool
+	if err := tx.QueryRow(ctx, `SELECT author_type='system' FROM messages WHERE id=$1`, id).Scan(&system); err != nil {
+		return err
+	}
+	if system {
+		return errSystemMessage
+	}
+	return nil
+}
+
+func (sc msgScope) event(typ string, id int64) map[string]any {
+	f := map[string]any{"v": ProtocolVersion, "type": typ, "id": id}
+	if sc.convID != 0 {
+		f["conversationId"] = sc.convID
+	} else {
+		f["scope"] = sc.name
+	}
+	return f
+}
+
+// threadEvent is sc.event for a message that may be a reply in thread
+// root; replies carry the parentId.
+func (sc msgScope) threadEvent(typ string, id, root int64) map[string]any {
+	f := sc.event(typ, id)
+	if root != 0 {
+		f["parentId"] = root
+	}
+	return f
+}
+
+// withEdits adds the edit state of a stored message to its message frame.
+func withEdits(f map[string]any, editedAt *time.Time, deleted bool) map[string]any {
+	if editedAt != nil {
+		f["editedAt"] = *editedAt
+	}
+	if deleted {
+		f["deleted"] = true
+	}
+	return f
+}
+
+func (s *Service) isModerator(ctx context.Context, uid int64) (bool, error) {
+	var ok bool
+	err := s.db.QueryRow(ctx, `SELECT EXISTS(SELECT 1 FROM user_roles WHERE user_id=$1 AND role='moderator')`, uid).Scan(&ok)
+	return ok, err
+}
+
+// editMessage replaces the body of message id and broadcasts the edit.
+// Saving an unchanged body is a no-op.
+func (s *Service) editMessage(ctx context.Context, sc msgScope, id, uid int64, body string) (map[string]any, error) {
+	if sc.convID == 0 {
+		if err := s.checkGlobalPost(ctx, uid, body); err != nil {
+			return nil, err
+		}
+	}
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	var authorID int64
+	var old string
+	var createdAt time.Time
+	var editedAt, deletedAt *time.Time
+	if err := sc.lookup(ctx, tx, id, `author_id, body, created_at, edited_at, deleted_at`, &authorID, &old, &createdAt, &editedAt, &deletedAt); err != nil {
+		return nil, err
+	}
+	if err := sc.checkNotSystem(ctx, tx, id); err != nil {
+		return nil, err
+	}
+	room, root, err := eventRoom(ctx, tx, sc, id)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case authorID != uid:
+		return nil, errNotAuthor
+	case deletedAt != nil:
+		return nil, errMessageDeleted
+	case s.editWindow > 0 && time.Since(createdAt) > s.editWindow:
+		return nil, errEditWindow
+	}
+	f := sc.threadEvent("edited", id, root)
+	f["body"], f["editedBy"] = body, uid
+	if old == body {
+		if editedAt != nil {
+			f["editedAt"] = *editedAt
+		}
+		return f, nil
+	}
+
+	if _, err := tx.Exec(ctx, `
+		INSERT INTO message_revisions(scope, message_id, body, edited_by) VALUES($1,$2,$3,$4)
+	`, sc.name, id, old, uid); err != nil {
+		return nil, err
+	}
+	var at time.Time
+	if err := tx.QueryRow(ctx, `UPDATE `+sc.table+` SET body=$2, edited_at=now() WHERE id=$1 RETURNING edited_at`, id, body).Scan(&at); err != nil {
+		return nil, err
+	}
+	ref := mentionRef{scope: "global", messageID: id}
+	var notified []int64
+	if sc.convID == 0 {
+		var ms []mention
+		if ms, notified, err = s.saveMentions(ctx, tx, ref, uid, body); err != nil {
+			return nil, err
+		}
+		if ms == nil {
+			ms = []mention{}
+		}
+		f["mentions"] = ms
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	f["editedAt"] = at
+	s.hub.Broadcast(room, f)
+	s.pushMentions(ref, uid, body, notified)
+	if sc.convID != 0 {
+		s.touchConversation(sc.convID)
+	}
+	return f, nil
+}
+
+// deleteMessage soft-deletes message id and broadcasts the deletion.
+func (s *Service) deleteMessage(ctx context.Context, sc msgScope, id, uid int64) (map[string]any, error) {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	var authorID int64
+	var body string
+	var deletedAt *time.Time
+	if err := sc.lookup(ctx, tx, id, `author_id, body, deleted_at`, &authorID, &body, &deletedAt); err != nil {
+		return nil, err
+	}
+	if err := sc.checkNotSystem(ctx, tx, id); err != nil {
+		return nil, err
+	}
+	room, root, err := eventRoom(ctx, tx, sc, id)
+	if err != nil {
+		return nil, err
+	}
+	if deletedAt != nil {
+		return nil, errMessageDeleted
+	}
+	if authorID != uid {
+		mod, err := s.isModerator(ctx, uid)
+		if err != nil {
+			return nil, err
+		}
+		if !mod {
+			return nil, errCannotDelete
+		}
+		if err := logAction(ctx, tx, uid, "delete_message", &authorID, &id, map[string]any{"scope": sc.name}); err != nil {
+			return nil, err
+		}
+	}
+
+	if _, err := tx.Exec(ctx, `
+		INSERT INTO message_revisions(scope, message_id, body, edited_by) VALUES($1,$2,$3,$4)
+	`, sc.name, id, body, uid); err != nil {
+		return nil, err
+	}
+	var at time.Time
+	if err := tx.QueryRow(ctx, `
+		UPDATE `+sc.table+` SET body='', deleted_at=now(), deleted_by=$2 WHERE id=$1 RETURNING deleted_at
+	`, id, uid).Scan(&at); err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec(ctx, `DELETE FROM message_reactions WHERE scope=$1 AND message_id=$2`, sc.name, id); err != nil {
+		return nil, err
+	}
+	if sc.convID == 0 {
+		if _, err := tx.Exec(ctx, `DELETE FROM message_mentions WHERE scope=$1 AND message_id=$2`, sc.name, id); err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	f := sc.threadEvent("deleted", id, root)
+	f["deletedAt"], f["deletedBy"] = at, uid
+	s.hub.Broadcast(room, f)
+	if root != 0 {
+		s.broadcastThread(ctx, root)
+	}
+	if sc.convID != 0 {
+		s.touchConversation(sc.convID)
+	}
+	return f, nil
+}
+
+// editHandler and deleteHandler serve the "edit" and "delete" frames.
+func (s *Service) editHandler(sc msgScope) frameHandler {
+	return func(ctx context.Context, c *client, f inFrame) (*ackFrame, error) {
+		if f.MessageID <= 0 {
+			return nil, invalidFrame("messageId required")
+		}
+		body, err := validateBody(f.Body)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := s.editMessage(ctx, sc, f.MessageID, c.uid, body); err != nil {
+			return nil, err
+		}
+		return &ackFrame{ID: f.MessageID}, nil
+	}
+}
+
+func (s *Service) deleteHandler(sc msgScope) frameHandler {
+	return func(ctx context.Context, c *client, f inFrame) (*ackFrame, error) {
+		if f.MessageID <= 0 {
+			return nil, invalidFrame("messageId required")
+		}
+		if _, err := s.deleteMessage(ctx, sc, f.MessageID, c.uid); err != nil {
+			return nil, err
+		}
+		return &ackFrame{ID: f.MessageID}, nil
+	}
+}
+
+func (s *Service) EditMessage(w http.ResponseWriter, r *http.Request) {
+	convID, err := web.ParamInt64(r, "id")
+	if err != nil {
+		http.Error(w, "bad id", 400)
+		return
+	}
+	s.edit(w, r, convScope(convID))
+}
+
+func (s *Service) DeleteMessage(w http.ResponseWriter, r *http.Request) {
+	convID, err := web.ParamInt64(r, "id")
+	if err != nil {
+		http.Error(w, "bad id", 400)
+		return
+	}
+	s.delete(w, r, convScope(convID))
+}
+
+func (s *Service) GlobalEdit(w http.ResponseWriter, r *http.Request) { s.edit(w, r, globalScope) }
+
+func (s *Service) GlobalDelete(w http.ResponseWriter, r *http.Request) { s.delete(w, r, globalScope) }
+
+func (s *Service) edit(w http.ResponseWriter, r *http.Request, sc msgScope) {
+	uid := auth.UserID(r)
+	id, err := web.ParamInt64(r, "messageId")
+	if err != nil {
+		http.Error(w, "bad id", 400)
+		return
+	}
+	var in struct {
+		Body string `json:"body"`
+	}
+	if err := web.DecodeJSON(r, &in); err != nil {
+		http.Error(w, "bad body", 400)
+		return
+	}
+	body, err := validateBody(in.Body)
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	f, err := s.editMessage(r.Context(), sc, id, uid, body)
+	if err != nil {
+		http.Error(w, err.Error(), httpStatus(err))
+		return
+	}
+	web.JSON(w, 200, f)
+}
+
+func (s *Service) delete(w http.ResponseWriter, r *http.Request, sc msgScope) {
+	uid := auth.UserID(r)
+	id, err := web.ParamInt64(r, "messageId")
+	if err != nil {
+		http.Error(w, "bad id", 400)
+		return
+	}
+	f, err := s.deleteMessage(r.Context(), sc, id, uid)
+	if err != nil {
+		http.Error(w, err.Error(), httpStatus(err))
+		return
+	}
+	web.JSON(w, 200, f)
+}
+
+// MessageRevisions lists the earlier bodies of a conversation message to its
+// members and to moderators. Revisions of deleted messages, which include
+// the deleted body, are for moderators only.
+func (s *Service) MessageRevisions(w http.ResponseWriter, r *http.Request) {
+	uid := auth.UserID(r)
+	convID, err := web.ParamInt64(r, "id")
+	if err != nil {
+		http.Error(w, "bad id", 400)
+		return
+	}
+	member, _ := s.isMember(r, convID, uid)
+	s.revisions(w, r, convScope(convID), member)
+}
+
+func (s *Service) GlobalRevisions(w http.ResponseWriter, r *http.Request) {
+	ok, _ := s.canReadGlobal(r.Context(), auth.UserID(r))
+	s.revisions(w, r, globalScope, ok)
+}
+
+func (s *Service) revisions(w http.ResponseWriter, r *http.Request, sc msgScope, allowed bool) {
+	uid := auth.UserID(r)
+	id, err := web.ParamInt64(r, "messageId")
+	if err != nil {
+		http.Error(w, "bad id", 400)
+		return
+	}
+	mod, err := s.isModerator(r.Context(), uid)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if !allowed && !mod {
+		http.Error(w, "forbidden", 403)
+		return
+	}
+	var deleted bool
+	where, args := sc.where(id)
+	if err := s.db.QueryRow(r.Context(), `SELECT deleted_at IS NOT NULL FROM `+sc.table+where, args...).Scan(&deleted); err != nil {
+		http.Error(w, "not found", 404)
+		return
+	}
+	if deleted && !mod {
+		http.Error(w, "forbidden", 403)
+		return
+	}
+	rows, err := s.db.Query(r.Context(), `
+		SELECT id, body, edited_by, created_at FROM message_revisions
+		WHERE scope=$1 AND message_id=$2 ORDER BY id
+	`, sc.name, id)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	defer rows.Close()
+	type Revision struct {
+		ID        int64     `json:"id"`
+		Body      string    `json:"body"`
+		EditedBy  int64     `json:"editedBy"`
+		CreatedAt time.Time `json:"createdAt"`
+	}
+	items := []Revision{}
+	for rows.Next() {
+		var rv Revision
+		if err := rows.Scan(&rv.ID, &rv.Body, &rv.EditedBy, &rv.CreatedAt); err == nil {
+			items = append(items, rv)
+		}
+	}
+	web.JSON(w, 200, map[string]any{"messageId": id, "items": items})
+}
@@ -0,0 +1,158 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"upskill/internal/web"
+)
+
+// History pages are keyset windows over message ids, so they stay stable
+// while new messages arrive:
+//
+//	?limit=50             newest page
+//	?before=120&limit=50  the page just older than message 120
+//	?after=120&limit=50   the page just newer than message 120
+//
+// Items are always returned oldest first; hasMore tells whether another page
+// exists in the requested direction.
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 200
+	maxReplay       = 500
+)
+
+type page struct {
+	before, after int64
+	limit         int
+}
+
+func parsePage(r *http.Request) (page, error) {
+	var p page
+	var err error
+	if p.before, err = queryID(r, "before"); err != nil {
+		return p, err
+	}
+	if p.after, err = queryID(r, "after"); err != nil {
+		return p, err
+	}
+	if p.before > 0 && p.after > 0 {
+		return p, errors.New("use either before or after")
+	}
+	p.limit = web.QueryInt(r, "limit", defaultPageSize)
+	if p.limit <= 0 || p.limit > maxPageSize {
+		p.limit = defaultPageSize
+	}
+	return p, nil
+}
+
+func queryID(r *http.Request, name string) (int64, error) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return 0, nil
+	}
+	id, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || id < 0 {
+		return 0, errors.New("bad " + name)
+	}
+	return id, nil
+}
+
+// clause returns the id condition plus ORDER BY and LIMIT for idCol. One row
+// more than the page is fetched to detect hasMore.
+func (p page) clause(idCol string, args *web.Args) string {
+	if p.after > 0 {
+		return " AND " + idCol + " > " + args.Add(p.after) +
+			" ORDER BY " + idCol + " ASC LIMIT " + args.Add(p.limit+1)
+	}
+	q := ""
+	if p.before > 0 {
+		q = " AND " + idCol + " < " + args.Add(p.before)
+	}
+	return q + " ORDER BY " + idCol + " DESC LIMIT " + args.Add(p.limit+1)
+}
+
+// finishPage trims the extra row and puts items oldest first.
+func finishPage[T any](p page, items []T, id func(T) int64) ([]T, bool) {
+	more := len(items) > p.limit
+	if more {
+		items = items[:p.limit]
+	}
+	sort.Slice(items, func(i, j int) bool { return id(items[i]) < id(items[j]) })
+	return items, more
+}
+
+// replayKey identifies a message frame. Ids are only unique within a scope,
+// and a multiplexed socket follows several.
+type replayKey struct {
+	ConversationID int64  `json:"conversationId"`
+	Scope          string `json:"scope"`
+	ID             int64  `json:"id"`
+}
+
+// replay writes the message frames returned by query (ordered by id, at most
+// maxReplay+1 rows) to c, followed by a "replayed" frame naming topic, if
+// any. It returns the messages it wrote so release can drop their live
+// copies.
+func (s *Service) replay(ctx context.Context, c *client, scan func(ctx context.Context) ([]map[string]any, error), topic string) (map[replayKey]bool, error) {
+	frames, err := scan(ctx)
+	if err != nil {
+		return nil, err
+	}
+	more := len(frames) > maxReplay
+	if more {
+		frames = frames[:maxReplay]
+	}
+	sent := make(map[replayKey]bool, len(frames))
+	var lastID int64
+	for _, f := range frames {
+		data, err := json.Marshal(f)
+		if err != nil {
+			return nil, err
+		}
+		if err := c.sendWait(data); err != nil {
+			return nil, err
+		}
+		lastID = f["id"].(int64)
+		convID, _ := f["conversationId"].(int64)
+		scope, _ := f["scope"].(string)
+		sent[replayKey{convID, scope, lastID}] = true
+	}
+	done := map[string]any{
+		"v": ProtocolVersion, "type": "replayed", "count": len(frames), "lastId": lastID, "hasMore": more,
+	}
+	if topic != "" {
+		done["topic"] = topic
+	}
+	data, _ := json.Marshal(done)
+	return sent, c.sendWait(data)
+}
+
+// skipReplayed drops live message frames that were already replayed.
+func skipReplayed(sent map[replayKey]bool) func([]byte) bool {
+	return func(data []byte) bool {
+		var f struct {
+			Type string `json:"type"`
+			replayKey
+		}
+		return json.Unmarshal(data, &f) == nil && f.Type == "message" && sent[f.replayKey]
+	}
+}
+
+// startReplay runs the since= handshake: c must be holding and already
+// joined to its room, so nothing broadcast meanwhile is lost.
+func (s *Service) startReplay(ctx context.Context, c *client, scan func(ctx context.Context) ([]map[string]any, error)) error {
+	sent, err := s.replay(ctx, c, scan, "")
+	if err != nil {
+		// c is still holding, so bypass send.
+		data, _ := json.Marshal(errorFrameFor("", err))
+		_ = c.sendWait(data)
+		return err
+	}
+	return c.release(skipReplayed(sent))
+}
@@ -1,48 +1,332 @@
 package chat
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"log"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/gorilla/websocket"
 )
 
+const (
+	writeWait    = 10 * time.Second
+	pongWait     = 60 * time.Second
+	pingPeriod   = pongWait * 9 / 10
+	maxFrameSize = 16 << 10
+	sendQueueLen = 64
+	maxHeld      = 256
+)
+
+// Exported on /debug/vars.
+var (
+	metricConnections = expvar.NewInt("chat_connections")
+	metricRooms       = expvar.NewInt("chat_rooms")
+	metricEvictions   = expvar.NewInt("chat_slow_evictions")
+)
+
+var (
+	errClientClosed = errors.New("client closed")
+	errClientSlow   = errors.New("client too slow")
+)
+
+// client is one open socket. gorilla/websocket allows a single writer, so
+// every frame goes through a bounded queue drained by the client's own
+// writer goroutine. A client whose queue is full is evicted rather than
+// allowed to stall broadcasts to everyone else.
+type client struct {
+	conn  *websocket.Conn
+	uid   int64
+	queue chan []byte
+	done  chan struct{}
+	once  sync.Once
+
+	// While holding, broadcasts are buffered in held instead of queued so a
+	// replay can be written first; see hold and release.
+	mu      sync.Mutex
+	holding bool
+	held    [][]byte
+
+	// kicked, if set, handles a kick from room instead of closing the
+	// socket; see mux.go.
+	kicked func(room string)
+}
+
+func newClient(conn *websocket.Conn, uid int64) *client {
+	c := &client{conn: conn, uid: uid, queue: make(chan []byte, sendQueueLen), done: make(chan struct{})}
+	metricConnections.Add(1)
+	conn.SetReadLimit(maxFrameSize)
+	_ = conn.SetReadDeadline(time.Now().Add(pongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(pongWait))
+	})
+	go c.writeLoop()
+	return c
+}
+
+// send queues v for writing. It never blocks.
+func (c *client) send(v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.sendRaw(data)
+}
+
+func (c *client) sendRaw(data []byte) error {
+	c.mu.Lock()
+	if c.holding {
+		if len(c.held) < maxHeld {
+			c.held = append(c.held, data)
+			c.mu.Unlock()
+			return nil
+		}
+		c.mu.Unlock()
+		metricEvictions.Add(1)
+		c.close()
+		return errClientSlow
+	}
+	c.mu.Unlock()
+	select {
+	case <-c.done:
+		return errClientClosed
+	default:
+	}
+	select {
+	case c.queue <- data:
+		return nil
+	default:
+		metricEvictions.Add(1)
+		c.close()
+		return errClientSlow
+	}
+}
+
+// sendWait queues data, waiting for room in the queue. It is for the
+// connection's own goroutine (replays), never for broadcasts.
+func (c *client) sendWait(data []byte) error {
+	select {
+	case c.queue <- data:
+		return nil
+	case <-c.done:
+		return errClientClosed
+	}
+}
+
+// hold starts buffering broadcasts. Call it before joining a room whose
+// history is about to be replayed.
+func (c *client) hold() {
+	c.mu.Lock()
+	c.holding = true
+	c.mu.Unlock()
+}
+
+// release writes the broadcasts held since hold, dropping those skip
+// reports as already replayed, and resumes live delivery. Frames arriving
+// during the flush are written after the ones before them.
+func (c *client) release(skip func([]byte) bool) error {
+	for {
+		c.mu.Lock()
+		batch := c.held
+		c.held = nil
+		if len(batch) == 0 {
+			c.holding = false
+			c.mu.Unlock()
+			return nil
+		}
+		c.mu.Unlock()
+		for _, data := range batch {
+			if skip != nil && skip(data) {
+				continue
+			}
+			if err := c.sendWait(data); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// close stops the writer and closes the socket, which also ends the read
+// loop. It is safe to call more than once and from any goroutine.
+func (c *client) close() {
+	c.once.Do(func() {
+		close(c.done)
+		_ = c.conn.Close()
+		metricConnections.Add(-1)
+	})
+}
+
+func (c *client) writeLoop() {
+	ticker := time.NewTicker(pingPeriod)
+	defer func() {
+		ticker.Stop()
+		c.close()
+	}()
+	for {
+		select {
+		case <-c.done:
+			return
+		case data := <-c.queue:
+			_ = c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := c.conn.WriteMessage(websocket.TextMessage, data); err != nil {
+				return
+			}
+		case <-ticker.C:
+			if err := c.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeWait)); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// Hub fans payloads out to the clients joined to a room. Broadcasts go
+// through the broker so that every instance delivers to its own sockets.
 type Hub struct {
-	mu    sync.RWMutex
-	rooms map[string]map[*websocket.Conn]struct{}
+	mu     sync.RWMutex
+	rooms  map[string]map[*client]struct{}
+	broker Broker
+
+	// guards holds clients that are not in a room but must still hear its
+	// kicks, counting the subscriptions each guards; see Guard.
+	guards map[string]map[*client]int
+
+	// onDeliver, if set, runs after a payload was queued for the local
+	// clients of room; delivered holds the users it reached.
+	onDeliver func(room string, payload []byte, delivered map[int64]bool)
 }
 
-func NewHub() *Hub {
-	return &Hub{rooms: make(map[string]map[*websocket.Conn]struct{})}
+func NewHub(b Broker) *Hub {
+	h := &Hub{rooms: make(map[string]map[*client]struct{}), guards: make(map[string]map[*client]int), broker: b}
+	b.Subscribe(h.deliver)
+	return h
 }
 
-func (h *Hub) Join(room string, c *websocket.Conn) {
+func (h *Hub) Join(room string, c *client) {
 	h.mu.Lock()
 	if _, ok := h.rooms[room]; !ok {
-		h.rooms[room] = make(map[*websocket.Conn]struct{})
+		h.rooms[room] = make(map[*client]struct{})
+		metricRooms.Add(1)
 	}
 	h.rooms[room][c] = struct{}{}
 	h.mu.Unlock()
 }
 
-func (h *Hub) Leave(room string, c *websocket.Conn) {
+func (h *Hub) Leave(room string, c *client) {
 	h.mu.Lock()
 	if m, ok := h.rooms[room]; ok {
 		delete(m, c)
 		if len(m) == 0 {
 			delete(h.rooms, room)
+			metricRooms.Add(-1)
 		}
 	}
 	h.mu.Unlock()
 }
 
+// Guard makes kicks from room reach c without joining it, for a
+// subscription that depends on access to room. Calls nest; each is undone
+// by one Unguard.
+func (h *Hub) Guard(room string, c *client) {
+	h.mu.Lock()
+	if _, ok := h.guards[room]; !ok {
+		h.guards[room] = make(map[*client]int)
+	}
+	h.guards[room][c]++
+	h.mu.Unlock()
+}
+
+func (h *Hub) Unguard(room string, c *client) {
+	h.mu.Lock()
+	if m, ok := h.guards[room]; ok {
+		if m[c]--; m[c] <= 0 {
+			delete(m, c)
+		}
+		if len(m) == 0 {
+			delete(h.guards, room)
+		}
+	}
+	h.mu.Unlock()
+}
+
+// Broadcast publishes payload to room on every instance.
 func (h *Hub) Broadcast(room string, payload any) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		log.Printf("chat hub: marshal: %v", err)
+		return
+	}
+	if err := h.broker.Publish(context.Background(), room, data); err != nil {
+		log.Printf("chat hub: publish to %s: %v", room, err)
+	}
+}
+
+// Kick disconnects uid's sockets in room on every instance.
+func (h *Hub) Kick(room string, uid int64) {
+	h.Broadcast(kickPrefix+room, uid)
+}
+
+const kickPrefix = "kick:"
+
+// deliver queues a published payload for the local clients of room.
+func (h *Hub) deliver(room string, payload []byte) {
+	if target, ok := strings.CutPrefix(room, kickPrefix); ok {
+		h.kick(target, payload)
+		return
+	}
 	h.mu.RLock()
-	conns := make([]*websocket.Conn, 0, len(h.rooms[room]))
+	clients := make([]*client, 0, len(h.rooms[room]))
 	for c := range h.rooms[room] {
-		conns = append(conns, c)
+		clients = append(clients, c)
 	}
 	h.mu.RUnlock()
-	for _, c := range conns {
-		_ = c.WriteJSON(payload)
+	if len(clients) == 0 {
+		return
+	}
+	delivered := make(map[int64]bool)
+	for _, c := range clients {
+		if err := c.sendRaw(payload); err == nil {
+			delivered[c.uid] = true
+		}
+	}
+	if h.onDeliver != nil {
+		go h.onDeliver(room, payload, delivered)
+	}
+}
+
+func (h *Hub) kick(room string, payload []byte) {
+	var uid int64
+	if err := json.Unmarshal(payload, &uid); err != nil {
+		return
 	}
+	h.mu.RLock()
+	var kicked []*client
+	for c := range h.rooms[room] {
+		if c.uid == uid {
+			kicked = append(kicked, c)
+		}
+	}
+	for c := range h.guards[room] {
+		if _, in := h.rooms[room][c]; !in && c.uid == uid {
+			kicked = append(kicked, c)
+		}
+	}
+	h.mu.RUnlock()
+	for _, c := range kicked {
+		if c.kicked != nil {
+			c.kicked(room)
+		} else {
+			c.close()
+		}
+	}
+}
+
+// local reports whether room has clients on this instance.
+func (h *Hub) local(room string) bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.rooms[room]) > 0
 }
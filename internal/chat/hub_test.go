@@ -0,0 +1,124 @@
+package chat
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// joinServer serves sockets that join room on h as uid.
+func joinServer(t *testing.T, h *Hub, room string, uid int64) string {
+	t.Helper()
+	up := websocket.Upgrader{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := up.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		c := newClient(conn, uid)
+		h.Join(room, c)
+		defer func() { h.Leave(room, c); c.close() }()
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return "ws" + strings.TrimPrefix(srv.URL, "http")
+}
+
+func TestHubsShareBroker(t *testing.T) {
+	broker := NewMemoryBroker()
+	a, b := NewHub(broker), NewHub(broker)
+
+	delivered := make(chan map[int64]bool, 1)
+	a.onDeliver = func(room string, _ []byte, d map[int64]bool) { delivered <- d }
+
+	conn, _, err := websocket.DefaultDialer.Dial(joinServer(t, a, "conv:1", 7), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// Join happens after the handshake; wait until the room exists on a.
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		a.mu.RLock()
+		n := len(a.rooms["conv:1"])
+		a.mu.RUnlock()
+		if n == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("client never joined")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	b.Broadcast("conv:1", map[string]any{"type": "message", "id": 1})
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var got map[string]any
+	if err := conn.ReadJSON(&got); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if got["type"] != "message" {
+		t.Fatalf("unexpected payload %v", got)
+	}
+	select {
+	case d := <-delivered:
+		if !d[7] {
+			t.Fatalf("delivery not reported for uid 7: %v", d)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("onDeliver not called")
+	}
+}
+
+func TestHubKick(t *testing.T) {
+	broker := NewMemoryBroker()
+	a, b := NewHub(broker), NewHub(broker)
+	url := joinServer(t, a, "global", 7)
+	kept, _, err := websocket.DefaultDialer.Dial(joinServer(t, a, "global", 8), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer kept.Close()
+	kicked, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer kicked.Close()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		a.mu.RLock()
+		n := len(a.rooms["global"])
+		a.mu.RUnlock()
+		if n == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("clients never joined")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	b.Kick("global", 7)
+	_ = kicked.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var ne net.Error
+	if _, _, err := kicked.ReadMessage(); err == nil || errors.As(err, &ne) && ne.Timeout() {
+		t.Fatalf("kicked client still connected: %v", err)
+	}
+	b.Broadcast("global", map[string]any{"type": "message"})
+	_ = kept.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, _, err := kept.ReadMessage(); err != nil {
+		t.Fatalf("other client lost: %v", err)
+	}
+}
@@ -0,0 +1,158 @@
+package chat
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+	"unicode/utf8"
+
+	"upskill/internal/auth"
+	"upskill/internal/web"
+)
+
+// The conversation list ("inbox") is ordered by recent activity. Each user
+// can also hold a socket on their own room, user:<id>, which receives a
+// "conversation" event with the fresh list item whenever one of their
+// conversations gets a new, edited, deleted or read message:
+//
+//	{"v":1,"type":"conversation","conversation":{"id":3,"unreadCount":2,...}}
+//
+// Mentions in the global chat and cohort rooms arrive there too, as
+// "mention" events; see mentions.go.
+
+const previewLen = 140
+
+type conversationItem struct {
+	ID          int64        `json:"id"`
+	StudentID   int64        `json:"studentId"`
+	MentorID    int64        `json:"mentorId"`
+	Peer        string       `json:"peer"`
+	PeerID      int64        `json:"peerId"`
+	PeerRole    string       `json:"peerRole"`
+	PeerAvatar  string       `json:"peerAvatarUrl,omitempty"`
+	LastMessage *lastMessage `json:"lastMessage"`
+	UnreadCount int          `json:"unreadCount"`
+	ActivityAt  time.Time    `json:"activityAt"`
+}
+
+type lastMessage struct {
+	ID          int64     `json:"id"`
+	AuthorID    int64     `json:"authorId"`
+	Preview     string    `json:"preview"`
+	Attachments int       `json:"attachments,omitempty"`
+	Deleted     bool      `json:"deleted,omitempty"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+func preview(body string) string {
+	if utf8.RuneCountInString(body) <= previewLen {
+		return body
+	}
+	r := []rune(body)
+	return string(r[:previewLen-1]) + "…"
+}
+
+// conversationItems lists the conversations of uid, most recently active
+// first; convID > 0 restricts it to that conversation.
+func (s *Service) conversationItems(ctx context.Context, uid, convID int64) ([]conversationItem, error) {
+	rows, err := s.db.Query(ctx, `
+		SELECT c.id, c.student_id, c.mentor_id,
+		       COALESCE(p.first_name,'')||' '||COALESCE(p.last_name,'') as peer_name,
+		       COALESCE(p.avatar_url,''),
+		       lm.id, lm.author_id, lm.body, lm.created_at, lm.deleted_at IS NOT NULL,
+		       (SELECT count(*) FROM attachments a WHERE a.message_id = lm.id),
+		       (SELECT count(*) FROM messages um
+		        WHERE um.conversation_id = c.id AND um.author_id <> $1
+		          AND um.read_at IS NULL AND um.deleted_at IS NULL),
+		       COALESCE(lm.created_at, c.created_at) as activity_at
+		FROM conversations c
+		LEFT JOIN users p ON p.id = CASE WHEN c.student_id=$1 THEN c.mentor_id ELSE c.student_id END
+		LEFT JOIN LATERAL (
+			SELECT id, author_id, body, created_at, deleted_at FROM messages
+			WHERE conversation_id = c.id ORDER BY id DESC LIMIT 1
+		) lm ON true
+		WHERE (c.student_id=$1 OR c.mentor_id=$1) AND ($2 = 0 OR c.id = $2)
+		ORDER BY activity_at DESC, c.id DESC
+	`, uid, convID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []conversationItem{}
+	for rows.Next() {
+		var it conversationItem
+		var lmID, lmAuthor *int64
+		var lmBody *string
+		var lmAt *time.Time
+		var lmDeleted bool
+		var lmAttachments int
+		if err := rows.Scan(&it.ID, &it.StudentID, &it.MentorID, &it.Peer, &it.PeerAvatar,
+			&lmID, &lmAuthor, &lmBody, &lmAt, &lmDeleted, &lmAttachments, &it.UnreadCount, &it.ActivityAt); err != nil {
+			continue
+		}
+		it.PeerID, it.PeerRole = it.MentorID, "mentor"
+		if uid == it.MentorID {
+			it.PeerID, it.PeerRole = it.StudentID, "student"
+		}
+		if lmID != nil {
+			it.LastMessage = &lastMessage{ID: *lmID, AuthorID: *lmAuthor, Preview: preview(*lmBody), CreatedAt: *lmAt, Deleted: lmDeleted}
+			if !lmDeleted {
+				it.LastMessage.Attachments = lmAttachments
+			}
+		}
+		items = append(items, it)
+	}
+	return items, rows.Err()
+}
+
+func (s *Service) ListConversations(w http.ResponseWriter, r *http.Request) {
+	uid := auth.UserID(r)
+	items, err := s.conversationItems(r.Context(), uid, 0)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	web.JSON(w, 200, map[string]any{"items": items})
+}
+
+// touchConversation pushes the current list item of convID to both
+// participants' user rooms. It runs in the background so senders are not
+// held up by it.
+func (s *Service) touchConversation(convID int64) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		conv, err := s.getConversation(ctx, convID)
+		if err != nil {
+			log.Printf("chat: inbox update %d: %v", convID, err)
+			return
+		}
+		for _, uid := range []int64{conv.StudentID, conv.MentorID} {
+			items, err := s.conversationItems(ctx, uid, convID)
+			if err != nil || len(items) == 0 {
+				log.Printf("chat: inbox update %d for %d: %v", convID, uid, err)
+				continue
+			}
+			s.hub.Broadcast(userRoom(uid), map[string]any{"v": ProtocolVersion, "type": "conversation", "conversation": items[0]})
+		}
+	}()
+}
+
+// UserWS is the caller's own channel for inbox updates.
+func (s *Service) UserWS(w http.ResponseWriter, r *http.Request) {
+	uid := auth.UserID(r)
+	conn, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	c := newClient(conn, uid)
+	room := userRoom(uid)
+	s.hub.Join(room, c)
+	defer func() { s.hub.Leave(room, c); c.close() }()
+	serve(r.Context(), c, nil)
+}
+
+func userRoom(uid int64) string { return "user:" + strconv.FormatInt(uid, 10) }
@@ -0,0 +1,276 @@
+package chat
+
+import (
+	"context"
+	"net/http"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/jackc/pgx/v5"
+
+	"upskill/internal/auth"
+	"upskill/internal/notify"
+	"upskill/internal/web"
+)
+
+// Mentions. In the global chat and cohort rooms "@handle" addresses another
+// user. Handles that belong to someone who can read the room become mention
+// entities on the message, with offset and length in code points of the
+// body:
+//
+//	{"v":1,"type":"message","scope":"global","id":9,"body":"hi @ann",
+//	 "mentions":[{"userId":4,"handle":"ann","offset":3,"length":4}],...}
+//
+// Each mentioned user gets a "chat.mention" notification and, on their user
+// room, a "mention" event, whether or not they are in the room itself.
+// Editing a message re-resolves its mentions; only new ones notify.
+
+const maxMentions = 20
+
+// An @ that follows a letter, digit, dot or another @ is part of an email
+// address or similar, not a mention.
+var mentionRe = regexp.MustCompile(`(?:^|[^\p{L}\p{N}_.@])(@([A-Za-z0-9_]{3,30}))\b`)
+
+type mention struct {
+	UserID int64  `json:"userId"`
+	Handle string `json:"handle"`
+	Offset int    `json:"offset"`
+	Length int    `json:"length"`
+}
+
+// mentionRef is the message a set of mentions belongs to.
+type mentionRef struct {
+	scope     string // "global" or "cohort"
+	cohortID  int64
+	messageID int64
+}
+
+// parseMentions finds the @handles in body, handles lowercased and user ids
+// still unset.
+func parseMentions(body string) []mention {
+	var res []mention
+	for _, m := range mentionRe.FindAllStringSubmatchIndex(body, -1) {
+		start, end := m[2], m[3]
+		res = append(res, mention{
+			Handle: strings.ToLower(body[start+1 : end]),
+			Offset: utf8.RuneCountInString(body[:start]),
+			Length: utf8.RuneCountInString(body[start:end]),
+		})
+	}
+	return res
+}
+
+// mentionAudience is the condition on users u that can read the room of
+// ref, and so can be mentioned there.
+func mentionAudience(ref mentionRef, args *web.Args) string {
+	if ref.scope == "cohort" {
+		id := args.Add(ref.cohortID)
+		return `(EXISTS(SELECT 1 FROM cohort_mentors WHERE cohort_id=` + id + ` AND mentor_id=u.id)
+		  OR EXISTS(SELECT 1 FROM cohort_members WHERE cohort_id=` + id + ` AND student_id=u.id AND status='enrolled'))`
+	}
+	return `EXISTS(SELECT 1 FROM user_roles ur WHERE ur.user_id=u.id AND ur.role IN ('student','mentor'))
+		AND NOT EXISTS(SELECT 1 FROM chat_sanctions cs WHERE cs.user_id=u.id AND cs.kind='ban'
+		  AND cs.revoked_at IS NULL AND (cs.expires_at IS NULL OR cs.expires_at > now()))`
+}
+
+// resolveMentions keeps the mentions in body of users who can read the
+// room of ref, at most maxMentions distinct users.
+func (s *Service) resolveMentions(ctx context.Context, tx pgx.Tx, ref mentionRef, body string) ([]mention, error) {
+	parsed := parseMentions(body)
+	if len(parsed) == 0 {
+		return nil, nil
+	}
+	handles := make([]string, 0, len(parsed))
+	for _, m := range parsed {
+		handles = append(handles, m.Handle)
+	}
+	args := web.Args{handles}
+	rows, err := tx.Query(ctx, `SELECT u.id, u.handle FROM users u WHERE u.handle = ANY($1) AND `+mentionAudience(ref, &args), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ids := map[string]int64{}
+	for rows.Next() {
+		var id int64
+		var h string
+		if err := rows.Scan(&id, &h); err == nil {
+			ids[h] = id
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	var res []mention
+	users := map[int64]bool{}
+	for _, m := range parsed {
+		id, ok := ids[m.Handle]
+		if !ok || (!users[id] && len(users) == maxMentions) {
+			continue
+		}
+		users[id] = true
+		m.UserID = id
+		res = append(res, m)
+	}
+	return res, nil
+}
+
+// saveMentions resolves and stores the mentions of the message ref by
+// authorID and notifies users who were not mentioned in it before. It
+// returns the mentions and the users to push a mention event to once the
+// transaction commits.
+func (s *Service) saveMentions(ctx context.Context, tx pgx.Tx, ref mentionRef, authorID int64, body string) ([]mention, []int64, error) {
+	ms, err := s.resolveMentions(ctx, tx, ref, body)
+	if err != nil {
+		return nil, nil, err
+	}
+	rows, err := tx.Query(ctx, `
+		DELETE FROM message_mentions WHERE scope=$1 AND message_id=$2 RETURNING user_id
+	`, ref.scope, ref.messageID)
+	if err != nil {
+		return nil, nil, err
+	}
+	before := map[int64]bool{}
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err == nil {
+			before[id] = true
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	var notified []int64
+	for _, m := range ms {
+		if _, err := tx.Exec(ctx, `
+			INSERT INTO message_mentions(scope, message_id, user_id, handle, "offset", length)
+			VALUES($1,$2,$3,$4,$5,$6)
+		`, ref.scope, ref.messageID, m.UserID, m.Handle, m.Offset, m.Length); err != nil {
+			return nil, nil, err
+		}
+		if m.UserID == authorID || before[m.UserID] {
+			continue
+		}
+		before[m.UserID] = true
+		if _, err := notify.Create(ctx, tx, m.UserID, "chat.mention", ref.payload(authorID, body)); err != nil {
+			return nil, nil, err
+		}
+		notified = append(notified, m.UserID)
+	}
+	return ms, notified, nil
+}
+
+func (ref mentionRef) payload(authorID int64, body string) map[string]any {
+	p := map[string]any{"scope": ref.scope, "messageId": ref.messageID, "authorId": authorID, "preview": preview(body)}
+	if ref.scope == "cohort" {
+		p["cohortId"] = ref.cohortID
+	}
+	return p
+}
+
+// pushMentions sends the mention event to each user's own room.
+func (s *Service) pushMentions(ref mentionRef, authorID int64, body string, uids []int64) {
+	for _, uid := range uids {
+		f := ref.payload(authorID, body)
+		f["v"], f["type"] = ProtocolVersion, "mention"
+		s.hub.Broadcast(userRoom(uid), f)
+	}
+}
+
+// messageMentions loads the mentions of messages in scope, by message id.
+func (s *Service) messageMentions(ctx context.Context, scope string, msgIDs []int64) (map[int64][]mention, error) {
+	res := map[int64][]mention{}
+	if len(msgIDs) == 0 {
+		return res, nil
+	}
+	rows, err := s.db.Query(ctx, `
+		SELECT message_id, user_id, handle, "offset", length FROM message_mentions
+		WHERE scope=$1 AND message_id = ANY($2)
+		ORDER BY message_id, "offset"
+	`, scope, msgIDs)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var msgID int64
+		var m mention
+		if err := rows.Scan(&msgID, &m.UserID, &m.Handle, &m.Offset, &m.Length); err != nil {
+			return nil, err
+		}
+		res[msgID] = append(res[msgID], m)
+	}
+	return res, rows.Err()
+}
+
+// withMentions adds mention entities to a message frame.
+func withMentions(f map[string]any, ms []mention) map[string]any {
+	if len(ms) > 0 {
+		f["mentions"] = ms
+	}
+	return f
+}
+
+// SuggestMentions completes a handle prefix for composing a message:
+// ?q=an&scope=global or ?q=an&scope=cohort&cohortId=2. It only offers users
+// a mention would reach.
+func (s *Service) SuggestMentions(w http.ResponseWriter, r *http.Request) {
+	uid := auth.UserID(r)
+	q := strings.ToLower(strings.TrimPrefix(r.URL.Query().Get("q"), "@"))
+	if len(q) > 30 || strings.Trim(q, "abcdefghijklmnopqrstuvwxyz0123456789_") != "" {
+		http.Error(w, "bad q", 400)
+		return
+	}
+	ref := mentionRef{scope: r.URL.Query().Get("scope")}
+	switch ref.scope {
+	case "", "global":
+		ref.scope = "global"
+		if ok, err := s.canReadGlobal(r.Context(), uid); err != nil || !ok {
+			http.Error(w, "forbidden", 403)
+			return
+		}
+	case "cohort":
+		id, err := queryID(r, "cohortId")
+		if err != nil || id == 0 {
+			http.Error(w, "cohortId required", 400)
+			return
+		}
+		if ok, _ := s.isCohortMember(r.Context(), id, uid); !ok {
+			http.Error(w, "forbidden", 403)
+			return
+		}
+		ref.cohortID = id
+	default:
+		http.Error(w, "scope must be global or cohort", 400)
+		return
+	}
+
+	args := web.Args{strings.ReplaceAll(q, "_", `\_`) + "%", uid}
+	rows, err := s.db.Query(r.Context(), `
+		SELECT u.id, u.handle, COALESCE(u.first_name,'')||' '||COALESCE(u.last_name,'') as name, COALESCE(u.avatar_url,'')
+		FROM users u WHERE u.handle LIKE $1 AND u.id <> $2 AND `+mentionAudience(ref, &args)+`
+		ORDER BY u.handle LIMIT 10
+	`, args...)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	defer rows.Close()
+	type Item struct {
+		ID        int64  `json:"id"`
+		Handle    string `json:"handle"`
+		Name      string `json:"name"`
+		AvatarURL string `json:"avatarUrl,omitempty"`
+	}
+	items := []Item{}
+	for rows.Next() {
+		var it Item
+		if err := rows.Scan(&it.ID, &it.Handle, &it.Name, &it.AvatarURL); err == nil {
+			items = append(items, it)
+		}
+	}
+	web.JSON(w, 200, map[string]any{"items": items})
+}
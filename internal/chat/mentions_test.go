@@ -0,0 +1,21 @@
+package chat
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseMentions(t *testing.T) {
+	for body, want := range map[string][]mention{
+		"@Ann hi":                           {{Handle: "ann", Offset: 0, Length: 4}},
+		"héllo @bob_1, @carol!":             {{Handle: "bob_1", Offset: 6, Length: 6}, {Handle: "carol", Offset: 14, Length: 6}},
+		"mail me at ann@example.com":        nil,
+		"@ab is too short":                  nil,
+		"(@dave) and @@eve":                 {{Handle: "dave", Offset: 1, Length: 5}},
+		"@thirtyonecharacterslongggggggggg": nil,
+	} {
+		if got := parseMentions(body); !reflect.DeepEqual(got, want) {
+			t.Errorf("parseMentions(%q) = %+v, want %+v", body, got, want)
+		}
+	}
+}
@@ -0,0 +1,605 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/jackc/pgx/v5"
+
+	"upskill/internal/auth"
+	"upskill/internal/notify"
+	"upskill/internal/web"
+)
+
+// Global chat moderation. Anyone in the global chat can report a message
+// into the moderation queue. Moderators resolve reports, mute users (no
+// posting) or ban them (no access at all) for a while or for good, and set
+// the slow-mode interval and the blocked-word list. Every moderator action
+// goes to moderation_log.
+//
+// checkGlobalPost enforces all of it but slow mode and is shared by
+// GlobalPost and the socket's send and edit frames; checkSlowMode runs in
+// postGlobal's transaction.
+
+const (
+	maxBlockedWords = 500
+	maxSlowMode     = 24 * 60 * 60
+)
+
+var (
+	errMuted       = &frameError{codeForbidden, "you are muted in the global chat"}
+	errBanned      = &frameError{codeForbidden, "you are banned from the global chat"}
+	errBlockedWord = invalidFrame("message contains a blocked word")
+)
+
+type globalRules struct {
+	SlowModeSeconds int      `json:"slowModeSeconds"`
+	BlockedWords    []string `json:"blockedWords"`
+}
+
+func (s *Service) globalRules(ctx context.Context) (globalRules, error) {
+	var g globalRules
+	err := s.db.QueryRow(ctx, `SELECT slow_mode_seconds, blocked_words FROM global_chat_settings WHERE id=1`).
+		Scan(&g.SlowModeSeconds, &g.BlockedWords)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return g, nil
+	}
+	return g, err
+}
+
+// sanction returns the strongest active sanction of uid: "ban", "mute" or "".
+func (s *Service) sanction(ctx context.Context, uid int64) (string, error) {
+	var kind string
+	err := s.db.QueryRow(ctx, `
+		SELECT kind FROM chat_sanctions
+		WHERE user_id=$1 AND revoked_at IS NULL AND (expires_at IS NULL OR expires_at > now())
+		ORDER BY kind='ban' DESC LIMIT 1
+	`, uid).Scan(&kind)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", nil
+	}
+	return kind, err
+}
+
+// canReadGlobal is canPostGlobal minus banned users.
+func (s *Service) canReadGlobal(ctx context.Context, uid int64) (bool, error) {
+	ok, err := s.canPostGlobal(ctx, uid)
+	if err != nil || !ok {
+		return false, err
+	}
+	kind, err := s.sanction(ctx, uid)
+	return kind != "ban", err
+}
+
+// containsBlocked reports whether body contains one of the blocked entries
+// as whole words, ignoring case and punctuation.
+func containsBlocked(body string, blocked []string) bool {
+	if len(blocked) == 0 {
+		return false
+	}
+	notWord := func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsNumber(r) }
+	words := strings.FieldsFunc(strings.ToLower(body), notWord)
+	joined := " " + strings.Join(words, " ") + " "
+	for _, b := range blocked {
+		entry := strings.Join(strings.FieldsFunc(strings.ToLower(b), notWord), " ")
+		if entry != "" && strings.Contains(joined, " "+entry+" ") {
+			return true
+		}
+	}
+	return false
+}
+
+// checkGlobalPost rejects a global message by uid that breaks the rules.
+// Slow mode is left to checkSlowMode, which new messages go through inside
+// their insert transaction.
+func (s *Service) checkGlobalPost(ctx context.Context, uid int64, body string) error {
+	switch kind, err := s.sanction(ctx, uid); {
+	case err != nil:
+		return err
+	case kind == "ban":
+		return errBanned
+	case kind == "mute":
+		return errMuted
+	}
+	rules, err := s.globalRules(ctx)
+	if err != nil {
+		return err
+	}
+	if containsBlocked(body, rules.BlockedWords) {
+		return errBlockedWord
+	}
+	return nil
+}
+
+// checkSlowMode rejects a new global message by uid that comes too soon
+// after their last one. Moderators are exempt. It locks uid's posting for
+// the rest of tx, so concurrent posts by the same user are serialized and
+// the second one sees the first.
+func (s *Service) checkSlowMode(ctx context.Context, tx pgx.Tx, uid int64) error {
+	rules, err := s.globalRules(ctx)
+	if err != nil || rules.SlowModeSeconds == 0 {
+		return err
+	}
+	if mod, err := s.isModerator(ctx, uid); err != nil || mod {
+		return err
+	}
+	if _, err := tx.Exec(ctx, `SELECT pg_advisory_xact_lock(hashtextextended('global_messages:' || $1::bigint, 0))`, uid); err != nil {
+		return err
+	}
+	var wait float64
+	if err := tx.QueryRow(ctx, `
+		SELECT COALESCE(EXTRACT(EPOCH FROM MAX(created_at) + make_interval(secs => $2) - clock_timestamp()), 0)
+		FROM global_messages WHERE author_id=$1
+	`, uid, rules.SlowModeSeconds).Scan(&wait); err != nil {
+		return err
+	}
+	if wait > 0 {
+		return &frameError{codeRateLimited, fmt.Sprintf("slow mode: wait %d more seconds", int(wait)+1)}
+	}
+	return nil
+}
+
+// logAction records a moderator action, inside the caller's transaction
+// when q is one.
+func logAction(ctx context.Context, q notify.Querier, modID int64, action string, targetID, messageID *int64, details map[string]any) error {
+	if details == nil {
+		details = map[string]any{}
+	}
+	raw, err := json.Marshal(details)
+	if err != nil {
+		return err
+	}
+	var id int64
+	return q.QueryRow(ctx, `
+		INSERT INTO moderation_log(moderator_id, action, target_user_id, message_id, details)
+		VALUES($1,$2,$3,$4,$5) RETURNING id
+	`, modID, action, targetID, messageID, raw).Scan(&id)
+}
+
+// requireModerator writes 403 unless the caller is a moderator.
+func (s *Service) requireModerator(w http.ResponseWriter, r *http.Request) bool {
+	ok, err := s.isModerator(r.Context(), auth.UserID(r))
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return false
+	}
+	if !ok {
+		http.Error(w, "forbidden", 403)
+		return false
+	}
+	return true
+}
+
+// --- reports ---
+
+func (s *Service) ReportGlobal(w http.ResponseWriter, r *http.Request) {
+	uid := auth.UserID(r)
+	if ok, err := s.canReadGlobal(r.Context(), uid); err != nil || !ok {
+		http.Error(w, "forbidden", 403)
+		return
+	}
+	msgID, err := web.ParamInt64(r, "messageId")
+	if err != nil {
+		http.Error(w, "bad id", 400)
+		return
+	}
+	var in struct {
+		Reason string `json:"reason"`
+	}
+	if err := web.DecodeJSON(r, &in); err != nil || len(in.Reason) > 1000 {
+		http.Error(w, "bad input", 400)
+		return
+	}
+	var authorID int64
+	if err := s.db.QueryRow(r.Context(), `SELECT author_id FROM global_messages WHERE id=$1 AND deleted_at IS NULL`, msgID).Scan(&authorID); err != nil {
+		http.Error(w, "not found", 404)
+		return
+	}
+	if authorID == uid {
+		http.Error(w, "cannot report your own message", 400)
+		return
+	}
+	if _, err := s.db.Exec(r.Context(), `
+		INSERT INTO message_reports(message_id, reporter_id, reason) VALUES($1,$2,NULLIF($3,''))
+		ON CONFLICT (message_id, reporter_id) DO NOTHING
+	`, msgID, uid, strings.TrimSpace(in.Reason)); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	web.JSON(w, 200, map[string]any{"ok": true})
+}
+
+var reportsSpec = web.ListSpec{
+	IDColumn: "mr.id",
+	Sorts: map[string]web.SortKey{
+		"createdAt": {Column: "mr.created_at", Type: web.SortTime},
+	},
+	DefaultSort:     "createdAt",
+	StatusColumn:    "mr.status",
+	Statuses:        []string{"open", "resolved", "dismissed"},
+	DefaultStatuses: []string{"open"},
+	DateColumn:      "mr.created_at",
+	DefaultLimit:    50,
+	MaxLimit:        200,
+}
+
+// ListReports is the moderation queue, oldest open report first by default.
+func (s *Service) ListReports(w http.ResponseWriter, r *http.Request) {
+	if !s.requireModerator(w, r) {
+		return
+	}
+	lq, err := web.ParseList(r, reportsSpec)
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	var args web.Args
+	rows, err := s.db.Query(r.Context(), `
+		SELECT mr.id, mr.message_id, gm.author_id,
+		       COALESCE(au.first_name,'')||' '||COALESCE(au.last_name,'') as author,
+		       gm.body, gm.deleted_at IS NOT NULL, mr.reporter_id, COALESCE(mr.reason,''), mr.status,
+		       (SELECT count(*) FROM message_reports o WHERE o.message_id = mr.message_id),
+		       mr.created_at
+		FROM message_reports mr
+		JOIN global_messages gm ON gm.id = mr.message_id
+		LEFT JOIN users au ON au.id = gm.author_id
+		WHERE true`+lq.Where(&args)+lq.OrderLimit(&args), args...)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	defer rows.Close()
+	type Item struct {
+		ID          int64     `json:"id"`
+		MessageID   int64     `json:"messageId"`
+		AuthorID    int64     `json:"authorId"`
+		Author      string    `json:"author"`
+		Body        string    `json:"body"`
+		Deleted     bool      `json:"deleted,omitempty"`
+		ReporterID  int64     `json:"reporterId"`
+		Reason      string    `json:"reason"`
+		Status      string    `json:"status"`
+		ReportCount int       `json:"reportCount"`
+		CreatedAt   time.Time `json:"createdAt"`
+	}
+	items := []Item{}
+	for rows.Next() {
+		var it Item
+		if err := rows.Scan(&it.ID, &it.MessageID, &it.AuthorID, &it.Author, &it.Body, &it.Deleted,
+			&it.ReporterID, &it.Reason, &it.Status, &it.ReportCount, &it.CreatedAt); err == nil {
+			items = append(items, it)
+		}
+	}
+	items, next := web.Page(w, r, lq, items, func(it Item, _ string) (any, int64) { return it.CreatedAt, it.ID })
+	web.JSON(w, 200, map[string]any{"items": items, "nextCursor": next})
+}
+
+// ResolveReport closes every open report of the message. "delete" also
+// deletes the message; "dismiss" keeps it.
+func (s *Service) ResolveReport(w http.ResponseWriter, r *http.Request) {
+	if !s.requireModerator(w, r) {
+		return
+	}
+	uid := auth.UserID(r)
+	id, err := web.ParamInt64(r, "id")
+	if err != nil {
+		http.Error(w, "bad id", 400)
+		return
+	}
+	var in struct {
+		Action string `json:"action"`
+		Note   string `json:"note"`
+	}
+	if err := web.DecodeJSON(r, &in); err != nil || (in.Action != "delete" && in.Action != "dismiss") {
+		http.Error(w, "action must be delete or dismiss", 400)
+		return
+	}
+	var msgID int64
+	if err := s.db.QueryRow(r.Context(), `SELECT message_id FROM message_reports WHERE id=$1`, id).Scan(&msgID); err != nil {
+		http.Error(w, "not found", 404)
+		return
+	}
+	status := "dismissed"
+	if in.Action == "delete" {
+		status = "resolved"
+		if _, err := s.deleteMessage(r.Context(), globalScope, msgID, uid); err != nil && !errors.Is(err, errMessageDeleted) {
+			http.Error(w, err.Error(), httpStatus(err))
+			return
+		}
+	}
+
+	tx, err := s.db.Begin(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	defer tx.Rollback(r.Context())
+	tag, err := tx.Exec(r.Context(), `
+		UPDATE message_reports SET status=$2, resolved_by=$3, resolved_at=now()
+		WHERE message_id=$1 AND status='open'
+	`, msgID, status, uid)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if in.Action == "dismiss" {
+		var authorID int64
+		_ = tx.QueryRow(r.Context(), `SELECT author_id FROM global_messages WHERE id=$1`, msgID).Scan(&authorID)
+		if err := logAction(r.Context(), tx, uid, "dismiss_reports", &authorID, &msgID, map[string]any{"note": in.Note}); err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+	}
+	if err := tx.Commit(r.Context()); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	web.JSON(w, 200, map[string]any{"ok": true, "status": status, "reportsClosed": tag.RowsAffected()})
+}
+
+// --- sanctions ---
+
+func (s *Service) CreateSanction(w http.ResponseWriter, r *http.Request) {
+	if !s.requireModerator(w, r) {
+		return
+	}
+	modID := auth.UserID(r)
+	var in struct {
+		UserID  int64  `json:"userId"`
+		Kind    string `json:"kind"`
+		Minutes int    `json:"minutes"` // 0 = permanent
+		Reason  string `json:"reason"`
+	}
+	if err := web.DecodeJSON(r, &in); err != nil || in.UserID <= 0 || in.Minutes < 0 || (in.Kind != "mute" && in.Kind != "ban") {
+		http.Error(w, "bad input", 400)
+		return
+	}
+	if in.UserID == modID {
+		http.Error(w, "cannot sanction yourself", 400)
+		return
+	}
+	var expiresAt *time.Time
+	if in.Minutes > 0 {
+		t := time.Now().UTC().Add(time.Duration(in.Minutes) * time.Minute)
+		expiresAt = &t
+	}
+
+	tx, err := s.db.Begin(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	defer tx.Rollback(r.Context())
+	var exists bool
+	if err := tx.QueryRow(r.Context(), `SELECT EXISTS(SELECT 1 FROM users WHERE id=$1)`, in.UserID).Scan(&exists); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if !exists {
+		http.Error(w, "user not found", 404)
+		return
+	}
+	var id int64
+	if err := tx.QueryRow(r.Context(), `
+		INSERT INTO chat_sanctions(user_id, kind, reason, created_by, expires_at)
+		VALUES($1,$2,NULLIF($3,''),$4,$5) RETURNING id
+	`, in.UserID, in.Kind, in.Reason, modID, expiresAt).Scan(&id); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if err := logAction(r.Context(), tx, modID, in.Kind, &in.UserID, nil, map[string]any{
+		"sanctionId": id, "reason": in.Reason, "expiresAt": expiresAt,
+	}); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if _, err := notify.Create(r.Context(), tx, in.UserID, "chat.sanction", map[string]any{
+		"sanctionId": id, "kind": in.Kind, "reason": in.Reason, "expiresAt": expiresAt,
+	}); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if err := tx.Commit(r.Context()); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if in.Kind == "ban" {
+		s.hub.Kick("global", in.UserID)
+	}
+	web.JSON(w, 200, map[string]any{"id": id, "expiresAt": expiresAt})
+}
+
+// ListSanctions lists the sanctions in force.
+func (s *Service) ListSanctions(w http.ResponseWriter, r *http.Request) {
+	if !s.requireModerator(w, r) {
+		return
+	}
+	rows, err := s.db.Query(r.Context(), `
+		SELECT cs.id, cs.user_id, COALESCE(u.first_name,'')||' '||COALESCE(u.last_name,'') as name,
+		       cs.kind, COALESCE(cs.reason,''), cs.created_by, cs.created_at, cs.expires_at
+		FROM chat_sanctions cs
+		LEFT JOIN users u ON u.id = cs.user_id
+		WHERE cs.revoked_at IS NULL AND (cs.expires_at IS NULL OR cs.expires_at > now())
+		ORDER BY cs.created_at DESC
+	`)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	defer rows.Close()
+	type Item struct {
+		ID        int64      `json:"id"`
+		UserID    int64      `json:"userId"`
+		Name      string     `json:"name"`
+		Kind      string     `json:"kind"`
+		Reason    string     `json:"reason"`
+		CreatedBy int64      `json:"createdBy"`
+		CreatedAt time.Time  `json:"createdAt"`
+		ExpiresAt *time.Time `json:"expiresAt"`
+	}
+	items := []Item{}
+	for rows.Next() {
+		var it Item
+		if err := rows.Scan(&it.ID, &it.UserID, &it.Name, &it.Kind, &it.Reason, &it.CreatedBy, &it.CreatedAt, &it.ExpiresAt); err == nil {
+			items = append(items, it)
+		}
+	}
+	web.JSON(w, 200, map[string]any{"items": items})
+}
+
+func (s *Service) RevokeSanction(w http.ResponseWriter, r *http.Request) {
+	if !s.requireModerator(w, r) {
+		return
+	}
+	modID := auth.UserID(r)
+	id, err := web.ParamInt64(r, "id")
+	if err != nil {
+		http.Error(w, "bad id", 400)
+		return
+	}
+	tx, err := s.db.Begin(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	defer tx.Rollback(r.Context())
+	var userID int64
+	if err := tx.QueryRow(r.Context(), `
+		UPDATE chat_sanctions SET revoked_at=now(), revoked_by=$2
+		WHERE id=$1 AND revoked_at IS NULL RETURNING user_id
+	`, id, modID).Scan(&userID); err != nil {
+		http.Error(w, "not found", 404)
+		return
+	}
+	if err := logAction(r.Context(), tx, modID, "revoke_sanction", &userID, nil, map[string]any{"sanctionId": id}); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if err := tx.Commit(r.Context()); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	web.JSON(w, 200, map[string]any{"ok": true})
+}
+
+// --- settings and log ---
+
+func (s *Service) GetModerationSettings(w http.ResponseWriter, r *http.Request) {
+	if !s.requireModerator(w, r) {
+		return
+	}
+	rules, err := s.globalRules(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	web.JSON(w, 200, rules)
+}
+
+func (s *Service) PutModerationSettings(w http.ResponseWriter, r *http.Request) {
+	if !s.requireModerator(w, r) {
+		return
+	}
+	modID := auth.UserID(r)
+	var in globalRules
+	if err := web.DecodeJSON(r, &in); err != nil || in.SlowModeSeconds < 0 || in.SlowModeSeconds > maxSlowMode || len(in.BlockedWords) > maxBlockedWords {
+		http.Error(w, "bad input", 400)
+		return
+	}
+	words := []string{}
+	seen := map[string]bool{}
+	for _, w := range in.BlockedWords {
+		w = strings.ToLower(strings.TrimSpace(w))
+		if w != "" && !seen[w] {
+			seen[w] = true
+			words = append(words, w)
+		}
+	}
+	in.BlockedWords = words
+
+	tx, err := s.db.Begin(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	defer tx.Rollback(r.Context())
+	if _, err := tx.Exec(r.Context(), `
+		INSERT INTO global_chat_settings(id, slow_mode_seconds, blocked_words, updated_by, updated_at)
+		VALUES(1,$1,$2,$3,now())
+		ON CONFLICT (id) DO UPDATE SET slow_mode_seconds=EXCLUDED.slow_mode_seconds,
+		  blocked_words=EXCLUDED.blocked_words, updated_by=EXCLUDED.updated_by, updated_at=now()
+	`, in.SlowModeSeconds, in.BlockedWords, modID); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if err := logAction(r.Context(), tx, modID, "update_settings", nil, nil, map[string]any{
+		"slowModeSeconds": in.SlowModeSeconds, "blockedWords": len(in.BlockedWords),
+	}); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if err := tx.Commit(r.Context()); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	web.JSON(w, 200, in)
+}
+
+var modLogSpec = web.ListSpec{
+	IDColumn: "ml.id",
+	Sorts: map[string]web.SortKey{
+		"createdAt": {Column: "ml.created_at", Type: web.SortTime},
+	},
+	DefaultSort:  "-createdAt",
+	DateColumn:   "ml.created_at",
+	DefaultLimit: 50,
+	MaxLimit:     200,
+}
+
+func (s *Service) ModerationLog(w http.ResponseWriter, r *http.Request) {
+	if !s.requireModerator(w, r) {
+		return
+	}
+	lq, err := web.ParseList(r, modLogSpec)
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	var args web.Args
+	rows, err := s.db.Query(r.Context(), `
+		SELECT ml.id, ml.moderator_id, COALESCE(u.first_name,'')||' '||COALESCE(u.last_name,'') as moderator,
+		       ml.action, ml.target_user_id, ml.message_id, ml.details, ml.created_at
+		FROM moderation_log ml
+		LEFT JOIN users u ON u.id = ml.moderator_id
+		WHERE true`+lq.Where(&args)+lq.OrderLimit(&args), args...)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	defer rows.Close()
+	type Item struct {
+		ID           int64           `json:"id"`
+		ModeratorID  int64           `json:"moderatorId"`
+		Moderator    string          `json:"moderator"`
+		Action       string          `json:"action"`
+		TargetUserID *int64          `json:"targetUserId,omitempty"`
+		MessageID    *int64          `json:"messageId,omitempty"`
+		Details      json.RawMessage `json:"details"`
+		CreatedAt    time.Time       `json:"createdAt"`
+	}
+	items := []Item{}
+	for rows.Next() {
+		var it Item
+		if err := rows.Scan(&it.ID, &it.ModeratorID, &it.Moderator, &it.Action, &it.TargetUserID, &it.MessageID, &it.Details, &it.CreatedAt); err == nil {
+			items = append(items, it)
+		}
+	}
+	items, next := web.Page(w, r, lq, items, func(it Item, _ string) (any, int64) { return it.CreatedAt, it.ID })
+	web.JSON(w, 200, map[string]any{"items": items, "nextCursor": next})
+}
@@ -0,0 +1,23 @@
+package chat
+
+import "testing"
+
+func TestContainsBlocked(t *testing.T) {
+	blocked := []string{"spam", "Free Money"}
+	for body, want := range map[string]bool{
+		"no SPAM please":        true,
+		"spam!":                 true,
+		"spammer":               false,
+		"get free   money now":  true,
+		"free money-back offer": true,
+		"money free":            false,
+		"":                      false,
+	} {
+		if got := containsBlocked(body, blocked); got != want {
+			t.Errorf("containsBlocked(%q) = %v", body, got)
+		}
+	}
+	if containsBlocked("spam", nil) {
+		t.Error("empty list blocked a message")
+	}
+}
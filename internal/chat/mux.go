@@ -0,0 +1,383 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	"upskill/internal/auth"
+)
+
+// Multiplexed socket. GET /ws carries all realtime traffic of a user over
+// one connection. The client subscribes to topics, and access is checked
+// per topic when it does:
+//
+//	{"v":1,"type":"subscribe","clientId":"s-1","topic":"conversation:3","since":40}
+//	{"v":1,"type":"unsubscribe","clientId":"s-2","topic":"conversation:3"}
+//
+// Topics:
+//
+//	global             the global chat room
+//	thread:<id>        replies to global message <id>
+//	conversation:<id>  a conversation the caller takes part in
+//	cohort:<id>        a cohort room the caller belongs to
+//	inbox              conversation list updates and mentions of the caller
+//	notifications      the caller's new notifications
+//
+// plus the kinds other packages add with RegisterTopic, such as plan:<id>.
+// The subscribe ack carries the topic as the server names it; "since"
+// replays global or conversation messages as on the dedicated sockets,
+// ending with a "replayed" frame that names the topic. Events are the
+// frames the dedicated sockets send. Frames that act on a topic (send,
+// typing, read, edit, delete, react, unreact) name it and need a
+// subscription:
+//
+//	{"v":1,"type":"send","topic":"conversation:3","clientId":"c-1","body":"hi"}
+//
+// When access ends, as on a chat ban or when a plan is unshared, the
+// subscription is dropped and the client told:
+//
+//	{"v":1,"type":"unsubscribed","topic":"global"}
+
+const maxTopics = 100
+
+// topicFrames are the frame types routed to a subscribed topic.
+var topicFrames = []string{"send", "typing", "read", "edit", "delete", "react", "unreact"}
+
+// builtinTopics are the topic kinds served by this package, and whether
+// they take an id.
+var builtinTopics = map[string]bool{
+	"global": false, "thread": true, "conversation": true, "cohort": true, "inbox": false, "notifications": false,
+}
+
+var errNoTopicAccess = &frameError{codeForbidden, "no access to topic"}
+
+// TopicAuthorizer reports whether uid may follow topic <kind>:<id>.
+type TopicAuthorizer func(ctx context.Context, uid, id int64) (bool, error)
+
+// RegisterTopic adds topic kind, served by another package that publishes
+// its events with Publish. Call it while setting up routes; the registry is
+// not locked.
+func (s *Service) RegisterTopic(kind string, can TopicAuthorizer) {
+	if _, ok := builtinTopics[kind]; ok || s.topics[kind] != nil || strings.Contains(kind, ":") {
+		panic("chat: bad or duplicate topic kind " + kind)
+	}
+	s.topics[kind] = can
+}
+
+// Publish sends event to the subscribers of topic <kind>:<id>.
+func (s *Service) Publish(kind string, id int64, event map[string]any) {
+	event["v"] = ProtocolVersion
+	s.hub.Broadcast(topicRoom(kind, id), event)
+}
+
+// Revoke drops uid's subscriptions to topic <kind>:<id> once their access
+// to it has ended. For cohort topics it also closes uid's dedicated cohort
+// sockets.
+func (s *Service) Revoke(kind string, id, uid int64) {
+	room := topicRoom(kind, id)
+	if kind == "cohort" {
+		room = cohortRoom(id)
+	}
+	s.hub.Kick(room, uid)
+}
+
+func topicRoom(kind string, id int64) string {
+	return "topic:" + kind + ":" + strconv.FormatInt(id, 10)
+}
+
+func notificationsRoom(uid int64) string { return "notifications:" + strconv.FormatInt(uid, 10) }
+
+// parseTopic splits a topic name into its kind and id. Kinds not built in
+// always take an id.
+func parseTopic(name string) (string, int64, error) {
+	kind, rest, hasID := strings.Cut(name, ":")
+	if needsID, builtin := builtinTopics[kind]; builtin && !needsID {
+		if hasID {
+			return "", 0, invalidFrame("topic " + kind + " takes no id")
+		}
+		return kind, 0, nil
+	}
+	if kind == "" || !hasID {
+		return "", 0, invalidFrame("bad topic")
+	}
+	id, err := strconv.ParseInt(rest, 10, 64)
+	if err != nil || id <= 0 {
+		return "", 0, invalidFrame("bad topic id")
+	}
+	return kind, id, nil
+}
+
+// topic is one subscription of a multiplexed socket.
+type topic struct {
+	name     string
+	room     string
+	guard    string // a kick from this room ends the subscription too; see Hub.Guard
+	handlers map[string]frameHandler
+	replay   func(since int64) func(ctx context.Context) ([]map[string]any, error)
+}
+
+// resolveTopic checks that uid may follow the named topic and returns it.
+func (s *Service) resolveTopic(ctx context.Context, uid int64, name string) (*topic, error) {
+	kind, id, err := parseTopic(name)
+	if err != nil {
+		return nil, err
+	}
+	switch kind {
+	case "global":
+		if err := allowed(s.canReadGlobal(ctx, uid)); err != nil {
+			return nil, err
+		}
+		return &topic{
+			name: kind, room: "global", handlers: s.globalHandlers(uid, 0),
+			replay: func(since int64) func(ctx context.Context) ([]map[string]any, error) {
+				return s.replayGlobal(since, uid)
+			},
+		}, nil
+	case "thread":
+		if err := allowed(s.canReadGlobal(ctx, uid)); err != nil {
+			return nil, err
+		}
+		var root int64
+		err := s.db.QueryRow(ctx, `SELECT COALESCE(parent_id, id) FROM global_messages WHERE id=$1`, id).Scan(&root)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, errMessageNotFound
+		}
+		if err != nil {
+			return nil, err
+		}
+		return &topic{
+			name: "thread:" + strconv.FormatInt(root, 10), room: threadRoom(root), guard: "global",
+			handlers: s.globalHandlers(uid, root),
+		}, nil
+	case "conversation":
+		conv, err := s.getConversation(ctx, id)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, &frameError{codeNotFound, "conversation not found"}
+		}
+		if err != nil {
+			return nil, err
+		}
+		if conv.authorType(uid) == "" {
+			return nil, errNoTopicAccess
+		}
+		return &topic{
+			name: name, room: roomName(id), handlers: s.conversationHandlers(conv, uid),
+			replay: func(since int64) func(ctx context.Context) ([]map[string]any, error) {
+				return s.replayConversation(id, since, uid)
+			},
+		}, nil
+	case "cohort":
+		if err := allowed(s.isCohortMember(ctx, id, uid)); err != nil {
+			return nil, err
+		}
+		return &topic{name: name, room: cohortRoom(id)}, nil
+	case "inbox":
+		return &topic{name: kind, room: userRoom(uid)}, nil
+	case "notifications":
+		return &topic{name: kind, room: notificationsRoom(uid)}, nil
+	}
+	can := s.topics[kind]
+	if can == nil {
+		return nil, invalidFrame("unknown topic " + kind)
+	}
+	if err := allowed(can(ctx, uid, id)); err != nil {
+		return nil, err
+	}
+	return &topic{name: name, room: topicRoom(kind, id)}, nil
+}
+
+func allowed(ok bool, err error) error {
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errNoTopicAccess
+	}
+	return nil
+}
+
+// mux tracks the subscriptions of one multiplexed socket. Frames are
+// handled one at a time, but kicks arrive from the broker, hence the lock.
+type mux struct {
+	s    *Service
+	c    *client
+	mu   sync.Mutex
+	subs map[string]*topic
+}
+
+// MuxWS is the multiplexed socket described above.
+func (s *Service) MuxWS(w http.ResponseWriter, r *http.Request) {
+	uid := auth.UserID(r)
+	conn, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	c := newClient(conn, uid)
+	m := &mux{s: s, c: c, subs: map[string]*topic{}}
+	c.kicked = m.kicked
+	defer func() { m.leaveAll(); c.close() }()
+
+	handlers := map[string]frameHandler{"subscribe": m.subscribe, "unsubscribe": m.unsubscribe}
+	for _, typ := range topicFrames {
+		handlers[typ] = m.route
+	}
+	serve(r.Context(), c, handlers)
+}
+
+func (m *mux) subscribe(ctx context.Context, c *client, f inFrame) (*ackFrame, error) {
+	if f.Since < 0 {
+		return nil, invalidFrame("bad since")
+	}
+	t, err := m.s.resolveTopic(ctx, c.uid, f.Topic)
+	if err != nil {
+		return nil, err
+	}
+	if f.Since > 0 && t.replay == nil {
+		return nil, invalidFrame("topic " + t.name + " has no history")
+	}
+	m.mu.Lock()
+	_, joined := m.subs[t.name]
+	if !joined && len(m.subs) >= maxTopics {
+		m.mu.Unlock()
+		return nil, invalidFrame("too many topics")
+	}
+	m.subs[t.name] = t
+	m.mu.Unlock()
+
+	// As on the dedicated sockets, broadcasts are held while the replay is
+	// written and then de-duplicated against it.
+	if f.Since > 0 {
+		c.hold()
+	}
+	if !joined {
+		m.s.hub.Join(t.room, c)
+		if t.guard != "" {
+			m.s.hub.Guard(t.guard, c)
+		}
+	}
+	if f.Since > 0 {
+		sent, err := m.s.replay(ctx, c, t.replay(f.Since), t.name)
+		if rerr := c.release(skipReplayed(sent)); err == nil {
+			err = rerr
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &ackFrame{Topic: t.name}, nil
+}
+
+func (m *mux) unsubscribe(ctx context.Context, c *client, f inFrame) (*ackFrame, error) {
+	if f.Topic == "" {
+		return nil, invalidFrame("topic required")
+	}
+	m.mu.Lock()
+	t, ok := m.subs[f.Topic]
+	delete(m.subs, f.Topic)
+	m.mu.Unlock()
+	if ok {
+		m.leave(t)
+	}
+	return &ackFrame{Topic: f.Topic}, nil
+}
+
+// route hands a frame to the subscribed topic it names.
+func (m *mux) route(ctx context.Context, c *client, f inFrame) (*ackFrame, error) {
+	if f.Topic == "" {
+		return nil, invalidFrame("topic required")
+	}
+	m.mu.Lock()
+	t := m.subs[f.Topic]
+	m.mu.Unlock()
+	if t == nil {
+		return nil, &frameError{codeForbidden, "not subscribed to " + f.Topic}
+	}
+	h := t.handlers[f.Type]
+	if h == nil {
+		return nil, &frameError{codeUnknownType, f.Type + " is not supported on " + t.name}
+	}
+	return h(ctx, c, f)
+}
+
+// kicked drops the subscriptions a kick from room ends.
+func (m *mux) kicked(room string) {
+	m.mu.Lock()
+	var dropped []*topic
+	for name, t := range m.subs {
+		if t.room == room || t.guard == room {
+			delete(m.subs, name)
+			dropped = append(dropped, t)
+		}
+	}
+	m.mu.Unlock()
+	for _, t := range dropped {
+		m.leave(t)
+		_ = m.c.send(map[string]any{"v": ProtocolVersion, "type": "unsubscribed", "topic": t.name})
+	}
+}
+
+func (m *mux) leaveAll() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, t := range m.subs {
+		m.leave(t)
+	}
+}
+
+func (m *mux) leave(t *topic) {
+	m.s.hub.Leave(t.room, m.c)
+	if t.guard != "" {
+		m.s.hub.Unguard(t.guard, m.c)
+	}
+}
+
+// notifyChannel is where the notifications table announces new rows; see
+// migration 026.
+const notifyChannel = "notifications"
+
+// watchNotifications pushes new notifications to the notifications topic
+// of their user. Every instance listens, so each only delivers to its own
+// sockets.
+func (s *Service) watchNotifications(ctx context.Context) {
+	listenPG(ctx, s.db, notifyChannel, "chat notifications", func(msg string) {
+		var n struct {
+			ID     int64 `json:"id"`
+			UserID int64 `json:"userId"`
+		}
+		if err := json.Unmarshal([]byte(msg), &n); err != nil {
+			log.Printf("chat notifications: bad notification: %v", err)
+			return
+		}
+		room := notificationsRoom(n.UserID)
+		if !s.hub.local(room) {
+			return
+		}
+		var kind string
+		var payload json.RawMessage
+		var createdAt time.Time
+		if err := s.db.QueryRow(ctx, `
+			SELECT kind, payload, created_at FROM notifications WHERE id=$1
+		`, n.ID).Scan(&kind, &payload, &createdAt); err != nil {
+			log.Printf("chat notifications: load %d: %v", n.ID, err)
+			return
+		}
+		data, err := json.Marshal(map[string]any{
+			"v": ProtocolVersion, "type": "notification",
+			"notification": map[string]any{"id": n.ID, "kind": kind, "payload": payload, "createdAt": createdAt},
+		})
+		if err != nil {
+			return
+		}
+		s.hub.deliver(room, data)
+	})
+}
@@ -0,0 +1,27 @@
+package chat
+
+import "testing"
+
+func TestParseTopic(t *testing.T) {
+	for name, want := range map[string]struct {
+		kind string
+		id   int64
+	}{
+		"global":         {"global", 0},
+		"inbox":          {"inbox", 0},
+		"notifications":  {"notifications", 0},
+		"conversation:3": {"conversation", 3},
+		"thread:12":      {"thread", 12},
+		"plan:5":         {"plan", 5},
+	} {
+		kind, id, err := parseTopic(name)
+		if err != nil || kind != want.kind || id != want.id {
+			t.Errorf("parseTopic(%q) = %q, %d, %v", name, kind, id, err)
+		}
+	}
+	for _, name := range []string{"", "global:1", "conversation", "conversation:", "conversation:0", "cohort:-2", "plan:x", ":4"} {
+		if _, _, err := parseTopic(name); err == nil {
+			t.Errorf("parseTopic(%q) accepted", name)
+		}
+	}
+}
@@ -0,0 +1,212 @@
+package chat
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"strings"
+	"unicode/utf8"
+)
+
+// WebSocket protocol. Every frame is a JSON object carrying the protocol
+// version "v" and a "type".
+//
+// Client to server:
+//
+//	{"v":1,"type":"send","clientId":"c-1","body":"hi","attachmentIds":[5]}
+//	{"v":1,"type":"typing","active":true}
+//	{"v":1,"type":"read","upToId":42}
+//	{"v":1,"type":"edit","clientId":"c-2","messageId":7,"body":"hi!"}
+//	{"v":1,"type":"delete","clientId":"c-3","messageId":7}
+//	{"v":1,"type":"react","clientId":"c-4","messageId":7,"emoji":"👍"}
+//	{"v":1,"type":"unreact","clientId":"c-5","messageId":7,"emoji":"👍"}
+//
+// The global socket also takes "parentId" on send and the thread frames
+// described in threads.go. The multiplexed socket of mux.go adds "topic"
+// to these and its own subscribe frames.
+//
+// Server to client, besides the room broadcasts ("message", "typing",
+// "receipt", "edited", "deleted", "reaction"):
+//
+//	{"v":1,"type":"ack","clientId":"c-1","id":7,"createdAt":"...","duplicate":false}
+//	{"v":1,"type":"error","clientId":"c-1","code":"invalid","message":"body required"}
+//
+// A send is acknowledged once the message is stored. Re-sending the same
+// clientId returns the original message id with duplicate=true and is not
+// broadcast again, so clients can retry safely after a reconnect.
+
+const ProtocolVersion = 1
+
+const (
+	maxBodyLen     = 4000
+	maxClientIDLen = 64
+)
+
+// Error codes carried by error frames.
+const (
+	codeBadFrame    = "bad_frame"
+	codeVersion     = "unsupported_version"
+	codeUnknownType = "unknown_type"
+	codeInvalid     = "invalid"
+	codeNotFound    = "not_found"
+	codeForbidden   = "forbidden"
+	codeConflict    = "conflict"
+	codeRateLimited = "rate_limited"
+	codeInternal    = "internal"
+)
+
+type inFrame struct {
+	V         int    `json:"v"`
+	Type      string `json:"type"`
+	ClientID  string `json:"clientId,omitempty"`
+	Body      string `json:"body,omitempty"`
+	Active    *bool  `json:"active,omitempty"`
+	UpToID    int64  `json:"upToId,omitempty"`
+	MessageID int64  `json:"messageId,omitempty"`
+	Emoji     string `json:"emoji,omitempty"`
+	ParentID  int64  `json:"parentId,omitempty"`
+	Topic     string `json:"topic,omitempty"`
+	Since     int64  `json:"since,omitempty"`
+
+	AttachmentIDs []int64 `json:"attachmentIds,omitempty"`
+}
+
+type ackFrame struct {
+	V         int    `json:"v"`
+	Type      string `json:"type"`
+	ClientID  string `json:"clientId,omitempty"`
+	ID        int64  `json:"id,omitempty"`
+	Topic     string `json:"topic,omitempty"`
+	CreatedAt any    `json:"createdAt,omitempty"`
+	Duplicate bool   `json:"duplicate,omitempty"`
+}
+
+type errorFrame struct {
+	V        int    `json:"v"`
+	Type     string `json:"type"`
+	ClientID string `json:"clientId,omitempty"`
+	Code     string `json:"code"`
+	Message  string `json:"message"`
+}
+
+// frameError is returned by frame handlers for problems the client caused;
+// its code and message are sent back verbatim.
+type frameError struct {
+	code, msg string
+}
+
+func (e *frameError) Error() string { return e.msg }
+
+func invalidFrame(msg string) error { return &frameError{codeInvalid, msg} }
+
+// frameHandler handles one frame type and returns the ack to send, if any.
+type frameHandler func(ctx context.Context, c *client, f inFrame) (*ackFrame, error)
+
+// serve runs the read loop of c until the socket closes.
+func serve(ctx context.Context, c *client, handlers map[string]frameHandler) {
+	for {
+		_, data, err := c.conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		f, ferr := decodeFrame(data)
+		if ferr == nil {
+			if h, ok := handlers[f.Type]; ok {
+				var ack *ackFrame
+				if ack, ferr = h(ctx, c, f); ferr == nil && ack != nil {
+					ack.V, ack.Type, ack.ClientID = ProtocolVersion, "ack", f.ClientID
+					_ = c.send(ack)
+				}
+			} else {
+				ferr = &frameError{codeUnknownType, "unknown type " + f.Type}
+			}
+		}
+		if ferr != nil {
+			_ = c.send(errorFrameFor(f.ClientID, ferr))
+		}
+	}
+}
+
+func decodeFrame(data []byte) (inFrame, error) {
+	var f inFrame
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&f); err != nil {
+		return f, &frameError{codeBadFrame, "malformed frame"}
+	}
+	if f.V != ProtocolVersion {
+		return f, &frameError{codeVersion, "protocol version must be 1"}
+	}
+	if f.Type == "" {
+		return f, &frameError{codeBadFrame, "type required"}
+	}
+	if len(f.ClientID) > maxClientIDLen {
+		return f, invalidFrame("clientId too long")
+	}
+	return f, nil
+}
+
+func errorFrameFor(clientID string, err error) errorFrame {
+	var fe *frameError
+	if !errors.As(err, &fe) {
+		log.Printf("chat ws: %v", err)
+		fe = &frameError{codeInternal, "internal error"}
+	}
+	return errorFrame{V: ProtocolVersion, Type: "error", ClientID: clientID, Code: fe.code, Message: fe.msg}
+}
+
+// httpStatus maps the error of an operation shared with the socket to the
+// status of its HTTP endpoint.
+func httpStatus(err error) int {
+	var fe *frameError
+	if !errors.As(err, &fe) {
+		return 500
+	}
+	switch fe.code {
+	case codeNotFound:
+		return 404
+	case codeForbidden:
+		return 403
+	case codeConflict:
+		return 409
+	case codeRateLimited:
+		return 429
+	}
+	return 400
+}
+
+// validateBody trims body and checks it against the message limits shared by
+// the socket and the HTTP endpoints.
+func validateBody(body string) (string, error) {
+	body = strings.TrimSpace(body)
+	if body == "" {
+		return "", invalidFrame("body required")
+	}
+	if utf8.RuneCountInString(body) > maxBodyLen {
+		return "", invalidFrame("body too long")
+	}
+	return body, nil
+}
+
+// validateMessage is validateBody for new messages, whose body may be empty
+// when they carry attachments.
+func validateMessage(body string, attachmentIDs []int64) (string, []int64, error) {
+	ids, err := checkAttachmentIDs(attachmentIDs)
+	if err != nil {
+		return "", nil, err
+	}
+	if len(ids) > 0 && strings.TrimSpace(body) == "" {
+		return "", ids, nil
+	}
+	body, err = validateBody(body)
+	return body, ids, err
+}
+
+func validateSend(f inFrame) (string, []int64, error) {
+	if f.ClientID == "" {
+		return "", nil, invalidFrame("clientId required")
+	}
+	return validateMessage(f.Body, f.AttachmentIDs)
+}
@@ -0,0 +1,241 @@
+package chat
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/go-chi/chi/v5"
+
+	"upskill/internal/auth"
+	"upskill/internal/web"
+)
+
+// Reactions. Conversation members, and global chat users who are not muted,
+// can react to a message with emoji, once per emoji. Changes are broadcast
+// to the message's room with the new count:
+//
+//	{"v":1,"type":"reaction","conversationId":3,"id":7,"emoji":"👍","userId":1,"added":true,"count":2}
+//
+// History responses carry the totals per message, in the order the emoji
+// were first used: "reactions":[{"emoji":"👍","count":2,"me":true}].
+
+const (
+	maxEmojiLen      = 32 // bytes; covers ZWJ sequences such as family emoji
+	maxReactionKinds = 20 // distinct emoji per message
+)
+
+var errReactionKinds = invalidFrame("too many different reactions on this message")
+
+type reactionCount struct {
+	Emoji string `json:"emoji"`
+	Count int    `json:"count"`
+	Me    bool   `json:"me,omitempty"`
+}
+
+// validEmoji accepts a single emoji or emoji sequence: at least one symbol,
+// plus the modifiers, joiners and selectors that combine with it.
+func validEmoji(e string) bool {
+	if e == "" || len(e) > maxEmojiLen || !utf8.ValidString(e) {
+		return false
+	}
+	symbol := false
+	for _, r := range e {
+		switch {
+		case unicode.Is(unicode.So, r):
+			symbol = true
+		case unicode.In(r, unicode.Sk, unicode.Mn, unicode.Me, unicode.Cf):
+		default:
+			return false
+		}
+	}
+	return symbol
+}
+
+// react adds or removes uid's emoji reaction to message id and broadcasts the
+// change. Repeating an add or a remove changes nothing and broadcasts
+// nothing.
+func (s *Service) react(ctx context.Context, sc msgScope, id, uid int64, emoji string, add bool) (map[string]any, error) {
+	if !validEmoji(emoji) {
+		return nil, invalidFrame("emoji required")
+	}
+	if sc.convID == 0 {
+		switch kind, err := s.sanction(ctx, uid); {
+		case err != nil:
+			return nil, err
+		case kind == "ban":
+			return nil, errBanned
+		case kind == "mute":
+			return nil, errMuted
+		}
+	}
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	var deletedAt *time.Time
+	if err := sc.lookup(ctx, tx, id, `deleted_at`, &deletedAt); err != nil {
+		return nil, err
+	}
+	if deletedAt != nil {
+		return nil, errMessageDeleted
+	}
+	room, root, err := eventRoom(ctx, tx, sc, id)
+	if err != nil {
+		return nil, err
+	}
+	var changed int64
+	if add {
+		var kinds int
+		var used bool
+		if err := tx.QueryRow(ctx, `
+			SELECT count(DISTINCT emoji), COALESCE(bool_or(emoji=$3), false)
+			FROM message_reactions WHERE scope=$1 AND message_id=$2
+		`, sc.name, id, emoji).Scan(&kinds, &used); err != nil {
+			return nil, err
+		}
+		if !used && kinds >= maxReactionKinds {
+			return nil, errReactionKinds
+		}
+		tag, err := tx.Exec(ctx, `
+			INSERT INTO message_reactions(scope, message_id, user_id, emoji) VALUES($1,$2,$3,$4)
+			ON CONFLICT DO NOTHING
+		`, sc.name, id, uid, emoji)
+		if err != nil {
+			return nil, err
+		}
+		changed = tag.RowsAffected()
+	} else {
+		tag, err := tx.Exec(ctx, `
+			DELETE FROM message_reactions WHERE scope=$1 AND message_id=$2 AND user_id=$3 AND emoji=$4
+		`, sc.name, id, uid, emoji)
+		if err != nil {
+			return nil, err
+		}
+		changed = tag.RowsAffected()
+	}
+	var count int
+	if err := tx.QueryRow(ctx, `
+		SELECT count(*) FROM message_reactions WHERE scope=$1 AND message_id=$2 AND emoji=$3
+	`, sc.name, id, emoji).Scan(&count); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	f := sc.threadEvent("reaction", id, root)
+	f["emoji"], f["userId"], f["added"], f["count"] = emoji, uid, add, count
+	if changed > 0 {
+		s.hub.Broadcast(room, f)
+	}
+	return f, nil
+}
+
+// messageReactions totals the reactions of messages in scope, by message id,
+// marking the ones by uid.
+func (s *Service) messageReactions(ctx context.Context, scope string, msgIDs []int64, uid int64) (map[int64][]reactionCount, error) {
+	res := map[int64][]reactionCount{}
+	if len(msgIDs) == 0 {
+		return res, nil
+	}
+	rows, err := s.db.Query(ctx, `
+		SELECT message_id, emoji, count(*), bool_or(user_id=$3)
+		FROM message_reactions
+		WHERE scope=$1 AND message_id = ANY($2)
+		GROUP BY message_id, emoji
+		ORDER BY message_id, min(created_at), emoji
+	`, scope, msgIDs, uid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var msgID int64
+		var rc reactionCount
+		if err := rows.Scan(&msgID, &rc.Emoji, &rc.Count, &rc.Me); err != nil {
+			return nil, err
+		}
+		res[msgID] = append(res[msgID], rc)
+	}
+	return res, rows.Err()
+}
+
+// withReactions adds reaction totals to a message frame.
+func withReactions(f map[string]any, rs []reactionCount) map[string]any {
+	if len(rs) > 0 {
+		f["reactions"] = rs
+	}
+	return f
+}
+
+// reactHandler serves the "react" and "unreact" frames.
+func (s *Service) reactHandler(sc msgScope, add bool) frameHandler {
+	return func(ctx context.Context, c *client, f inFrame) (*ackFrame, error) {
+		if f.MessageID <= 0 {
+			return nil, invalidFrame("messageId required")
+		}
+		if _, err := s.react(ctx, sc, f.MessageID, c.uid, f.Emoji, add); err != nil {
+			return nil, err
+		}
+		return &ackFrame{ID: f.MessageID}, nil
+	}
+}
+
+func (s *Service) AddReaction(w http.ResponseWriter, r *http.Request)    { s.convReaction(w, r, true) }
+func (s *Service) RemoveReaction(w http.ResponseWriter, r *http.Request) { s.convReaction(w, r, false) }
+
+func (s *Service) GlobalAddReaction(w http.ResponseWriter, r *http.Request) {
+	s.globalReaction(w, r, true)
+}
+
+func (s *Service) GlobalRemoveReaction(w http.ResponseWriter, r *http.Request) {
+	s.globalReaction(w, r, false)
+}
+
+func (s *Service) convReaction(w http.ResponseWriter, r *http.Request, add bool) {
+	convID, err := web.ParamInt64(r, "id")
+	if err != nil {
+		http.Error(w, "bad id", 400)
+		return
+	}
+	if ok, _ := s.isMember(r, convID, auth.UserID(r)); !ok {
+		http.Error(w, "forbidden", 403)
+		return
+	}
+	s.reaction(w, r, convScope(convID), add)
+}
+
+func (s *Service) globalReaction(w http.ResponseWriter, r *http.Request, add bool) {
+	if ok, err := s.canPostGlobal(r.Context(), auth.UserID(r)); err != nil || !ok {
+		http.Error(w, "forbidden", 403)
+		return
+	}
+	s.reaction(w, r, globalScope, add)
+}
+
+// reaction takes the emoji from the path (PUT or DELETE .../reactions/{emoji},
+// percent-encoded).
+func (s *Service) reaction(w http.ResponseWriter, r *http.Request, sc msgScope, add bool) {
+	uid := auth.UserID(r)
+	id, err := web.ParamInt64(r, "messageId")
+	if err != nil {
+		http.Error(w, "bad id", 400)
+		return
+	}
+	emoji, err := url.PathUnescape(chi.URLParam(r, "emoji"))
+	if err != nil {
+		http.Error(w, "bad emoji", 400)
+		return
+	}
+	f, err := s.react(r.Context(), sc, id, uid, emoji, add)
+	if err != nil {
+		http.Error(w, err.Error(), httpStatus(err))
+		return
+	}
+	web.JSON(w, 200, f)
+}
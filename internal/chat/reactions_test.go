@@ -0,0 +1,23 @@
+package chat
+
+import "testing"
+
+func TestValidEmoji(t *testing.T) {
+	for e, want := range map[string]bool{
+		"👍":         true,
+		"👍🏽":        true,
+		"❤️":        true,
+		"👩‍👩‍👧":     true,
+		"🇺🇦":        true,
+		"":          false,
+		"a":         false,
+		"👍 ":        false,
+		"ok👍":       false,
+		"\u200d":    false,
+		"👍👍👍👍👍👍👍👍👍": false,
+	} {
+		if got := validEmoji(e); got != want {
+			t.Errorf("validEmoji(%q) = %v", e, got)
+		}
+	}
+}
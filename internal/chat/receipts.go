@@ -0,0 +1,126 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"upskill/internal/auth"
+	"upskill/internal/web"
+)
+
+// Receipts: a message is "delivered" once the hub pushed it to a socket of
+// the recipient and "read" once the recipient marks it so. Both transitions
+// are broadcast to the conversation room as "receipt" events.
+
+func messageStatus(deliveredAt, readAt *time.Time) string {
+	switch {
+	case readAt != nil:
+		return "read"
+	case deliveredAt != nil:
+		return "delivered"
+	}
+	return "sent"
+}
+
+// onDeliver is the hub hook that records delivery: a conversation message
+// that reached a socket of anyone but its author is delivered. Every
+// instance runs it for its own sockets; the UPDATE only succeeds once.
+func (s *Service) onDeliver(room string, payload []byte, delivered map[int64]bool) {
+	if !strings.HasPrefix(room, "conv:") {
+		return
+	}
+	var m struct {
+		Type           string `json:"type"`
+		ConversationID int64  `json:"conversationId"`
+		ID             int64  `json:"id"`
+		AuthorID       int64  `json:"authorId"`
+	}
+	if err := json.Unmarshal(payload, &m); err != nil || m.Type != "message" {
+		return
+	}
+	for uid := range delivered {
+		if uid != m.AuthorID {
+			s.markDelivered(context.Background(), m.ConversationID, m.ID, uid)
+			return
+		}
+	}
+}
+
+func (s *Service) markDelivered(ctx context.Context, convID, msgID, recipientID int64) {
+	var at time.Time
+	if err := s.db.QueryRow(ctx, `
+		UPDATE messages SET delivered_at=now()
+		WHERE id=$1 AND delivered_at IS NULL
+		RETURNING delivered_at
+	`, msgID).Scan(&at); err != nil {
+		return
+	}
+	s.hub.Broadcast(roomName(convID), map[string]any{
+		"v": ProtocolVersion, "type": "receipt", "status": "delivered", "conversationId": convID,
+		"userId": recipientID, "messageIds": []int64{msgID}, "at": at,
+	})
+}
+
+// MarkRead marks the peer's messages up to upToId (all of them when omitted)
+// as read.
+func (s *Service) MarkRead(w http.ResponseWriter, r *http.Request) {
+	uid := auth.UserID(r)
+	convID, err := web.ParamInt64(r, "id")
+	if err != nil {
+		http.Error(w, "bad id", 400)
+		return
+	}
+	if ok, _ := s.isMember(r, convID, uid); !ok {
+		http.Error(w, "forbidden", 403)
+		return
+	}
+	var in struct {
+		UpToID int64 `json:"upToId"`
+	}
+	if err := web.DecodeJSON(r, &in); (err != nil && !errors.Is(err, io.EOF)) || in.UpToID < 0 {
+		http.Error(w, "bad input", 400)
+		return
+	}
+	ids, at, err := s.markRead(r.Context(), convID, uid, in.UpToID)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	web.JSON(w, 200, map[string]any{"ok": true, "messageIds": ids, "readAt": at})
+}
+
+func (s *Service) markRead(ctx context.Context, convID, uid, upToID int64) ([]int64, time.Time, error) {
+	at := time.Now().UTC()
+	rows, err := s.db.Query(ctx, `
+		UPDATE messages SET read_at=$4, delivered_at=COALESCE(delivered_at, $4)
+		WHERE conversation_id=$1 AND author_id<>$2 AND read_at IS NULL AND ($3 = 0 OR id <= $3)
+		RETURNING id
+	`, convID, uid, upToID, at)
+	if err != nil {
+		return nil, at, err
+	}
+	defer rows.Close()
+	ids := []int64{}
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, at, err
+	}
+	if len(ids) > 0 {
+		s.hub.Broadcast(roomName(convID), map[string]any{
+			"v": ProtocolVersion, "type": "receipt", "status": "read", "conversationId": convID,
+			"userId": uid, "messageIds": ids, "at": at,
+		})
+		s.touchConversation(convID)
+	}
+	return ids, at, nil
+}
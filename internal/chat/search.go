@@ -0,0 +1,164 @@
+package chat
+
+import (
+	"html"
+	"net/http"
+	"strings"
+	"time"
+
+	"upskill/internal/auth"
+	"upskill/internal/web"
+)
+
+// Search matches ?q (web search syntax: words, "phrases", -exclusions)
+// against the caller's conversations and, if they may read it, the global
+// chat:
+//
+//	GET /chat/search?q=goroutine+leak&conversationId=3&authorId=7&from=2024-01-01&to=2024-03-31
+//	GET /chat/search?q=...&scope=global&sort=-rank&cursor=...
+//
+// Snippets are HTML: the message text is escaped and matches are wrapped in
+// <mark>. Deleted messages are never found.
+
+var searchSpec = web.ListSpec{
+	IDColumn: "h.key",
+	Sorts: map[string]web.SortKey{
+		"createdAt": {Column: "h.created_at", Type: web.SortTime},
+		"rank":      {Column: "h.rank", Type: web.SortFloat},
+	},
+	DefaultSort:  "-createdAt",
+	DateColumn:   "h.created_at",
+	SearchTSV:    "h.tsv",
+	DefaultLimit: 20,
+	MaxLimit:     100,
+}
+
+// globalHits is the global chat branch of the search union, left out for
+// callers who may not read the global chat.
+func globalHits(allowed bool) string {
+	if !allowed {
+		return ""
+	}
+	return `
+				UNION ALL
+				SELECT 'global', gm.id, gm.id*2+1, NULL, gm.author_id,
+				       gm.body, gm.body_tsv, gm.created_at
+				FROM global_messages gm
+				WHERE gm.deleted_at IS NULL`
+}
+
+// Match delimiters for ts_headline, from the private use area so they
+// survive HTML escaping and cannot be confused with message text.
+const (
+	markStart = "\ue000"
+	markStop  = "\ue001"
+)
+
+var headlineOpts = "StartSel=" + markStart + ", StopSel=" + markStop +
+	", MaxWords=30, MinWords=10, MaxFragments=2, FragmentDelimiter=\" … \""
+
+// highlight turns a ts_headline result into escaped HTML with <mark> tags.
+func highlight(s string) string {
+	s = html.EscapeString(s)
+	return strings.NewReplacer(markStart, "<mark>", markStop, "</mark>").Replace(s)
+}
+
+func (s *Service) Search(w http.ResponseWriter, r *http.Request) {
+	uid := auth.UserID(r)
+	lq, err := web.ParseList(r, searchSpec)
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	if lq.Search == "" {
+		http.Error(w, "q required", 400)
+		return
+	}
+	convID, err := queryID(r, "conversationId")
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	authorID, err := queryID(r, "authorId")
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	scope := r.URL.Query().Get("scope")
+	if scope != "" && scope != "conversation" && scope != "global" {
+		http.Error(w, "scope must be conversation or global", 400)
+		return
+	}
+	global, err := s.canReadGlobal(r.Context(), uid)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if !global && scope == "global" {
+		http.Error(w, "forbidden", 403)
+		return
+	}
+
+	args := web.Args{uid}
+	tsq := lq.TSQuery(&args)
+	q := `
+		SELECT h.scope, h.id, h.key, h.conversation_id, h.author_id,
+		       COALESCE(u.first_name,'')||' '||COALESCE(u.last_name,'') as author,
+		       ts_headline('simple', h.body, ` + tsq + `, ` + args.Add(headlineOpts) + `),
+		       h.created_at, h.rank
+		FROM (
+			SELECT hits.*, ts_rank(hits.tsv, ` + tsq + `)::float8 AS rank
+			FROM (
+				SELECT 'conversation' AS scope, m.id, m.id*2 AS key, m.conversation_id, m.author_id,
+				       m.body, m.body_tsv AS tsv, m.created_at
+				FROM messages m
+				JOIN conversations c ON c.id = m.conversation_id
+				WHERE (c.student_id=$1 OR c.mentor_id=$1) AND m.deleted_at IS NULL` + globalHits(global) + `
+			) hits
+		) h
+		LEFT JOIN users u ON u.id = h.author_id
+		WHERE true`
+	if convID > 0 {
+		q += ` AND h.conversation_id = ` + args.Add(convID)
+	}
+	if authorID > 0 {
+		q += ` AND h.author_id = ` + args.Add(authorID)
+	}
+	if scope != "" {
+		q += ` AND h.scope = ` + args.Add(scope)
+	}
+	q += lq.Where(&args) + lq.OrderLimit(&args)
+	rows, err := s.db.Query(r.Context(), q, args...)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	defer rows.Close()
+
+	type Hit struct {
+		Scope          string    `json:"scope"`
+		ID             int64     `json:"id"`
+		ConversationID *int64    `json:"conversationId,omitempty"`
+		AuthorID       int64     `json:"authorId"`
+		Author         string    `json:"author"`
+		Snippet        string    `json:"snippet"`
+		CreatedAt      time.Time `json:"createdAt"`
+		Rank           float64   `json:"rank"`
+		key            int64
+	}
+	items := []Hit{}
+	for rows.Next() {
+		var h Hit
+		if err := rows.Scan(&h.Scope, &h.ID, &h.key, &h.ConversationID, &h.AuthorID, &h.Author, &h.Snippet, &h.CreatedAt, &h.Rank); err == nil {
+			h.Snippet = highlight(h.Snippet)
+			items = append(items, h)
+		}
+	}
+	items, next := web.Page(w, r, lq, items, func(h Hit, sort string) (any, int64) {
+		if sort == "rank" {
+			return h.Rank, h.key
+		}
+		return h.CreatedAt, h.key
+	})
+	web.JSON(w, 200, map[string]any{"items": items, "nextCursor": next})
+}
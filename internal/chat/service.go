@@ -1,16 +1,23 @@
 package chat
 
 import (
+	"context"
+	"crypto/rand"
 	"database/sql"
+	"encoding/json"
 	"errors"
+	"log"
 	"net/http"
 	"strconv"
 	"time"
 
 	"github.com/gorilla/websocket"
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 
 	"upskill/internal/auth"
+	"upskill/internal/config"
+	"upskill/internal/storage"
 	"upskill/internal/web"
 )
 
@@ -19,98 +26,326 @@ type Service struct {
 	hub      *Hub
 	upgrader websocket.Upgrader
 	auth     *auth.Service
+
+	// editWindow is how long authors can edit a message; zero means no limit.
+	editWindow time.Duration
+
+	// Attachments: blob store, upload limit and download URL signing.
+	store     storage.Store
+	maxUpload int64
+	urlKey    []byte
+	urlTTL    time.Duration
+
+	// topics are the kinds of topic other packages serve on the
+	// multiplexed socket; see mux.go.
+	topics map[string]TopicAuthorizer
 }
 
-func NewService(db *pgxpool.Pool, authSvc *auth.Service) *Service {
-	return &Service{
-		db:       db,
-		hub:      NewHub(),
-		auth:     authSvc,
-		upgrader: websocket.Upgrader{CheckOrigin: func(*http.Request) bool { return true }},
+// NewService sets up the chat service. Its broker and notification
+// listeners run until ctx is done.
+func NewService(ctx context.Context, cfg config.Config, db *pgxpool.Pool, authSvc *auth.Service) *Service {
+	var broker Broker
+	switch cfg.ChatBroker {
+	case "postgres":
+		broker = NewPGBroker(ctx, db)
+	default:
+		broker = NewMemoryBroker()
 	}
+	store, err := storage.New(cfg)
+	if err != nil {
+		log.Fatalf("chat: attachment storage: %v", err)
+	}
+	s := &Service{
+		db:         db,
+		hub:        NewHub(broker),
+		auth:       authSvc,
+		upgrader:   websocket.Upgrader{CheckOrigin: func(*http.Request) bool { return true }},
+		editWindow: cfg.ChatEditWindow,
+		store:      store,
+		maxUpload:  cfg.AttachmentMaxBytes,
+		urlKey:     []byte(cfg.AttachmentURLSecret),
+		urlTTL:     cfg.AttachmentURLTTL,
+		topics:     map[string]TopicAuthorizer{},
+	}
+	if s.maxUpload <= 0 {
+		s.maxUpload = 10 << 20
+	}
+	if s.urlTTL <= 0 {
+		s.urlTTL = 15 * time.Minute
+	}
+	if len(s.urlKey) == 0 {
+		// Links then only verify on this instance and die with it.
+		s.urlKey = make([]byte, 32)
+		_, _ = rand.Read(s.urlKey)
+	}
+	s.hub.onDeliver = s.onDeliver
+	go s.watchNotifications(ctx)
+	return s
 }
 
+// GlobalHistory pages through the top-level global messages. Replies are
+// left to GlobalThread; each message carries the summary of its thread.
 func (s *Service) GlobalHistory(w http.ResponseWriter, r *http.Request) {
-	limit := web.QueryInt(r, "limit", 50)
-	if limit <= 0 || limit > 200 {
-		limit = 50
+	uid := auth.UserID(r)
+	if kind, err := s.sanction(r.Context(), uid); err != nil || kind == "ban" {
+		http.Error(w, "forbidden", 403)
+		return
 	}
-	rows, err := s.db.Query(r.Context(), `
+	p, err := parsePage(r)
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	items, more, err := s.globalMessages(r.Context(), uid, `gm.parent_id IS NULL`, nil, p)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	web.JSON(w, 200, map[string]any{"items": items, "hasMore": more})
+}
+
+type globalMsg struct {
+	ID          int64           `json:"id"`
+	AuthorID    int64           `json:"authorId"`
+	Author      string          `json:"author"`
+	Body        string          `json:"body"`
+	CreatedAt   time.Time       `json:"createdAt"`
+	EditedAt    *time.Time      `json:"editedAt,omitempty"`
+	Deleted     bool            `json:"deleted,omitempty"`
+	ParentID    *int64          `json:"parentId,omitempty"`
+	ReplyCount  int             `json:"replyCount,omitempty"`
+	LastReplyAt *time.Time      `json:"lastReplyAt,omitempty"`
+	Mentions    []mention       `json:"mentions,omitempty"`
+	Reactions   []reactionCount `json:"reactions,omitempty"`
+}
+
+// globalMessages loads a page of the global messages matching cond, as seen
+// by uid.
+func (s *Service) globalMessages(ctx context.Context, uid int64, cond string, args web.Args, p page) ([]globalMsg, bool, error) {
+	rows, err := s.db.Query(ctx, `
 		SELECT gm.id, gm.author_id, COALESCE(u.first_name,'')||' '||COALESCE(u.last_name,'') as author,
-		       gm.body, gm.created_at
+		       gm.body, gm.created_at, gm.edited_at, gm.deleted_at IS NOT NULL, gm.parent_id,
+		       t.replies, t.last_reply_at
 		FROM global_messages gm
 		LEFT JOIN users u ON u.id = gm.author_id
-		ORDER BY gm.created_at DESC
-		LIMIT $1
-	`, limit)
+		LEFT JOIN LATERAL (
+			SELECT count(*) AS replies, max(created_at) AS last_reply_at FROM global_messages
+			WHERE parent_id = gm.id AND deleted_at IS NULL
+		) t ON true
+		WHERE `+cond+p.clause("gm.id", &args), args...)
 	if err != nil {
-		http.Error(w, err.Error(), 500)
-		return
+		return nil, false, err
 	}
 	defer rows.Close()
-	type Msg struct {
-		ID        int64     `json:"id"`
-		AuthorID  int64     `json:"authorId"`
-		Author    string    `json:"author"`
-		Body      string    `json:"body"`
-		CreatedAt time.Time `json:"createdAt"`
-	}
-	var items []Msg
+	items := []globalMsg{}
 	for rows.Next() {
-		var m Msg
-		if err := rows.Scan(&m.ID, &m.AuthorID, &m.Author, &m.Body, &m.CreatedAt); err == nil {
+		var m globalMsg
+		if err := rows.Scan(&m.ID, &m.AuthorID, &m.Author, &m.Body, &m.CreatedAt, &m.EditedAt, &m.Deleted, &m.ParentID,
+			&m.ReplyCount, &m.LastReplyAt); err == nil {
 			items = append(items, m)
 		}
 	}
-	web.JSON(w, 200, map[string]any{"items": items})
+	if err := rows.Err(); err != nil {
+		return nil, false, err
+	}
+	items, more := finishPage(p, items, func(m globalMsg) int64 { return m.ID })
+	ids := make([]int64, len(items))
+	for i, m := range items {
+		ids[i] = m.ID
+	}
+	byMsg, err := s.messageMentions(ctx, "global", ids)
+	if err != nil {
+		return nil, false, err
+	}
+	reactions, err := s.messageReactions(ctx, "global", ids, uid)
+	if err != nil {
+		return nil, false, err
+	}
+	for i := range items {
+		items[i].Mentions = byMsg[items[i].ID]
+		items[i].Reactions = reactions[items[i].ID]
+	}
+	return items, more, nil
 }
 
 func (s *Service) GlobalPost(w http.ResponseWriter, r *http.Request) {
 	uid := auth.UserID(r)
-	var ok bool
-	if err := s.db.QueryRow(r.Context(), `SELECT EXISTS(SELECT 1 FROM user_roles WHERE user_id=$1 AND role IN ('student','mentor'))`, uid).Scan(&ok); err != nil || !ok {
+	if ok, err := s.canPostGlobal(r.Context(), uid); err != nil || !ok {
 		http.Error(w, "forbidden", 403)
 		return
 	}
 	var in struct {
-		Body string `json:"body"`
+		Body     string `json:"body"`
+		ClientID string `json:"clientId"`
+		ParentID int64  `json:"parentId"`
 	}
-	if err := web.DecodeJSON(r, &in); err != nil || in.Body == "" {
+	if err := web.DecodeJSON(r, &in); err != nil || len(in.ClientID) > maxClientIDLen || in.ParentID < 0 {
 		http.Error(w, "bad body", 400)
 		return
 	}
-	var id int64
-	if err := s.db.QueryRow(r.Context(), `INSERT INTO global_messages(author_id, body) VALUES($1,$2) RETURNING id`, uid, in.Body).Scan(&id); err != nil {
-		http.Error(w, err.Error(), 500)
+	body, err := validateBody(in.Body)
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	payload, _, err := s.postGlobal(r.Context(), uid, body, in.ClientID, in.ParentID)
+	if err != nil {
+		http.Error(w, err.Error(), httpStatus(err))
 		return
 	}
-	payload := map[string]any{"type": "message", "scope": "global", "id": id, "authorId": uid, "body": in.Body, "createdAt": time.Now().UTC()}
-	s.hub.Broadcast("global", payload)
 	web.JSON(w, 200, payload)
 }
 
+func (s *Service) canPostGlobal(ctx context.Context, uid int64) (bool, error) {
+	var ok bool
+	err := s.db.QueryRow(ctx, `SELECT EXISTS(SELECT 1 FROM user_roles WHERE user_id=$1 AND role IN ('student','mentor'))`, uid).Scan(&ok)
+	return ok, err
+}
+
+// postGlobal stores and broadcasts a global message, a reply in the thread
+// of parentID when that is set. A repeated clientId returns the stored
+// message instead, with duplicate=true; retries of a message that got
+// through are not held to slow mode.
+func (s *Service) postGlobal(ctx context.Context, uid int64, body, clientID string, parentID int64) (map[string]any, bool, error) {
+	var id int64
+	var createdAt time.Time
+	var root *int64
+	if clientID != "" {
+		err := s.db.QueryRow(ctx, `
+			SELECT id, body, created_at, parent_id FROM global_messages WHERE author_id=$1 AND client_id=$2
+		`, uid, clientID).Scan(&id, &body, &createdAt, &root)
+		if err == nil {
+			byMsg, err := s.messageMentions(ctx, "global", []int64{id})
+			if err != nil {
+				return nil, false, err
+			}
+			return withParent(withMentions(globalFrame(id, uid, body, createdAt, clientID), byMsg[id]), root), true, nil
+		}
+		if !errors.Is(err, pgx.ErrNoRows) {
+			return nil, false, err
+		}
+	}
+	if err := s.checkGlobalPost(ctx, uid, body); err != nil {
+		return nil, false, err
+	}
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+	defer tx.Rollback(ctx)
+	if err := s.checkSlowMode(ctx, tx, uid); err != nil {
+		return nil, false, err
+	}
+	if parentID > 0 {
+		r, err := threadRoot(ctx, tx, parentID)
+		if err != nil {
+			return nil, false, err
+		}
+		root = &r
+	}
+	err = tx.QueryRow(ctx, `
+		INSERT INTO global_messages(author_id, body, client_id, parent_id) VALUES($1,$2,NULLIF($3,''),$4)
+		ON CONFLICT (author_id, client_id) WHERE client_id IS NOT NULL DO NOTHING
+		RETURNING id, created_at
+	`, uid, body, clientID, root).Scan(&id, &createdAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		// A concurrent retry won the insert.
+		tx.Rollback(ctx)
+		return s.postGlobal(ctx, uid, body, clientID, parentID)
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	ref := mentionRef{scope: "global", messageID: id}
+	ms, notified, err := s.saveMentions(ctx, tx, ref, uid, body)
+	if err != nil {
+		return nil, false, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, false, err
+	}
+	payload := withParent(withMentions(globalFrame(id, uid, body, createdAt, clientID), ms), root)
+	if root != nil {
+		s.hub.Broadcast(threadRoom(*root), payload)
+		s.broadcastThread(ctx, *root)
+	} else {
+		s.hub.Broadcast("global", payload)
+	}
+	s.pushMentions(ref, uid, body, notified)
+	return payload, false, nil
+}
+
+// withParent marks a global message frame as a reply in thread root.
+func withParent(f map[string]any, root *int64) map[string]any {
+	if root != nil {
+		f["parentId"] = *root
+	}
+	return f
+}
+
 func (s *Service) GlobalWS(w http.ResponseWriter, r *http.Request) {
 	uid := auth.UserID(r)
-	var ok bool
-	if err := s.db.QueryRow(r.Context(), `SELECT EXISTS(SELECT 1 FROM user_roles WHERE user_id=$1 AND role IN ('student','mentor'))`, uid).Scan(&ok); err != nil || !ok {
+	if ok, err := s.canReadGlobal(r.Context(), uid); err != nil || !ok {
 		http.Error(w, "forbidden", 403)
 		return
 	}
+	since, err := queryID(r, "since")
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
 	conn, err := s.upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		http.Error(w, err.Error(), 400)
 		return
 	}
+	c := newClient(conn, uid)
 	room := "global"
-	s.hub.Join(room, conn)
-	defer func() { s.hub.Leave(room, conn); conn.Close() }()
-
-	// simple read loop to drain client pings; no specific incoming messages for global
-	for {
-		if _, _, err := conn.ReadMessage(); err != nil {
+	if since > 0 {
+		c.hold()
+	}
+	threads := &threadSubscriptions{s: s, c: c, rooms: map[string]bool{}}
+	s.hub.Join(room, c)
+	defer func() { s.hub.Leave(room, c); threads.leaveAll(); c.close() }()
+	if since > 0 {
+		if err := s.startReplay(r.Context(), c, s.replayGlobal(since, uid)); err != nil {
 			return
 		}
 	}
+
+	handlers := s.globalHandlers(uid, 0)
+	handlers["subscribe"] = threads.handler(true)
+	handlers["unsubscribe"] = threads.handler(false)
+	serve(r.Context(), c, handlers)
+}
+
+// globalHandlers are the frames uid can send to the global room. Sends
+// without a parentId go to thread root, if set.
+func (s *Service) globalHandlers(uid, root int64) map[string]frameHandler {
+	return map[string]frameHandler{
+		"send": func(ctx context.Context, c *client, f inFrame) (*ackFrame, error) {
+			body, ids, err := validateSend(f)
+			if err != nil {
+				return nil, err
+			}
+			if len(ids) > 0 {
+				return nil, invalidFrame("attachments are only supported in conversations")
+			}
+			parentID := f.ParentID
+			if parentID == 0 {
+				parentID = root
+			}
+			payload, dup, err := s.postGlobal(ctx, uid, body, f.ClientID, parentID)
+			if err != nil {
+				return nil, err
+			}
+			return &ackFrame{ID: payload["id"].(int64), CreatedAt: payload["createdAt"], Duplicate: dup}, nil
+		},
+		"edit":    s.editHandler(globalScope),
+		"delete":  s.deleteHandler(globalScope),
+		"react":   s.reactHandler(globalScope, true),
+		"unreact": s.reactHandler(globalScope, false),
+	}
 }
 
 type conversation struct {
@@ -119,38 +354,15 @@ type conversation struct {
 	MentorID  int64
 }
 
-func (s *Service) ListConversations(w http.ResponseWriter, r *http.Request) {
-	uid := auth.UserID(r)
-	rows, err := s.db.Query(r.Context(), `
-		SELECT c.id, c.student_id, c.mentor_id,
-		       CASE WHEN c.student_id=$1 THEN COALESCE(mu.first_name,'')||' '||COALESCE(mu.last_name,'')
-		            ELSE COALESCE(su.first_name,'')||' '||COALESCE(su.last_name,'') END as peer_name
-		FROM conversations c
-		LEFT JOIN users su ON su.id = c.student_id
-		LEFT JOIN users mu ON mu.id = c.mentor_id
-		WHERE c.student_id=$1 OR c.mentor_id=$1
-		ORDER BY c.id DESC
-	`, uid)
-	if err != nil {
-		http.Error(w, err.Error(), 500)
-		return
-	}
-	defer rows.Close()
-
-	type Item struct {
-		ID        int64  `json:"id"`
-		StudentID int64  `json:"studentId"`
-		MentorID  int64  `json:"mentorId"`
-		Peer      string `json:"peer"`
-	}
-	var items []Item
-	for rows.Next() {
-		var it Item
-		if err := rows.Scan(&it.ID, &it.StudentID, &it.MentorID, &it.Peer); err == nil {
-			items = append(items, it)
-		}
+// authorType is the author_type uid posts as, or "" for outsiders.
+func (c conversation) authorType(uid int64) string {
+	switch uid {
+	case c.StudentID:
+		return "student"
+	case c.MentorID:
+		return "mentor"
 	}
-	web.JSON(w, 200, map[string]any{"items": items})
+	return ""
 }
 
 func (s *Service) EnsureConversation(w http.ResponseWriter, r *http.Request) {
@@ -199,16 +411,16 @@ func (s *Service) History(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "forbidden", 403)
 		return
 	}
-	limit := web.QueryInt(r, "limit", 50)
-	if limit <= 0 || limit > 200 {
-		limit = 50
+	p, err := parsePage(r)
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
 	}
+	args := web.Args{convID}
 	rows, err := s.db.Query(r.Context(), `
-		SELECT id, author_id, author_type, body, created_at
-		FROM messages WHERE conversation_id=$1
-		ORDER BY created_at DESC
-		LIMIT $2
-	`, convID, limit)
+		SELECT id, author_id, author_type, body, created_at, delivered_at, read_at, edited_at, deleted_at IS NOT NULL,
+		       COALESCE(event_type,''), metadata
+		FROM messages WHERE conversation_id=$1`+p.clause("id", &args), args...)
 	if err != nil {
 		http.Error(w, err.Error(), 500)
 		return
@@ -216,20 +428,54 @@ func (s *Service) History(w http.ResponseWriter, r *http.Request) {
 	defer rows.Close()
 
 	type Msg struct {
-		ID         int64     `json:"id"`
-		AuthorID   int64     `json:"authorId"`
-		AuthorType string    `json:"authorType"`
-		Body       string    `json:"body"`
-		CreatedAt  time.Time `json:"createdAt"`
-	}
-	var items []Msg
+		ID          int64           `json:"id"`
+		AuthorID    int64           `json:"authorId"`
+		AuthorType  string          `json:"authorType"`
+		Body        string          `json:"body"`
+		CreatedAt   time.Time       `json:"createdAt"`
+		Status      string          `json:"status"`
+		DeliveredAt *time.Time      `json:"deliveredAt,omitempty"`
+		ReadAt      *time.Time      `json:"readAt,omitempty"`
+		EditedAt    *time.Time      `json:"editedAt,omitempty"`
+		Deleted     bool            `json:"deleted,omitempty"`
+		EventType   string          `json:"eventType,omitempty"`
+		Metadata    json.RawMessage `json:"metadata,omitempty"`
+		Attachments []attachment    `json:"attachments,omitempty"`
+		Reactions   []reactionCount `json:"reactions,omitempty"`
+	}
+	items := []Msg{}
 	for rows.Next() {
 		var m Msg
-		if err := rows.Scan(&m.ID, &m.AuthorID, &m.AuthorType, &m.Body, &m.CreatedAt); err == nil {
+		if err := rows.Scan(&m.ID, &m.AuthorID, &m.AuthorType, &m.Body, &m.CreatedAt, &m.DeliveredAt, &m.ReadAt, &m.EditedAt, &m.Deleted,
+			&m.EventType, &m.Metadata); err == nil {
+			m.Status = messageStatus(m.DeliveredAt, m.ReadAt)
 			items = append(items, m)
 		}
 	}
-	web.JSON(w, 200, map[string]any{"items": items})
+	items, more := finishPage(p, items, func(m Msg) int64 { return m.ID })
+	ids := make([]int64, len(items))
+	for i, m := range items {
+		ids[i] = m.ID
+	}
+	byMsg, err := s.messageAttachments(r.Context(), ids)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	reactions, err := s.messageReactions(r.Context(), "conversation", ids, uid)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	now := time.Now()
+	for i := range items {
+		items[i].Attachments = byMsg[items[i].ID]
+		items[i].Reactions = reactions[items[i].ID]
+		for j := range items[i].Attachments {
+			s.signAttachment(&items[i].Attachments[j], uid, now)
+		}
+	}
+	web.JSON(w, 200, map[string]any{"items": items, "hasMore": more})
 }
 
 func (s *Service) PostMessage(w http.ResponseWriter, r *http.Request) {
@@ -239,44 +485,88 @@ func (s *Service) PostMessage(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "bad id", 400)
 		return
 	}
-	conv, err := s.getConversation(r, convID)
+	conv, err := s.getConversation(r.Context(), convID)
 	if err != nil {
 		http.Error(w, "not found", 404)
 		return
 	}
-	atype := ""
-	switch uid {
-	case conv.StudentID:
-		atype = "student"
-	case conv.MentorID:
-		atype = "mentor"
-	default:
+	if conv.authorType(uid) == "" {
 		http.Error(w, "forbidden", 403)
 		return
 	}
 	var in struct {
-		Body string `json:"body"`
+		Body          string  `json:"body"`
+		ClientID      string  `json:"clientId"`
+		AttachmentIDs []int64 `json:"attachmentIds"`
 	}
-	if err := web.DecodeJSON(r, &in); err != nil || in.Body == "" {
+	if err := web.DecodeJSON(r, &in); err != nil || len(in.ClientID) > maxClientIDLen {
 		http.Error(w, "bad body", 400)
 		return
 	}
-	var msgID int64
-	if err := s.db.QueryRow(r.Context(), `
-		INSERT INTO messages(conversation_id, author_id, author_type, body)
-		VALUES($1,$2,$3,$4) RETURNING id
-	`, convID, uid, atype, in.Body).Scan(&msgID); err != nil {
-		http.Error(w, err.Error(), 500)
+	body, ids, err := validateMessage(in.Body, in.AttachmentIDs)
+	if err != nil {
+		http.Error(w, err.Error(), 400)
 		return
 	}
-	payload := map[string]any{
-		"type": "message", "conversationId": convID, "id": msgID, "authorId": uid, "authorType": atype, "body": in.Body, "createdAt": time.Now().UTC(),
+	payload, _, err := s.postMessage(r.Context(), conv, uid, body, in.ClientID, ids)
+	if err != nil {
+		http.Error(w, err.Error(), httpStatus(err))
+		return
 	}
-	room := roomName(convID)
-	s.hub.Broadcast(room, payload)
 	web.JSON(w, 200, payload)
 }
 
+// postMessage stores a conversation message with the given uploads,
+// broadcasts it and records delivery. A repeated clientId returns the stored
+// message instead, with duplicate=true.
+func (s *Service) postMessage(ctx context.Context, conv conversation, uid int64, body, clientID string, attachmentIDs []int64) (map[string]any, bool, error) {
+	atype := conv.authorType(uid)
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+	defer tx.Rollback(ctx)
+	var id int64
+	var createdAt time.Time
+	err = tx.QueryRow(ctx, `
+		INSERT INTO messages(conversation_id, author_id, author_type, body, client_id)
+		VALUES($1,$2,$3,$4,NULLIF($5,''))
+		ON CONFLICT (conversation_id, author_id, client_id) WHERE client_id IS NOT NULL DO NOTHING
+		RETURNING id, created_at
+	`, conv.ID, uid, atype, body, clientID).Scan(&id, &createdAt)
+	dup := errors.Is(err, pgx.ErrNoRows)
+	if dup {
+		err = tx.QueryRow(ctx, `
+			SELECT id, body, created_at FROM messages WHERE conversation_id=$1 AND author_id=$2 AND client_id=$3
+		`, conv.ID, uid, clientID).Scan(&id, &body, &createdAt)
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	var atts []attachment
+	if !dup && len(attachmentIDs) > 0 {
+		if atts, err = attachToMessage(ctx, tx, conv.ID, id, uid, attachmentIDs); err != nil {
+			return nil, false, err
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, false, err
+	}
+	if dup {
+		byMsg, err := s.messageAttachments(ctx, []int64{id})
+		if err != nil {
+			return nil, false, err
+		}
+		atts = byMsg[id]
+	}
+	payload := withAttachments(messageFrame(conv.ID, id, uid, atype, body, createdAt, clientID), atts)
+	if !dup {
+		s.hub.Broadcast(roomName(conv.ID), payload)
+		s.touchConversation(conv.ID)
+	}
+	return payload, dup, nil
+}
+
 func (s *Service) ChatWS(w http.ResponseWriter, r *http.Request) {
 	uid := auth.UserID(r)
 	convIDStr := r.URL.Query().Get("conversationId")
@@ -290,38 +580,212 @@ func (s *Service) ChatWS(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	conv, err := s.getConversation(r, convID)
+	conv, err := s.getConversation(r.Context(), convID)
 	if err != nil {
 		http.Error(w, "not found", 404)
 		return
 	}
-	if uid != conv.StudentID && uid != conv.MentorID {
+	if conv.authorType(uid) == "" {
 		http.Error(w, "forbidden", 403)
 		return
 	}
+	since, err := queryID(r, "since")
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
 	conn, err := s.upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		http.Error(w, err.Error(), 400)
 		return
 	}
 
+	// With ?since=<id>, messages after id are replayed before live delivery
+	// starts; broadcasts arriving meanwhile are held and de-duplicated.
+	c := newClient(conn, uid)
 	room := roomName(convID)
-	s.hub.Join(room, conn)
-	defer func() { s.hub.Leave(room, conn); conn.Close() }()
-
-	for {
-		// client sends pings; we keep the socket alive
-		if _, _, err := conn.ReadMessage(); err != nil {
+	if since > 0 {
+		c.hold()
+	}
+	s.hub.Join(room, c)
+	defer func() { s.hub.Leave(room, c); c.close() }()
+	if since > 0 {
+		if err := s.startReplay(r.Context(), c, s.replayConversation(convID, since, uid)); err != nil {
 			return
 		}
 	}
+
+	serve(r.Context(), c, s.conversationHandlers(conv, uid))
+}
+
+// conversationHandlers are the frames uid can send to conversation conv.
+func (s *Service) conversationHandlers(conv conversation, uid int64) map[string]frameHandler {
+	convID, room := conv.ID, roomName(conv.ID)
+	return map[string]frameHandler{
+		"send": func(ctx context.Context, c *client, f inFrame) (*ackFrame, error) {
+			body, ids, err := validateSend(f)
+			if err != nil {
+				return nil, err
+			}
+			payload, dup, err := s.postMessage(ctx, conv, uid, body, f.ClientID, ids)
+			if err != nil {
+				return nil, err
+			}
+			return &ackFrame{ID: payload["id"].(int64), CreatedAt: payload["createdAt"], Duplicate: dup}, nil
+		},
+		"typing": func(ctx context.Context, c *client, f inFrame) (*ackFrame, error) {
+			if f.Active == nil {
+				return nil, invalidFrame("active required")
+			}
+			s.hub.Broadcast(room, map[string]any{
+				"v": ProtocolVersion, "type": "typing", "conversationId": convID, "userId": uid, "active": *f.Active,
+			})
+			return nil, nil
+		},
+		"read": func(ctx context.Context, c *client, f inFrame) (*ackFrame, error) {
+			if f.UpToID < 0 {
+				return nil, invalidFrame("bad upToId")
+			}
+			if _, _, err := s.markRead(ctx, convID, uid, f.UpToID); err != nil {
+				return nil, err
+			}
+			if f.ClientID == "" {
+				return nil, nil
+			}
+			return &ackFrame{}, nil
+		},
+		"edit":    s.editHandler(convScope(convID)),
+		"delete":  s.deleteHandler(convScope(convID)),
+		"react":   s.reactHandler(convScope(convID), true),
+		"unreact": s.reactHandler(convScope(convID), false),
+	}
 }
 
 // --- helpers ---
 
-func (s *Service) getConversation(r *http.Request, id int64) (conversation, error) {
+// messageFrame is the "message" event for a conversation message, as
+// broadcast live and as replayed.
+func messageFrame(convID, id, authorID int64, authorType, body string, createdAt time.Time, clientID string) map[string]any {
+	f := map[string]any{
+		"v": ProtocolVersion, "type": "message", "conversationId": convID, "id": id, "authorId": authorID, "authorType": authorType, "body": body, "createdAt": createdAt,
+	}
+	if clientID != "" {
+		f["clientId"] = clientID
+	}
+	return f
+}
+
+func globalFrame(id, authorID int64, body string, createdAt time.Time, clientID string) map[string]any {
+	f := map[string]any{"v": ProtocolVersion, "type": "message", "scope": "global", "id": id, "authorId": authorID, "body": body, "createdAt": createdAt}
+	if clientID != "" {
+		f["clientId"] = clientID
+	}
+	return f
+}
+
+// replayConversation scans the messages of convID after since, as seen by
+// uid.
+func (s *Service) replayConversation(convID, since, uid int64) func(ctx context.Context) ([]map[string]any, error) {
+	return func(ctx context.Context) ([]map[string]any, error) {
+		rows, err := s.db.Query(ctx, `
+			SELECT id, author_id, author_type, body, created_at, COALESCE(client_id,''), edited_at, deleted_at IS NOT NULL,
+			       COALESCE(event_type,''), metadata
+			FROM messages WHERE conversation_id=$1 AND id > $2
+			ORDER BY id LIMIT $3
+		`, convID, since, maxReplay+1)
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+		var frames []map[string]any
+		for rows.Next() {
+			var id, authorID int64
+			var atype, body, clientID string
+			var createdAt time.Time
+			var editedAt *time.Time
+			var deleted bool
+			var eventType string
+			var metadata json.RawMessage
+			if err := rows.Scan(&id, &authorID, &atype, &body, &createdAt, &clientID, &editedAt, &deleted, &eventType, &metadata); err != nil {
+				return nil, err
+			}
+			f := withEdits(messageFrame(convID, id, authorID, atype, body, createdAt, clientID), editedAt, deleted)
+			frames = append(frames, withSystem(f, eventType, metadata))
+		}
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+		ids := make([]int64, len(frames))
+		for i, f := range frames {
+			ids[i] = f["id"].(int64)
+		}
+		byMsg, err := s.messageAttachments(ctx, ids)
+		if err != nil {
+			return nil, err
+		}
+		reactions, err := s.messageReactions(ctx, "conversation", ids, uid)
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range frames {
+			withAttachments(f, byMsg[f["id"].(int64)])
+			withReactions(f, reactions[f["id"].(int64)])
+		}
+		return frames, nil
+	}
+}
+
+// replayGlobal scans the top-level global messages after since, as seen by
+// uid.
+func (s *Service) replayGlobal(since, uid int64) func(ctx context.Context) ([]map[string]any, error) {
+	return func(ctx context.Context) ([]map[string]any, error) {
+		rows, err := s.db.Query(ctx, `
+			SELECT id, author_id, body, created_at, COALESCE(client_id,''), edited_at, deleted_at IS NOT NULL
+			FROM global_messages WHERE id > $1 AND parent_id IS NULL
+			ORDER BY id LIMIT $2
+		`, since, maxReplay+1)
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+		var frames []map[string]any
+		for rows.Next() {
+			var id, authorID int64
+			var body, clientID string
+			var createdAt time.Time
+			var editedAt *time.Time
+			var deleted bool
+			if err := rows.Scan(&id, &authorID, &body, &createdAt, &clientID, &editedAt, &deleted); err != nil {
+				return nil, err
+			}
+			frames = append(frames, withEdits(globalFrame(id, authorID, body, createdAt, clientID), editedAt, deleted))
+		}
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+		ids := make([]int64, len(frames))
+		for i, f := range frames {
+			ids[i] = f["id"].(int64)
+		}
+		byMsg, err := s.messageMentions(ctx, "global", ids)
+		if err != nil {
+			return nil, err
+		}
+		reactions, err := s.messageReactions(ctx, "global", ids, uid)
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range frames {
+			withMentions(f, byMsg[f["id"].(int64)])
+			withReactions(f, reactions[f["id"].(int64)])
+		}
+		return frames, nil
+	}
+}
+
+func (s *Service) getConversation(ctx context.Context, id int64) (conversation, error) {
 	var c conversation
-	err := s.db.QueryRow(r.Context(), `SELECT id, student_id, mentor_id FROM conversations WHERE id=$1`, id).
+	err := s.db.QueryRow(ctx, `SELECT id, student_id, mentor_id FROM conversations WHERE id=$1`, id).
 		Scan(&c.ID, &c.StudentID, &c.MentorID)
 	return c, err
 }
@@ -0,0 +1,84 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+)
+
+// System messages are timeline entries other packages post into a
+// conversation when something happens between its participants. They are
+// stored with author_type "system", the acting user as author, and a
+// structured event clients can render as a card:
+//
+//	{"v":1,"type":"message","conversationId":3,"id":40,"authorType":"system","authorId":7,
+//	 "body":"Mentorship paused","eventType":"mentorship.paused","metadata":{"mentorshipId":5}}
+//
+// The body is a plain-text fallback. System messages cannot be edited or
+// deleted.
+
+// Event types of system messages.
+const (
+	EventMentorshipApproved    = "mentorship.approved"
+	EventMentorshipPaused      = "mentorship.paused"
+	EventMentorshipResumed     = "mentorship.resumed"
+	EventMentorshipEnded       = "mentorship.ended"
+	EventMentorshipTransferred = "mentorship.transferred"
+	EventPlanShared            = "plan.shared"
+	EventSessionBooked         = "session.booked"
+)
+
+var errSystemMessage = &frameError{codeForbidden, "system messages cannot be changed"}
+
+// PostSystem posts a system message about event into the conversation
+// between studentID and mentorID, creating the conversation if needed, and
+// broadcasts it. It runs in its own transaction, so callers post once
+// their own change has committed.
+func (s *Service) PostSystem(ctx context.Context, studentID, mentorID, actorID int64, event, body string, metadata map[string]any) (int64, error) {
+	if metadata == nil {
+		metadata = map[string]any{}
+	}
+	raw, err := json.Marshal(metadata)
+	if err != nil {
+		return 0, err
+	}
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+	var convID, id int64
+	var createdAt time.Time
+	if err := tx.QueryRow(ctx, `
+		INSERT INTO conversations(student_id, mentor_id)
+		VALUES($1,$2)
+		ON CONFLICT (student_id, mentor_id) DO UPDATE SET student_id=EXCLUDED.student_id
+		RETURNING id
+	`, studentID, mentorID).Scan(&convID); err != nil {
+		return 0, err
+	}
+	if err := tx.QueryRow(ctx, `
+		INSERT INTO messages(conversation_id, author_id, author_type, body, event_type, metadata)
+		VALUES($1,$2,'system',$3,$4,$5) RETURNING id, created_at
+	`, convID, actorID, body, event, raw).Scan(&id, &createdAt); err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return 0, err
+	}
+	f := withSystem(messageFrame(convID, id, actorID, "system", body, createdAt, ""), event, raw)
+	s.hub.Broadcast(roomName(convID), f)
+	s.touchConversation(convID)
+	return id, nil
+}
+
+// withSystem adds the event of a system message to its frame.
+func withSystem(f map[string]any, eventType string, metadata json.RawMessage) map[string]any {
+	if eventType != "" {
+		f["eventType"] = eventType
+		if len(metadata) > 0 {
+			f["metadata"] = metadata
+		}
+	}
+	return f
+}
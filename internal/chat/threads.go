@@ -0,0 +1,161 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	"upskill/internal/auth"
+	"upskill/internal/web"
+)
+
+// Threads. A global message can reply to another one; a reply to a reply
+// joins the thread of the first message, so threads are one level deep.
+// Replies stay out of the global room and GlobalHistory. They are broadcast
+// to thread:<id>, which a global socket joins with
+//
+//	{"v":1,"type":"subscribe","clientId":"c-6","messageId":12}
+//	{"v":1,"type":"unsubscribe","clientId":"c-7","messageId":12}
+//
+// and the global room gets the new thread summary instead:
+//
+//	{"v":1,"type":"thread","id":12,"replyCount":4,"lastReplyAt":"..."}
+
+const maxThreadSubscriptions = 50
+
+func threadRoom(id int64) string { return "thread:" + strconv.FormatInt(id, 10) }
+
+// threadRoot returns the message a reply to parentID belongs under.
+func threadRoot(ctx context.Context, tx pgx.Tx, parentID int64) (int64, error) {
+	var root int64
+	var deletedAt *time.Time
+	err := tx.QueryRow(ctx, `SELECT COALESCE(parent_id, id), deleted_at FROM global_messages WHERE id=$1`, parentID).Scan(&root, &deletedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, errMessageNotFound
+	}
+	if err != nil {
+		return 0, err
+	}
+	if deletedAt != nil {
+		return 0, errMessageDeleted
+	}
+	return root, nil
+}
+
+// eventRoom is the room for events about message id in sc, and the thread it
+// is a reply in, if any.
+func eventRoom(ctx context.Context, tx pgx.Tx, sc msgScope, id int64) (string, int64, error) {
+	if sc.convID != 0 {
+		return sc.room, 0, nil
+	}
+	var parentID *int64
+	if err := tx.QueryRow(ctx, `SELECT parent_id FROM global_messages WHERE id=$1`, id).Scan(&parentID); err != nil {
+		return "", 0, err
+	}
+	if parentID == nil {
+		return sc.room, 0, nil
+	}
+	return threadRoom(*parentID), *parentID, nil
+}
+
+// broadcastThread sends the current summary of thread root to the global
+// room.
+func (s *Service) broadcastThread(ctx context.Context, root int64) {
+	var count int
+	var last *time.Time
+	if err := s.db.QueryRow(ctx, `
+		SELECT count(*), max(created_at) FROM global_messages WHERE parent_id=$1 AND deleted_at IS NULL
+	`, root).Scan(&count, &last); err != nil {
+		log.Printf("chat: thread %d summary: %v", root, err)
+		return
+	}
+	f := map[string]any{"v": ProtocolVersion, "type": "thread", "id": root, "replyCount": count}
+	if last != nil {
+		f["lastReplyAt"] = *last
+	}
+	s.hub.Broadcast("global", f)
+}
+
+// GlobalThread returns a thread's first message and a page of its replies,
+// paged like GlobalHistory. Asking for a reply returns its whole thread.
+func (s *Service) GlobalThread(w http.ResponseWriter, r *http.Request) {
+	uid := auth.UserID(r)
+	if ok, err := s.canReadGlobal(r.Context(), uid); err != nil || !ok {
+		http.Error(w, "forbidden", 403)
+		return
+	}
+	id, err := web.ParamInt64(r, "messageId")
+	if err != nil {
+		http.Error(w, "bad id", 400)
+		return
+	}
+	p, err := parsePage(r)
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	var root int64
+	if err := s.db.QueryRow(r.Context(), `SELECT COALESCE(parent_id, id) FROM global_messages WHERE id=$1`, id).Scan(&root); err != nil {
+		http.Error(w, "not found", 404)
+		return
+	}
+	parent, _, err := s.globalMessages(r.Context(), uid, `gm.id = $1`, web.Args{root}, page{limit: 1})
+	if err != nil || len(parent) == 0 {
+		http.Error(w, "not found", 404)
+		return
+	}
+	items, more, err := s.globalMessages(r.Context(), uid, `gm.parent_id = $1`, web.Args{root}, p)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	web.JSON(w, 200, map[string]any{"parent": parent[0], "items": items, "hasMore": more})
+}
+
+// threadSubscriptions tracks the thread rooms one global socket joined.
+// Frames of a socket are handled one at a time, so it needs no lock.
+type threadSubscriptions struct {
+	s     *Service
+	c     *client
+	rooms map[string]bool
+}
+
+func (t *threadSubscriptions) handler(join bool) frameHandler {
+	return func(ctx context.Context, c *client, f inFrame) (*ackFrame, error) {
+		if f.MessageID <= 0 {
+			return nil, invalidFrame("messageId required")
+		}
+		var root int64
+		err := t.s.db.QueryRow(ctx, `SELECT COALESCE(parent_id, id) FROM global_messages WHERE id=$1`, f.MessageID).Scan(&root)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, errMessageNotFound
+		}
+		if err != nil {
+			return nil, err
+		}
+		room := threadRoom(root)
+		switch {
+		case join && !t.rooms[room]:
+			if len(t.rooms) >= maxThreadSubscriptions {
+				return nil, invalidFrame("too many thread subscriptions")
+			}
+			t.rooms[room] = true
+			t.s.hub.Join(room, t.c)
+		case !join && t.rooms[room]:
+			delete(t.rooms, room)
+			t.s.hub.Leave(room, t.c)
+		}
+		return &ackFrame{ID: root}, nil
+	}
+}
+
+func (t *threadSubscriptions) leaveAll() {
+	for room := range t.rooms {
+		t.s.hub.Leave(room, t.c)
+	}
+}
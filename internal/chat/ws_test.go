@@ -0,0 +1,828 @@
+package chat_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"upskill/internal/config"
+	"upskill/internal/db"
+	"upskill/internal/server"
+)
+
+// These tests run the full HTTP stack against a real database. Point
+// TEST_DATABASE_URL at a disposable Postgres to run them.
+
+type env struct {
+	srv  *httptest.Server
+	pool *pgxpool.Pool
+}
+
+func newEnv(t *testing.T) *env {
+	t.Helper()
+	url := os.Getenv("TEST_DATABASE_URL")
+	if url == "" {
+		t.Skip("TEST_DATABASE_URL not set")
+	}
+	pool, err := pgxpool.New(context.Background(), url)
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	t.Cleanup(pool.Close)
+	if err := db.RunMigrations(pool); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	// Cancelling stops the service's listeners before the pool closes.
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	srv := httptest.NewServer(server.New(ctx, config.Config{AllowedOrigins: []string{"*"}}, pool))
+	t.Cleanup(srv.Close)
+	return &env{srv: srv, pool: pool}
+}
+
+func (e *env) call(t *testing.T, method, path, token string, in any) *http.Response {
+	t.Helper()
+	var body bytes.Buffer
+	if in != nil {
+		_ = json.NewEncoder(&body).Encode(in)
+	}
+	req, _ := http.NewRequest(method, e.srv.URL+"/api"+path, &body)
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, path, err)
+	}
+	return res
+}
+
+func (e *env) do(t *testing.T, method, path, token string, in any) map[string]any {
+	t.Helper()
+	res := e.call(t, method, path, token, in)
+	defer res.Body.Close()
+	if res.StatusCode >= 300 {
+		t.Fatalf("%s %s: status %d", method, path, res.StatusCode)
+	}
+	var out map[string]any
+	_ = json.NewDecoder(res.Body).Decode(&out)
+	return out
+}
+
+type user struct {
+	id    int64
+	token string
+}
+
+func (e *env) register(t *testing.T, role string) user {
+	t.Helper()
+	email := fmt.Sprintf("ws-%s-%d@example.com", role, time.Now().UnixNano())
+	out := e.do(t, "POST", "/auth/register", "", map[string]any{"email": email, "password": "secret123"})
+	u := user{token: out["accessToken"].(string), id: int64(out["user"].(map[string]any)["id"].(float64))}
+	e.do(t, "POST", "/roles", u.token, map[string]any{"role": role})
+	return u
+}
+
+// status makes a request and returns only its status code.
+func (e *env) status(t *testing.T, method, path, token string, in any) int {
+	t.Helper()
+	res := e.call(t, method, path, token, in)
+	res.Body.Close()
+	return res.StatusCode
+}
+
+// moderator registers a student and grants them the moderator role, which
+// the API does not hand out.
+func (e *env) moderator(t *testing.T) user {
+	t.Helper()
+	u := e.register(t, "student")
+	if _, err := e.pool.Exec(context.Background(), `INSERT INTO user_roles(user_id, role) VALUES($1,'moderator')`, u.id); err != nil {
+		t.Fatalf("grant moderator: %v", err)
+	}
+	return u
+}
+
+// pair creates a student and a mentor with an active mentorship and returns
+// their conversation id.
+func (e *env) pair(t *testing.T) (student, mentor user, convID int64) {
+	t.Helper()
+	student, mentor = e.register(t, "student"), e.register(t, "mentor")
+	out := e.do(t, "POST", "/mentorship/requests", student.token, map[string]any{"mentorId": mentor.id})
+	e.do(t, "POST", fmt.Sprintf("/mentor/requests/%d/approve", int64(out["requestId"].(float64))), mentor.token, nil)
+	out = e.do(t, "POST", "/chat/conversations", student.token, map[string]any{"mentorId": mentor.id})
+	return student, mentor, int64(out["conversationId"].(float64))
+}
+
+func (e *env) dial(t *testing.T, path string, u user) *websocket.Conn {
+	t.Helper()
+	url := "ws" + strings.TrimPrefix(e.srv.URL, "http") + "/api" + path
+	conn, _, err := websocket.DefaultDialer.Dial(url, http.Header{"Authorization": {"Bearer " + u.token}})
+	if err != nil {
+		t.Fatalf("dial %s: %v", path, err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func send(t *testing.T, conn *websocket.Conn, frame string) {
+	t.Helper()
+	if err := conn.WriteMessage(websocket.TextMessage, []byte(frame)); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+}
+
+// next reads frames until one of the given type arrives.
+func next(t *testing.T, conn *websocket.Conn, typ string) map[string]any {
+	t.Helper()
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		var f map[string]any
+		if err := conn.ReadJSON(&f); err != nil {
+			t.Fatalf("waiting for %q: %v", typ, err)
+		}
+		if f["v"] != float64(1) {
+			t.Fatalf("frame without version: %v", f)
+		}
+		if f["type"] == typ {
+			return f
+		}
+	}
+}
+
+func TestChatWSSendAckBroadcast(t *testing.T) {
+	e := newEnv(t)
+	student, mentor, convID := e.pair(t)
+	path := fmt.Sprintf("/ws/chat?conversationId=%d", convID)
+	sc, mc := e.dial(t, path, student), e.dial(t, path, mentor)
+
+	send(t, sc, `{"v":1,"type":"send","clientId":"c-1","body":"hello"}`)
+	ack := next(t, sc, "ack")
+	if ack["clientId"] != "c-1" || ack["id"] == nil || ack["duplicate"] == true {
+		t.Fatalf("bad ack: %v", ack)
+	}
+	msg := next(t, mc, "message")
+	if msg["id"] != ack["id"] || msg["body"] != "hello" || msg["clientId"] != "c-1" {
+		t.Fatalf("bad broadcast: %v", msg)
+	}
+	rc := next(t, mc, "receipt")
+	if rc["status"] != "delivered" || rc["userId"] != float64(mentor.id) {
+		t.Fatalf("bad receipt: %v", rc)
+	}
+}
+
+func TestChatWSDeduplicatesClientID(t *testing.T) {
+	e := newEnv(t)
+	student, _, convID := e.pair(t)
+	sc := e.dial(t, fmt.Sprintf("/ws/chat?conversationId=%d", convID), student)
+
+	send(t, sc, `{"v":1,"type":"send","clientId":"dup","body":"once"}`)
+	first := next(t, sc, "ack")
+	send(t, sc, `{"v":1,"type":"send","clientId":"dup","body":"once"}`)
+	second := next(t, sc, "ack")
+	if second["id"] != first["id"] || second["duplicate"] != true {
+		t.Fatalf("retry not deduplicated: %v then %v", first, second)
+	}
+}
+
+func TestChatWSTypingAndRead(t *testing.T) {
+	e := newEnv(t)
+	student, mentor, convID := e.pair(t)
+	path := fmt.Sprintf("/ws/chat?conversationId=%d", convID)
+	sc, mc := e.dial(t, path, student), e.dial(t, path, mentor)
+
+	send(t, sc, `{"v":1,"type":"typing","active":true}`)
+	if f := next(t, mc, "typing"); f["userId"] != float64(student.id) || f["active"] != true {
+		t.Fatalf("bad typing: %v", f)
+	}
+
+	send(t, sc, `{"v":1,"type":"send","clientId":"r-1","body":"read me"}`)
+	id := next(t, sc, "ack")["id"].(float64)
+	send(t, mc, fmt.Sprintf(`{"v":1,"type":"read","clientId":"r-ack","upToId":%d}`, int64(id)))
+	if ack := next(t, mc, "ack"); ack["clientId"] != "r-ack" {
+		t.Fatalf("bad read ack: %v", ack)
+	}
+	for {
+		f := next(t, sc, "receipt")
+		if f["status"] == "read" {
+			if f["userId"] != float64(mentor.id) {
+				t.Fatalf("bad read receipt: %v", f)
+			}
+			break
+		}
+	}
+}
+
+func TestChatWSErrors(t *testing.T) {
+	e := newEnv(t)
+	student, _, convID := e.pair(t)
+	sc := e.dial(t, fmt.Sprintf("/ws/chat?conversationId=%d", convID), student)
+
+	cases := []struct {
+		name, frame, code string
+	}{
+		{"malformed", `{"v":1,`, "bad_frame"},
+		{"unknown field", `{"v":1,"type":"send","clientId":"x","body":"b","extra":1}`, "bad_frame"},
+		{"version", `{"v":2,"type":"send","clientId":"x","body":"b"}`, "unsupported_version"},
+		{"unknown type", `{"v":1,"type":"shout"}`, "unknown_type"},
+		{"missing client id", `{"v":1,"type":"send","body":"b"}`, "invalid"},
+		{"empty body", `{"v":1,"type":"send","clientId":"x","body":"  "}`, "invalid"},
+		{"typing without state", `{"v":1,"type":"typing"}`, "invalid"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			send(t, sc, tc.frame)
+			if f := next(t, sc, "error"); f["code"] != tc.code {
+				t.Fatalf("want code %s, got %v", tc.code, f)
+			}
+		})
+	}
+}
+
+func TestGlobalWSSend(t *testing.T) {
+	e := newEnv(t)
+	a, b := e.register(t, "student"), e.register(t, "mentor")
+	ac, bc := e.dial(t, "/ws/chat/global", a), e.dial(t, "/ws/chat/global", b)
+
+	clientID := fmt.Sprintf("g-%d", time.Now().UnixNano())
+	send(t, ac, `{"v":1,"type":"send","clientId":"`+clientID+`","body":"hi all"}`)
+	ack := next(t, ac, "ack")
+	for {
+		msg := next(t, bc, "message")
+		if msg["clientId"] == clientID {
+			if msg["id"] != ack["id"] {
+				t.Fatalf("ack %v does not match broadcast %v", ack, msg)
+			}
+			break
+		}
+	}
+	send(t, ac, `{"v":1,"type":"typing","active":true}`)
+	if f := next(t, ac, "error"); f["code"] != "unknown_type" {
+		t.Fatalf("global typing should be rejected: %v", f)
+	}
+}
+
+func TestChatWSReplaySince(t *testing.T) {
+	e := newEnv(t)
+	student, mentor, convID := e.pair(t)
+	post := func(body string) float64 {
+		out := e.do(t, "POST", fmt.Sprintf("/chat/conversations/%d/messages", convID), student.token, map[string]any{"body": body})
+		return out["id"].(float64)
+	}
+	first, second, third := post("one"), post("two"), post("three")
+
+	mc := e.dial(t, fmt.Sprintf("/ws/chat?conversationId=%d&since=%d", convID, int64(first)), mentor)
+	for _, want := range []float64{second, third} {
+		if f := next(t, mc, "message"); f["id"] != want {
+			t.Fatalf("replay out of order: want %v, got %v", want, f)
+		}
+	}
+	if f := next(t, mc, "replayed"); f["count"] != float64(2) || f["lastId"] != third || f["hasMore"] != false {
+		t.Fatalf("bad replayed frame: %v", f)
+	}
+	live := post("four")
+	if f := next(t, mc, "message"); f["id"] != live {
+		t.Fatalf("live message after replay: want %v, got %v", live, f)
+	}
+}
+
+func TestHistoryCursors(t *testing.T) {
+	e := newEnv(t)
+	student, _, convID := e.pair(t)
+	path := fmt.Sprintf("/chat/conversations/%d/messages", convID)
+	var ids []float64
+	for i := 0; i < 5; i++ {
+		ids = append(ids, e.do(t, "POST", path, student.token, map[string]any{"body": fmt.Sprint(i)})["id"].(float64))
+	}
+	pageIDs := func(query string) ([]float64, bool) {
+		out := e.do(t, "GET", path+query, student.token, nil)
+		var res []float64
+		for _, it := range out["items"].([]any) {
+			res = append(res, it.(map[string]any)["id"].(float64))
+		}
+		return res, out["hasMore"].(bool)
+	}
+
+	latest, more := pageIDs("?limit=2")
+	if fmt.Sprint(latest) != fmt.Sprint(ids[3:]) || !more {
+		t.Fatalf("latest page: %v more=%v", latest, more)
+	}
+	older, more := pageIDs(fmt.Sprintf("?limit=2&before=%d", int64(latest[0])))
+	if fmt.Sprint(older) != fmt.Sprint(ids[1:3]) || !more {
+		t.Fatalf("older page: %v more=%v", older, more)
+	}
+	newer, more := pageIDs(fmt.Sprintf("?limit=10&after=%d", int64(ids[0])))
+	if fmt.Sprint(newer) != fmt.Sprint(ids[1:]) || more {
+		t.Fatalf("newer page: %v more=%v", newer, more)
+	}
+}
+
+func TestChatWSEditAndDelete(t *testing.T) {
+	e := newEnv(t)
+	student, mentor, convID := e.pair(t)
+	path := fmt.Sprintf("/ws/chat?conversationId=%d", convID)
+	sc, mc := e.dial(t, path, student), e.dial(t, path, mentor)
+
+	send(t, sc, `{"v":1,"type":"send","clientId":"e-1","body":"draft"}`)
+	id := int64(next(t, sc, "ack")["id"].(float64))
+	send(t, sc, fmt.Sprintf(`{"v":1,"type":"edit","clientId":"e-2","messageId":%d,"body":"final"}`, id))
+	if f := next(t, mc, "edited"); f["id"] != float64(id) || f["body"] != "final" || f["editedAt"] == nil {
+		t.Fatalf("bad edited event: %v", f)
+	}
+	send(t, mc, fmt.Sprintf(`{"v":1,"type":"delete","clientId":"e-3","messageId":%d}`, id))
+	if f := next(t, mc, "error"); f["code"] != "forbidden" {
+		t.Fatalf("peer delete should be forbidden: %v", f)
+	}
+	send(t, sc, fmt.Sprintf(`{"v":1,"type":"delete","clientId":"e-4","messageId":%d}`, id))
+	if f := next(t, mc, "deleted"); f["id"] != float64(id) || f["deletedBy"] != float64(student.id) {
+		t.Fatalf("bad deleted event: %v", f)
+	}
+
+	out := e.do(t, "GET", fmt.Sprintf("/chat/conversations/%d/messages", convID), student.token, nil)
+	m := out["items"].([]any)[0].(map[string]any)
+	if m["deleted"] != true || m["body"] != "" || m["editedAt"] == nil {
+		t.Fatalf("history shows deleted message as %v", m)
+	}
+}
+
+func TestAttachmentUploadAndDownload(t *testing.T) {
+	e := newEnv(t)
+	student, mentor, convID := e.pair(t)
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	fw, _ := mw.CreateFormFile("file", "main.go")
+	_, _ = fw.Write([]byte("package main\n"))
+	mw.Close()
+	req, _ := http.NewRequest("POST", fmt.Sprintf("%s/api/chat/conversations/%d/attachments", e.srv.URL, convID), &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	req.Header.Set("Authorization", "Bearer "+student.token)
+	res, err := http.DefaultClient.Do(req)
+	if err != nil || res.StatusCode != 200 {
+		t.Fatalf("upload: %v %v", err, res.Status)
+	}
+	var up map[string]any
+	_ = json.NewDecoder(res.Body).Decode(&up)
+	res.Body.Close()
+	if up["contentType"] != "text/plain; charset=utf-8" {
+		t.Fatalf("bad upload: %v", up)
+	}
+
+	e.do(t, "POST", fmt.Sprintf("/chat/conversations/%d/messages", convID), student.token, map[string]any{"attachmentIds": []any{up["id"]}})
+	out := e.do(t, "GET", fmt.Sprintf("/chat/conversations/%d/messages", convID), mentor.token, nil)
+	atts := out["items"].([]any)[0].(map[string]any)["attachments"].([]any)
+	url := atts[0].(map[string]any)["url"].(string)
+
+	res, err = http.Get(e.srv.URL + url)
+	if err != nil || res.StatusCode != 200 {
+		t.Fatalf("download: %v %v", err, res.Status)
+	}
+	data, _ := io.ReadAll(res.Body)
+	res.Body.Close()
+	if string(data) != "package main\n" || res.Header.Get("X-Content-Type-Options") != "nosniff" {
+		t.Fatalf("bad download: %q %v", data, res.Header)
+	}
+	if res, _ := http.Get(e.srv.URL + strings.Replace(url, "sig=", "sig=x", 1)); res.StatusCode != 403 {
+		t.Fatalf("tampered link: status %d", res.StatusCode)
+	}
+}
+
+func TestSearch(t *testing.T) {
+	e := newEnv(t)
+	student, mentor, convID := e.pair(t)
+	_, other, otherConv := e.pair(t)
+	word := fmt.Sprintf("needle%d", time.Now().UnixNano())
+	post := func(u user, conv int64, body string) {
+		e.do(t, "POST", fmt.Sprintf("/chat/conversations/%d/messages", conv), u.token, map[string]any{"body": body})
+	}
+	post(mentor, convID, "use a <b>context</b> to stop the "+word)
+	post(student, convID, "thanks, "+word+" found")
+	post(other, otherConv, "not yours: "+word)
+
+	out := e.do(t, "GET", "/chat/search?limit=1&q="+word, student.token, nil)
+	items := out["items"].([]any)
+	if len(items) != 1 || out["nextCursor"] == "" {
+		t.Fatalf("first page: %v", out)
+	}
+	hit := items[0].(map[string]any)
+	if hit["authorId"] != float64(student.id) || !strings.Contains(hit["snippet"].(string), "<mark>"+word+"</mark>") {
+		t.Fatalf("bad hit: %v", hit)
+	}
+	out = e.do(t, "GET", "/chat/search?limit=1&q="+word+"&cursor="+out["nextCursor"].(string), student.token, nil)
+	hit = out["items"].([]any)[0].(map[string]any)
+	if hit["authorId"] != float64(mentor.id) || !strings.Contains(hit["snippet"].(string), "&lt;b&gt;") || out["nextCursor"] != "" {
+		t.Fatalf("second page: %v", out)
+	}
+	out = e.do(t, "GET", fmt.Sprintf("/chat/search?q=%s&authorId=%d", word, mentor.id), student.token, nil)
+	if n := len(out["items"].([]any)); n != 1 {
+		t.Fatalf("author filter: %d hits", n)
+	}
+}
+
+func TestConversationListLive(t *testing.T) {
+	e := newEnv(t)
+	student, mentor, convID := e.pair(t)
+	uc := e.dial(t, "/ws/chat/user", mentor)
+
+	e.do(t, "POST", fmt.Sprintf("/chat/conversations/%d/messages", convID), student.token, map[string]any{"body": "ping"})
+	f := next(t, uc, "conversation")
+	item := f["conversation"].(map[string]any)
+	if item["id"] != float64(convID) || item["unreadCount"] != float64(1) || item["peerRole"] != "student" {
+		t.Fatalf("bad live item: %v", item)
+	}
+	if lm := item["lastMessage"].(map[string]any); lm["preview"] != "ping" {
+		t.Fatalf("bad preview: %v", lm)
+	}
+
+	e.do(t, "POST", fmt.Sprintf("/chat/conversations/%d/read", convID), mentor.token, nil)
+	out := e.do(t, "GET", "/chat/conversations", mentor.token, nil)
+	first := out["items"].([]any)[0].(map[string]any)
+	if first["id"] != float64(convID) || first["unreadCount"] != float64(0) {
+		t.Fatalf("list after read: %v", first)
+	}
+}
+
+func TestGlobalModeration(t *testing.T) {
+	e := newEnv(t)
+	mod, a, b := e.moderator(t), e.register(t, "student"), e.register(t, "mentor")
+	t.Cleanup(func() {
+		_, _ = e.pool.Exec(context.Background(), `UPDATE global_chat_settings SET slow_mode_seconds=0, blocked_words='{}'`)
+	})
+
+	out := e.do(t, "POST", "/chat/global/messages", a.token, map[string]any{"body": "buy cheap stuff"})
+	msgID := int64(out["id"].(float64))
+	e.do(t, "POST", fmt.Sprintf("/chat/global/messages/%d/report", msgID), b.token, map[string]any{"reason": "spam"})
+	if code := e.status(t, "GET", "/chat/moderation/reports", b.token, nil); code != 403 {
+		t.Fatalf("non-moderator read the queue: %d", code)
+	}
+	var reportID int64
+	for _, it := range e.do(t, "GET", "/chat/moderation/reports?limit=200", mod.token, nil)["items"].([]any) {
+		if r := it.(map[string]any); r["messageId"] == float64(msgID) {
+			reportID = int64(r["id"].(float64))
+		}
+	}
+	if reportID == 0 {
+		t.Fatal("report not in the queue")
+	}
+	e.do(t, "POST", fmt.Sprintf("/chat/moderation/reports/%d/resolve", reportID), mod.token, map[string]any{"action": "delete"})
+	if code := e.status(t, "PATCH", fmt.Sprintf("/chat/global/messages/%d", msgID), a.token, map[string]any{"body": "x"}); code != 409 {
+		t.Fatalf("edit of deleted message: %d", code)
+	}
+
+	word := fmt.Sprintf("zorp%d", time.Now().UnixNano())
+	e.do(t, "PUT", "/chat/moderation/settings", mod.token, map[string]any{"slowModeSeconds": 60, "blockedWords": []string{word}})
+	if code := e.status(t, "POST", "/chat/global/messages", b.token, map[string]any{"body": "say " + strings.ToUpper(word) + "!"}); code != 400 {
+		t.Fatalf("blocked word accepted: %d", code)
+	}
+	e.do(t, "POST", "/chat/global/messages", b.token, map[string]any{"body": "first"})
+	bc := e.dial(t, "/ws/chat/global", b)
+	send(t, bc, `{"v":1,"type":"send","clientId":"slow-1","body":"second"}`)
+	if f := next(t, bc, "error"); f["code"] != "rate_limited" {
+		t.Fatalf("slow mode not enforced on the socket: %v", f)
+	}
+
+	if code := e.status(t, "POST", "/chat/moderation/sanctions", mod.token, map[string]any{"userId": 1 << 40, "kind": "mute"}); code != 404 {
+		t.Fatalf("sanction for unknown user: %d", code)
+	}
+	e.do(t, "POST", "/chat/moderation/sanctions", mod.token, map[string]any{"userId": a.id, "kind": "mute", "minutes": 5})
+	if code := e.status(t, "POST", "/chat/global/messages", a.token, map[string]any{"body": "hello"}); code != 403 {
+		t.Fatalf("muted user posted: %d", code)
+	}
+	e.do(t, "GET", "/chat/global/messages", a.token, nil)
+	out = e.do(t, "POST", "/chat/moderation/sanctions", mod.token, map[string]any{"userId": a.id, "kind": "ban"})
+	if code := e.status(t, "GET", "/chat/global/messages", a.token, nil); code != 403 {
+		t.Fatalf("banned user read history: %d", code)
+	}
+	if code := e.status(t, "GET", "/chat/search?scope=global&q=first", a.token, nil); code != 403 {
+		t.Fatalf("banned user searched the global chat: %d", code)
+	}
+	if out := e.do(t, "GET", "/chat/search?q=first", a.token, nil); len(out["items"].([]any)) != 0 {
+		t.Fatalf("banned user got global hits: %v", out)
+	}
+	e.do(t, "DELETE", fmt.Sprintf("/chat/moderation/sanctions/%d", int64(out["id"].(float64))), mod.token, nil)
+	e.do(t, "GET", "/chat/global/messages", a.token, nil)
+
+	actions := map[string]bool{}
+	for _, it := range e.do(t, "GET", "/chat/moderation/log?limit=20", mod.token, nil)["items"].([]any) {
+		if l := it.(map[string]any); l["moderatorId"] == float64(mod.id) {
+			actions[l["action"].(string)] = true
+		}
+	}
+	for _, a := range []string{"delete_message", "update_settings", "mute", "ban", "revoke_sanction"} {
+		if !actions[a] {
+			t.Fatalf("%s missing from the moderation log: %v", a, actions)
+		}
+	}
+}
+
+func TestGlobalMentions(t *testing.T) {
+	e := newEnv(t)
+	a, b := e.register(t, "student"), e.register(t, "mentor")
+	handle := fmt.Sprintf("m%d", time.Now().UnixNano())
+	e.do(t, "PUT", "/user/me/handle", b.token, map[string]any{"handle": "@" + strings.ToUpper(handle)})
+	if me := e.do(t, "GET", "/user/me", b.token, nil)["user"].(map[string]any); me["handle"] != handle {
+		t.Fatalf("handle not saved: %v", me)
+	}
+	if code := e.status(t, "PUT", "/user/me/handle", a.token, map[string]any{"handle": handle}); code != 409 {
+		t.Fatalf("taken handle accepted: %d", code)
+	}
+	bu := e.dial(t, "/ws/chat/user", b)
+
+	out := e.do(t, "POST", "/chat/global/messages", a.token, map[string]any{"body": "ping @" + handle + " and @nobody_here"})
+	ms, _ := out["mentions"].([]any)
+	if len(ms) != 1 || ms[0].(map[string]any)["userId"] != float64(b.id) || ms[0].(map[string]any)["offset"] != float64(5) {
+		t.Fatalf("bad mentions: %v", out["mentions"])
+	}
+	if f := next(t, bu, "mention"); f["messageId"] != out["id"] || f["authorId"] != float64(a.id) {
+		t.Fatalf("bad mention event: %v", f)
+	}
+	found := false
+	for _, it := range e.do(t, "GET", "/notifications", b.token, nil)["items"].([]any) {
+		if n := it.(map[string]any); n["kind"] == "chat.mention" && n["payload"].(map[string]any)["messageId"] == out["id"] {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatal("no mention notification")
+	}
+	hist := e.do(t, "GET", "/chat/global/messages?limit=1", a.token, nil)["items"].([]any)
+	if m := hist[0].(map[string]any); m["mentions"] == nil {
+		t.Fatalf("history without mentions: %v", m)
+	}
+}
+
+func TestReactions(t *testing.T) {
+	e := newEnv(t)
+	student, mentor, convID := e.pair(t)
+	path := fmt.Sprintf("/ws/chat?conversationId=%d", convID)
+	sc, mc := e.dial(t, path, student), e.dial(t, path, mentor)
+
+	send(t, sc, `{"v":1,"type":"send","clientId":"r-1","body":"done with step 2"}`)
+	id := int64(next(t, sc, "ack")["id"].(float64))
+	send(t, mc, fmt.Sprintf(`{"v":1,"type":"react","clientId":"r-2","messageId":%d,"emoji":"👍"}`, id))
+	if f := next(t, sc, "reaction"); f["id"] != float64(id) || f["emoji"] != "👍" || f["count"] != float64(1) || f["added"] != true {
+		t.Fatalf("bad reaction event: %v", f)
+	}
+	msgPath := fmt.Sprintf("/chat/conversations/%d/messages/%d/reactions/", convID, id)
+	e.do(t, "PUT", msgPath+url.PathEscape("👍"), student.token, nil)
+	if f := next(t, mc, "reaction"); f["count"] != float64(2) || f["userId"] != float64(student.id) {
+		t.Fatalf("bad reaction event: %v", f)
+	}
+	if code := e.status(t, "PUT", msgPath+"abc", student.token, nil); code != 400 {
+		t.Fatalf("non-emoji reaction accepted: %d", code)
+	}
+
+	m := e.do(t, "GET", fmt.Sprintf("/chat/conversations/%d/messages", convID), mentor.token, nil)["items"].([]any)[0].(map[string]any)
+	rs, _ := m["reactions"].([]any)
+	if len(rs) != 1 || rs[0].(map[string]any)["count"] != float64(2) || rs[0].(map[string]any)["me"] != true {
+		t.Fatalf("bad reactions in history: %v", m["reactions"])
+	}
+	e.do(t, "DELETE", msgPath+url.PathEscape("👍"), mentor.token, nil)
+	if f := next(t, sc, "reaction"); f["count"] != float64(1) || f["added"] != false {
+		t.Fatalf("bad removal event: %v", f)
+	}
+}
+
+func TestGlobalThreads(t *testing.T) {
+	e := newEnv(t)
+	a, b := e.register(t, "student"), e.register(t, "mentor")
+	ac, bc := e.dial(t, "/ws/chat/global", a), e.dial(t, "/ws/chat/global", b)
+
+	root := e.do(t, "POST", "/chat/global/messages", a.token, map[string]any{"body": "anyone tried the new linter?"})
+	rootID := int64(root["id"].(float64))
+	send(t, bc, fmt.Sprintf(`{"v":1,"type":"subscribe","clientId":"t-1","messageId":%d}`, rootID))
+	if ack := next(t, bc, "ack"); ack["id"] != float64(rootID) {
+		t.Fatalf("bad subscribe ack: %v", ack)
+	}
+
+	send(t, ac, fmt.Sprintf(`{"v":1,"type":"send","clientId":"t-2-%d","body":"yes, it is great","parentId":%d}`, rootID, rootID))
+	replyID := next(t, ac, "ack")["id"]
+	if f := next(t, bc, "message"); f["id"] != replyID || f["parentId"] != float64(rootID) {
+		t.Fatalf("reply not delivered to thread subscriber: %v", f)
+	}
+	if f := next(t, ac, "thread"); f["id"] != float64(rootID) || f["replyCount"] != float64(1) {
+		t.Fatalf("bad thread summary: %v", f)
+	}
+	// Replying to a reply stays in the same thread.
+	out := e.do(t, "POST", "/chat/global/messages", b.token, map[string]any{"body": "agreed", "parentId": replyID})
+	if out["parentId"] != float64(rootID) {
+		t.Fatalf("nested reply not flattened: %v", out)
+	}
+
+	for _, it := range e.do(t, "GET", "/chat/global/messages?limit=20", a.token, nil)["items"].([]any) {
+		m := it.(map[string]any)
+		if m["parentId"] != nil {
+			t.Fatalf("reply in the global timeline: %v", m)
+		}
+		if m["id"] == float64(rootID) && (m["replyCount"] != float64(2) || m["lastReplyAt"] == nil) {
+			t.Fatalf("bad thread summary in history: %v", m)
+		}
+	}
+	th := e.do(t, "GET", fmt.Sprintf("/chat/global/messages/%v/thread", replyID), a.token, nil)
+	if th["parent"].(map[string]any)["id"] != float64(rootID) || len(th["items"].([]any)) != 2 {
+		t.Fatalf("bad thread: %v", th)
+	}
+}
+
+func TestSystemMessages(t *testing.T) {
+	e := newEnv(t)
+	student, mentor, convID := e.pair(t)
+	var mentorshipID int64
+	if err := e.pool.QueryRow(context.Background(), `
+		SELECT id FROM mentorships WHERE student_id=$1 AND mentor_id=$2 AND status='active'
+	`, student.id, mentor.id).Scan(&mentorshipID); err != nil {
+		t.Fatalf("load mentorship: %v", err)
+	}
+	mc := e.dial(t, fmt.Sprintf("/ws/chat?conversationId=%d", convID), mentor)
+
+	e.do(t, "POST", fmt.Sprintf("/mentorships/%d/pause", mentorshipID), student.token, map[string]any{"reason": "exams"})
+	f := next(t, mc, "message")
+	meta, _ := f["metadata"].(map[string]any)
+	if f["authorType"] != "system" || f["eventType"] != "mentorship.paused" || meta["mentorshipId"] != float64(mentorshipID) || meta["reason"] != "exams" {
+		t.Fatalf("bad system frame: %v", f)
+	}
+	if code := e.status(t, "POST", fmt.Sprintf("/mentorships/%d/pause", mentorshipID), mentor.token, nil); code != 409 {
+		t.Fatalf("pausing a paused mentorship: %d", code)
+	}
+
+	items := e.do(t, "GET", fmt.Sprintf("/chat/conversations/%d/messages", convID), student.token, nil)["items"].([]any)
+	var events []any
+	for _, it := range items {
+		events = append(events, it.(map[string]any)["eventType"])
+	}
+	if fmt.Sprint(events) != "[mentorship.approved mentorship.paused]" {
+		t.Fatalf("bad timeline: %v", events)
+	}
+	id := int64(f["id"].(float64))
+	path := fmt.Sprintf("/chat/conversations/%d/messages/%d", convID, id)
+	if code := e.status(t, "PATCH", path, student.token, map[string]any{"body": "edited"}); code != 403 {
+		t.Fatalf("edit of a system message: %d", code)
+	}
+	if code := e.status(t, "DELETE", path, student.token, nil); code != 403 {
+		t.Fatalf("delete of a system message: %d", code)
+	}
+}
+
+func TestMultiplexedSocket(t *testing.T) {
+	e := newEnv(t)
+	student, mentor, convID := e.pair(t)
+	conv := fmt.Sprintf("conversation:%d", convID)
+	sock := e.dial(t, "/ws", student)
+	for _, topic := range []string{conv, "global", "inbox", "notifications"} {
+		send(t, sock, fmt.Sprintf(`{"v":1,"type":"subscribe","clientId":"s","topic":%q}`, topic))
+		if f := next(t, sock, "ack"); f["topic"] != topic {
+			t.Fatalf("subscribe %s: %v", topic, f)
+		}
+	}
+
+	stranger := e.dial(t, "/ws", e.register(t, "student"))
+	send(t, stranger, fmt.Sprintf(`{"v":1,"type":"subscribe","clientId":"s","topic":%q}`, conv))
+	if f := next(t, stranger, "error"); f["code"] != "forbidden" {
+		t.Fatalf("stranger subscribed: %v", f)
+	}
+	send(t, stranger, fmt.Sprintf(`{"v":1,"type":"send","clientId":"x","topic":%q,"body":"hi"}`, conv))
+	if f := next(t, stranger, "error"); f["code"] != "forbidden" {
+		t.Fatalf("send without subscription: %v", f)
+	}
+
+	mc := e.dial(t, fmt.Sprintf("/ws/chat?conversationId=%d", convID), mentor)
+	send(t, sock, fmt.Sprintf(`{"v":1,"type":"send","clientId":"m-1","topic":%q,"body":"over one socket"}`, conv))
+	if f := next(t, sock, "ack"); f["clientId"] != "m-1" {
+		t.Fatalf("bad ack: %v", f)
+	}
+	if f := next(t, mc, "message"); f["body"] != "over one socket" {
+		t.Fatalf("bad broadcast: %v", f)
+	}
+	send(t, mc, `{"v":1,"type":"send","clientId":"m-2","body":"reply"}`)
+	for {
+		f := next(t, sock, "message")
+		if f["body"] == "reply" {
+			break
+		}
+	}
+	if f := next(t, sock, "conversation"); f["conversation"].(map[string]any)["id"] != float64(convID) {
+		t.Fatalf("bad inbox update: %v", f)
+	}
+
+	// A socket following only a thread is not in the global room, but a
+	// ban must still end the subscription.
+	mod := e.moderator(t)
+	root := e.do(t, "POST", "/chat/global/messages", mod.token, map[string]any{"body": "thread starter"})["id"].(float64)
+	thread := fmt.Sprintf("thread:%d", int64(root))
+	ts := e.dial(t, "/ws", student)
+	send(t, ts, fmt.Sprintf(`{"v":1,"type":"subscribe","clientId":"s","topic":%q}`, thread))
+	if f := next(t, ts, "ack"); f["topic"] != thread {
+		t.Fatalf("subscribe %s: %v", thread, f)
+	}
+
+	e.do(t, "POST", "/chat/moderation/sanctions", mod.token, map[string]any{"userId": student.id, "kind": "ban"})
+	if f := next(t, ts, "unsubscribed"); f["topic"] != thread {
+		t.Fatalf("thread subscription survived the ban: %v", f)
+	}
+	seen := map[string]map[string]any{}
+	_ = sock.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for seen["unsubscribed"] == nil || seen["notification"] == nil {
+		var f map[string]any
+		if err := sock.ReadJSON(&f); err != nil {
+			t.Fatalf("waiting for ban frames: %v (seen %v)", err, seen)
+		}
+		seen[f["type"].(string)] = f
+	}
+	if seen["unsubscribed"]["topic"] != "global" {
+		t.Fatalf("bad unsubscribed frame: %v", seen["unsubscribed"])
+	}
+	if n := seen["notification"]["notification"].(map[string]any); n["kind"] != "chat.sanction" {
+		t.Fatalf("bad notification: %v", n)
+	}
+	send(t, sock, `{"v":1,"type":"send","clientId":"g-1","topic":"global","body":"still here?"}`)
+	if f := next(t, sock, "error"); f["code"] != "forbidden" {
+		t.Fatalf("banned user posted: %v", f)
+	}
+}
+
+func TestPlanTopicEndsWithMentorship(t *testing.T) {
+	e := newEnv(t)
+	student, mentor, _ := e.pair(t)
+	out := e.do(t, "POST", "/plans/generate", student.token, map[string]any{"topic": "Go", "startDate": "2024-01-01"})
+	planID := int64(out["planId"].(float64))
+	e.do(t, "PUT", fmt.Sprintf("/plans/%d/shares/%d", planID, mentor.id), student.token, map[string]any{"permission": "read"})
+	var mentorshipID int64
+	if err := e.pool.QueryRow(context.Background(), `
+		SELECT id FROM mentorships WHERE student_id=$1 AND mentor_id=$2 AND status='active'
+	`, student.id, mentor.id).Scan(&mentorshipID); err != nil {
+		t.Fatalf("load mentorship: %v", err)
+	}
+
+	topic := fmt.Sprintf("plan:%d", planID)
+	sock := e.dial(t, "/ws", mentor)
+	send(t, sock, fmt.Sprintf(`{"v":1,"type":"subscribe","clientId":"s","topic":%q}`, topic))
+	if f := next(t, sock, "ack"); f["topic"] != topic {
+		t.Fatalf("subscribe %s: %v", topic, f)
+	}
+	e.do(t, "POST", fmt.Sprintf("/mentorships/%d/end", mentorshipID), student.token, nil)
+	if f := next(t, sock, "unsubscribed"); f["topic"] != topic {
+		t.Fatalf("plan subscription survived the end of the mentorship: %v", f)
+	}
+}
+
+func TestTransferHandover(t *testing.T) {
+	e := newEnv(t)
+	student, mentor, _ := e.pair(t)
+	var mentorshipID int64
+	if err := e.pool.QueryRow(context.Background(), `
+		SELECT id FROM mentorships WHERE student_id=$1 AND mentor_id=$2 AND status='active'
+	`, student.id, mentor.id).Scan(&mentorshipID); err != nil {
+		t.Fatalf("load mentorship: %v", err)
+	}
+	notes := fmt.Sprintf("/mentor/mentees/%d/notes", student.id)
+	e.do(t, "POST", notes, mentor.token, map[string]any{"body": "Strong on Go, needs SQL practice", "tags": []string{"summary"}})
+	e.do(t, "POST", notes, mentor.token, map[string]any{"body": "private aside", "pinned": true})
+	target := e.register(t, "mentor")
+	out := e.do(t, "POST", fmt.Sprintf("/mentorships/%d/transfer", mentorshipID), mentor.token, map[string]any{"toMentorId": target.id})
+	ns := e.do(t, "GET", "/notifications", student.token, nil)["items"].([]any)
+	if len(ns) == 0 || ns[0].(map[string]any)["kind"] != "mentorship.transfer.requested" {
+		t.Fatalf("student not notified of the transfer: %v", ns)
+	}
+	handover := func(transferID float64, to user) (map[string]any, string) {
+		t.Helper()
+		out := e.do(t, "POST", fmt.Sprintf("/mentor/transfers/%d/accept", int64(transferID)), to.token, nil)
+		items := e.do(t, "GET", fmt.Sprintf("/chat/conversations/%d/messages", int64(out["conversationId"].(float64))), student.token, nil)["items"].([]any)
+		last := items[len(items)-1].(map[string]any)
+		return last, last["body"].(string)
+	}
+	last, body := handover(out["transferId"].(float64), target)
+	meta, _ := last["metadata"].(map[string]any)
+	if last["authorType"] != "system" || last["eventType"] != "mentorship.transferred" || meta["fromMentorId"] != float64(mentor.id) {
+		t.Fatalf("bad handover message: %v", last)
+	}
+	if !strings.Contains(body, "needs SQL practice") || strings.Contains(body, "private aside") {
+		t.Fatalf("handover without the summary note: %q", body)
+	}
+
+	// A summary given with the request wins over the tagged note.
+	if err := e.pool.QueryRow(context.Background(), `
+		SELECT id FROM mentorships WHERE student_id=$1 AND mentor_id=$2 AND status='active'
+	`, student.id, target.id).Scan(&mentorshipID); err != nil {
+		t.Fatalf("load mentorship: %v", err)
+	}
+	e.do(t, "POST", notes, target.token, map[string]any{"body": "tagged but superseded", "tags": []string{"summary"}})
+	third := e.register(t, "mentor")
+	out = e.do(t, "POST", fmt.Sprintf("/mentorships/%d/transfer", mentorshipID), target.token,
+		map[string]any{"toMentorId": third.id, "summary": "Ready for the concurrency track"})
+	if _, body = handover(out["transferId"].(float64), third); !strings.Contains(body, "Ready for the concurrency track") || strings.Contains(body, "superseded") {
+		t.Fatalf("handover ignored the explicit summary: %q", body)
+	}
+}
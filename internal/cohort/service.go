@@ -0,0 +1,784 @@
+package cohort
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"upskill/internal/auth"
+	"upskill/internal/chat"
+	"upskill/internal/planner"
+	"upskill/internal/web"
+)
+
+// Cohorts group several students under one lead mentor and optional
+// co-mentors. Every enrolled student gets a private copy of the cohort plan;
+// the shared chat room lives in the chat package.
+
+const (
+	RoleLead   = "lead"
+	RoleCo     = "co"
+	RoleMember = "member"
+)
+
+type Service struct {
+	db   *pgxpool.Pool
+	chat *chat.Service
+}
+
+func NewService(db *pgxpool.Pool, chatSvc *chat.Service) *Service {
+	return &Service{db: db, chat: chatSvc}
+}
+
+// revoke ends uid's realtime access to the cohort room.
+func (s *Service) revoke(cohortID, uid int64) {
+	if s.chat != nil {
+		s.chat.Revoke("cohort", cohortID, uid)
+	}
+}
+
+type querier interface {
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+// Role returns uid's role in a cohort: lead, co, member or "".
+func Role(ctx context.Context, q querier, cohortID, uid int64) (string, error) {
+	var role string
+	err := q.QueryRow(ctx, `
+		SELECT role FROM cohort_mentors WHERE cohort_id=$1 AND mentor_id=$2
+		UNION ALL
+		SELECT 'member' FROM cohort_members WHERE cohort_id=$1 AND student_id=$2 AND status='enrolled'
+		LIMIT 1
+	`, cohortID, uid).Scan(&role)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", nil
+	}
+	return role, err
+}
+
+func isMentorRole(role string) bool { return role == RoleLead || role == RoleCo }
+
+// cohortRole reads {id} and the caller's role, writing the error response
+// itself when it returns false.
+func (s *Service) cohortRole(w http.ResponseWriter, r *http.Request) (int64, string, bool) {
+	id, err := web.ParamInt64(r, "id")
+	if err != nil {
+		http.Error(w, "bad id", 400)
+		return 0, "", false
+	}
+	var exists bool
+	if err := s.db.QueryRow(r.Context(), `SELECT EXISTS(SELECT 1 FROM cohorts WHERE id=$1)`, id).Scan(&exists); err != nil {
+		http.Error(w, err.Error(), 500)
+		return 0, "", false
+	}
+	if !exists {
+		http.Error(w, "not found", 404)
+		return 0, "", false
+	}
+	role, err := Role(r.Context(), s.db, id, auth.UserID(r))
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return 0, "", false
+	}
+	return id, role, true
+}
+
+type cohortIn struct {
+	Title          *string `json:"title"`
+	Description    *string `json:"description"`
+	Capacity       *int    `json:"capacity"`
+	EnrollOpensAt  *string `json:"enrollOpensAt"`
+	EnrollClosesAt *string `json:"enrollClosesAt"`
+}
+
+func parseTime(v *string) (*time.Time, error) {
+	if v == nil || *v == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339, *v)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+func (s *Service) Create(w http.ResponseWriter, r *http.Request) {
+	uid := auth.UserID(r)
+	var isMentor bool
+	if err := s.db.QueryRow(r.Context(), `SELECT EXISTS(SELECT 1 FROM user_roles WHERE user_id=$1 AND role='mentor')`, uid).Scan(&isMentor); err != nil || !isMentor {
+		http.Error(w, "forbidden", 403)
+		return
+	}
+	var in struct {
+		cohortIn
+		CoMentorIDs []int64 `json:"coMentorIds"`
+	}
+	if err := web.DecodeJSON(r, &in); err != nil || in.Title == nil || strings.TrimSpace(*in.Title) == "" {
+		http.Error(w, "bad input", 400)
+		return
+	}
+	capacity := 15
+	if in.Capacity != nil {
+		capacity = *in.Capacity
+	}
+	if capacity < 1 || capacity > 100 {
+		http.Error(w, "capacity must be between 1 and 100", 400)
+		return
+	}
+	opens, err1 := parseTime(in.EnrollOpensAt)
+	closes, err2 := parseTime(in.EnrollClosesAt)
+	if err1 != nil || err2 != nil || (opens != nil && closes != nil && !closes.After(*opens)) {
+		http.Error(w, "bad enrollment window", 400)
+		return
+	}
+	desc := ""
+	if in.Description != nil {
+		desc = *in.Description
+	}
+
+	tx, err := s.db.Begin(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	defer tx.Rollback(r.Context())
+
+	var id int64
+	if err := tx.QueryRow(r.Context(), `
+		INSERT INTO cohorts(title, description, capacity, enroll_opens_at, enroll_closes_at, created_by)
+		VALUES($1,$2,$3,$4,$5,$6) RETURNING id
+	`, strings.TrimSpace(*in.Title), desc, capacity, opens, closes, uid).Scan(&id); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if _, err := tx.Exec(r.Context(), `INSERT INTO cohort_mentors(cohort_id, mentor_id, role) VALUES($1,$2,'lead')`, id, uid); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	for _, mid := range in.CoMentorIDs {
+		if mid == uid {
+			continue
+		}
+		if code, err := addCoMentor(r.Context(), tx, id, mid); err != nil {
+			http.Error(w, err.Error(), code)
+			return
+		}
+	}
+	if err := tx.Commit(r.Context()); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	web.JSON(w, 201, map[string]any{"cohortId": id})
+}
+
+func addCoMentor(ctx context.Context, tx pgx.Tx, cohortID, mentorID int64) (int, error) {
+	res, err := tx.Exec(ctx, `
+		INSERT INTO cohort_mentors(cohort_id, mentor_id, role)
+		SELECT $1, user_id, 'co' FROM user_roles WHERE user_id=$2 AND role='mentor'
+		ON CONFLICT (cohort_id, mentor_id) DO NOTHING
+	`, cohortID, mentorID)
+	if err != nil {
+		return 500, err
+	}
+	if res.RowsAffected() == 0 {
+		var exists bool
+		_ = tx.QueryRow(ctx, `SELECT EXISTS(SELECT 1 FROM cohort_mentors WHERE cohort_id=$1 AND mentor_id=$2)`, cohortID, mentorID).Scan(&exists)
+		if !exists {
+			return 400, errors.New("co-mentor must have the mentor role")
+		}
+	}
+	return 0, nil
+}
+
+var cohortsSpec = web.ListSpec{
+	IDColumn: "c.id",
+	Sorts: map[string]web.SortKey{
+		"createdAt": {Column: "c.created_at", Type: web.SortTime},
+		"title":     {Column: "c.title", Type: web.SortText},
+	},
+	DefaultSort:  "-createdAt",
+	DateColumn:   "c.created_at",
+	SearchColumn: "c.title",
+	DefaultLimit: 50,
+	MaxLimit:     200,
+}
+
+// List returns cohorts the caller mentors or belongs to, plus cohorts whose
+// enrollment is currently open.
+func (s *Service) List(w http.ResponseWriter, r *http.Request) {
+	uid := auth.UserID(r)
+	lq, err := web.ParseList(r, cohortsSpec)
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	args := web.Args{uid}
+	rows, err := s.db.Query(r.Context(), `
+		SELECT c.id, c.title, COALESCE(c.description,''), c.capacity, c.enroll_opens_at, c.enroll_closes_at,
+		       (SELECT COUNT(*) FROM cohort_members cm WHERE cm.cohort_id=c.id AND cm.status='enrolled'),
+		       COALESCE((SELECT role FROM cohort_mentors x WHERE x.cohort_id=c.id AND x.mentor_id=$1),
+		                (SELECT 'member' FROM cohort_members x WHERE x.cohort_id=c.id AND x.student_id=$1 AND x.status='enrolled'),
+		                ''),
+		       c.created_at
+		FROM cohorts c
+		WHERE (EXISTS(SELECT 1 FROM cohort_mentors x WHERE x.cohort_id=c.id AND x.mentor_id=$1)
+		   OR EXISTS(SELECT 1 FROM cohort_members x WHERE x.cohort_id=c.id AND x.student_id=$1 AND x.status='enrolled')
+		   OR ((c.enroll_opens_at IS NULL OR c.enroll_opens_at <= now())
+		       AND (c.enroll_closes_at IS NULL OR c.enroll_closes_at > now())))`+lq.Where(&args)+lq.OrderLimit(&args), args...)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	defer rows.Close()
+	type Item struct {
+		ID             int64      `json:"id"`
+		Title          string     `json:"title"`
+		Description    string     `json:"description"`
+		Capacity       int        `json:"capacity"`
+		EnrollOpensAt  *time.Time `json:"enrollOpensAt,omitempty"`
+		EnrollClosesAt *time.Time `json:"enrollClosesAt,omitempty"`
+		Enrolled       int        `json:"enrolled"`
+		Role           string     `json:"role,omitempty"`
+		CreatedAt      time.Time  `json:"createdAt"`
+	}
+	items := []Item{}
+	for rows.Next() {
+		var it Item
+		if err := rows.Scan(&it.ID, &it.Title, &it.Description, &it.Capacity, &it.EnrollOpensAt, &it.EnrollClosesAt, &it.Enrolled, &it.Role, &it.CreatedAt); err == nil {
+			items = append(items, it)
+		}
+	}
+	items, next := web.Page(w, r, lq, items, func(it Item, sort string) (any, int64) {
+		if sort == "title" {
+			return it.Title, it.ID
+		}
+		return it.CreatedAt, it.ID
+	})
+	web.JSON(w, 200, map[string]any{"items": items, "nextCursor": next})
+}
+
+func (s *Service) Get(w http.ResponseWriter, r *http.Request) {
+	id, role, ok := s.cohortRole(w, r)
+	if !ok {
+		return
+	}
+	var title, desc string
+	var capacity int
+	var opens, closes *time.Time
+	var templateID *int64
+	if err := s.db.QueryRow(r.Context(), `
+		SELECT title, COALESCE(description,''), capacity, enroll_opens_at, enroll_closes_at, template_plan_id
+		FROM cohorts WHERE id=$1
+	`, id).Scan(&title, &desc, &capacity, &opens, &closes, &templateID); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	type Person struct {
+		ID     int64  `json:"id"`
+		Name   string `json:"name"`
+		Role   string `json:"role,omitempty"`
+		PlanID *int64 `json:"planId,omitempty"`
+	}
+	mentors := []Person{}
+	rows, err := s.db.Query(r.Context(), `
+		SELECT cm.mentor_id, COALESCE(u.first_name,'')||' '||COALESCE(u.last_name,''), cm.role
+		FROM cohort_mentors cm LEFT JOIN users u ON u.id = cm.mentor_id
+		WHERE cm.cohort_id=$1
+		ORDER BY cm.role DESC, cm.added_at
+	`, id)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	for rows.Next() {
+		var p Person
+		if err := rows.Scan(&p.ID, &p.Name, &p.Role); err == nil {
+			mentors = append(mentors, p)
+		}
+	}
+	rows.Close()
+
+	out := map[string]any{
+		"id": id, "title": title, "description": desc, "capacity": capacity,
+		"enrollOpensAt": opens, "enrollClosesAt": closes, "templatePlanId": templateID,
+		"mentors": mentors, "role": role,
+	}
+	// Member lists are only visible inside the cohort.
+	if role != "" {
+		members := []Person{}
+		rows, err := s.db.Query(r.Context(), `
+			SELECT cm.student_id, COALESCE(u.first_name,'')||' '||COALESCE(u.last_name,''), cm.plan_id
+			FROM cohort_members cm LEFT JOIN users u ON u.id = cm.student_id
+			WHERE cm.cohort_id=$1 AND cm.status='enrolled'
+			ORDER BY cm.enrolled_at
+		`, id)
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		uid := auth.UserID(r)
+		for rows.Next() {
+			var p Person
+			if err := rows.Scan(&p.ID, &p.Name, &p.PlanID); err == nil {
+				if !isMentorRole(role) && p.ID != uid {
+					p.PlanID = nil
+				}
+				members = append(members, p)
+			}
+		}
+		rows.Close()
+		out["members"] = members
+	}
+	web.JSON(w, 200, out)
+}
+
+func (s *Service) Update(w http.ResponseWriter, r *http.Request) {
+	id, role, ok := s.cohortRole(w, r)
+	if !ok {
+		return
+	}
+	if role != RoleLead {
+		http.Error(w, "forbidden", 403)
+		return
+	}
+	var in cohortIn
+	if err := web.DecodeJSON(r, &in); err != nil || (in.Title != nil && strings.TrimSpace(*in.Title) == "") {
+		http.Error(w, "bad input", 400)
+		return
+	}
+	if in.Capacity != nil && (*in.Capacity < 1 || *in.Capacity > 100) {
+		http.Error(w, "capacity must be between 1 and 100", 400)
+		return
+	}
+	opens, err1 := parseTime(in.EnrollOpensAt)
+	closes, err2 := parseTime(in.EnrollClosesAt)
+	if err1 != nil || err2 != nil {
+		http.Error(w, "bad enrollment window", 400)
+		return
+	}
+
+	tx, err := s.db.Begin(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	defer tx.Rollback(r.Context())
+
+	// Locking the cohort row keeps Enroll from filling seats meanwhile.
+	var curOpens, curCloses *time.Time
+	var enrolled int
+	if err := tx.QueryRow(r.Context(), `
+		SELECT enroll_opens_at, enroll_closes_at,
+		       (SELECT COUNT(*) FROM cohort_members WHERE cohort_id=$1 AND status='enrolled')
+		FROM cohorts WHERE id=$1 FOR UPDATE
+	`, id).Scan(&curOpens, &curCloses, &enrolled); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if in.EnrollOpensAt == nil {
+		opens = curOpens
+	}
+	if in.EnrollClosesAt == nil {
+		closes = curCloses
+	}
+	if opens != nil && closes != nil && !closes.After(*opens) {
+		http.Error(w, "enrollment must close after it opens", 400)
+		return
+	}
+	if in.Capacity != nil && *in.Capacity < enrolled {
+		http.Error(w, "capacity is below the number of enrolled students", 409)
+		return
+	}
+	_, err = tx.Exec(r.Context(), `
+		UPDATE cohorts SET
+		  title = COALESCE($2, title),
+		  description = COALESCE($3, description),
+		  capacity = COALESCE($4, capacity),
+		  enroll_opens_at = CASE WHEN $5 THEN $6::timestamptz ELSE enroll_opens_at END,
+		  enroll_closes_at = CASE WHEN $7 THEN $8::timestamptz ELSE enroll_closes_at END
+		WHERE id=$1
+	`, id, trimmed(in.Title), in.Description, in.Capacity,
+		in.EnrollOpensAt != nil, opens, in.EnrollClosesAt != nil, closes)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if err := tx.Commit(r.Context()); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	web.JSON(w, 200, map[string]any{"ok": true})
+}
+
+func trimmed(v *string) *string {
+	if v == nil {
+		return nil
+	}
+	t := strings.TrimSpace(*v)
+	return &t
+}
+
+func (s *Service) AddMentor(w http.ResponseWriter, r *http.Request) {
+	id, role, ok := s.cohortRole(w, r)
+	if !ok {
+		return
+	}
+	if role != RoleLead {
+		http.Error(w, "forbidden", 403)
+		return
+	}
+	var in struct {
+		MentorID int64 `json:"mentorId"`
+	}
+	if err := web.DecodeJSON(r, &in); err != nil || in.MentorID <= 0 {
+		http.Error(w, "bad input", 400)
+		return
+	}
+	tx, err := s.db.Begin(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	defer tx.Rollback(r.Context())
+	if code, err := addCoMentor(r.Context(), tx, id, in.MentorID); err != nil {
+		http.Error(w, err.Error(), code)
+		return
+	}
+	if err := tx.Commit(r.Context()); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	web.JSON(w, 200, map[string]any{"ok": true})
+}
+
+func (s *Service) RemoveMentor(w http.ResponseWriter, r *http.Request) {
+	id, role, ok := s.cohortRole(w, r)
+	if !ok {
+		return
+	}
+	if role != RoleLead {
+		http.Error(w, "forbidden", 403)
+		return
+	}
+	mentorID, err := web.ParamInt64(r, "mentorId")
+	if err != nil {
+		http.Error(w, "bad mentorId", 400)
+		return
+	}
+	res, err := s.db.Exec(r.Context(), `DELETE FROM cohort_mentors WHERE cohort_id=$1 AND mentor_id=$2 AND role='co'`, id, mentorID)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if res.RowsAffected() == 0 {
+		http.Error(w, "not found", 404)
+		return
+	}
+	s.revoke(id, mentorID)
+	web.JSON(w, 200, map[string]any{"ok": true})
+}
+
+// Enroll adds the caller to the cohort while the enrollment window is open
+// and seats are left, and hands them a copy of the cohort plan.
+func (s *Service) Enroll(w http.ResponseWriter, r *http.Request) {
+	uid := auth.UserID(r)
+	id, role, ok := s.cohortRole(w, r)
+	if !ok {
+		return
+	}
+	if role != "" {
+		http.Error(w, "already in cohort", 409)
+		return
+	}
+
+	tx, err := s.db.Begin(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	defer tx.Rollback(r.Context())
+
+	var capacity int
+	var open bool
+	var templateID *int64
+	if err := tx.QueryRow(r.Context(), `
+		SELECT capacity,
+		       (enroll_opens_at IS NULL OR enroll_opens_at <= now()) AND (enroll_closes_at IS NULL OR enroll_closes_at > now()),
+		       template_plan_id
+		FROM cohorts WHERE id=$1 FOR UPDATE
+	`, id).Scan(&capacity, &open, &templateID); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if !open {
+		http.Error(w, "enrollment closed", 409)
+		return
+	}
+	var enrolled int
+	if err := tx.QueryRow(r.Context(), `SELECT COUNT(*) FROM cohort_members WHERE cohort_id=$1 AND status='enrolled'`, id).Scan(&enrolled); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if enrolled >= capacity {
+		http.Error(w, "cohort is full", 409)
+		return
+	}
+
+	// A returning student keeps the plan copied on their first enrollment.
+	var planID *int64
+	if err := tx.QueryRow(r.Context(), `SELECT plan_id FROM cohort_members WHERE cohort_id=$1 AND student_id=$2`, id, uid).Scan(&planID); err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if planID == nil && templateID != nil {
+		pid, err := planner.CopyPlan(r.Context(), tx, *templateID, uid)
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		planID = &pid
+	}
+	if _, err := tx.Exec(r.Context(), `
+		INSERT INTO cohort_members(cohort_id, student_id, status, plan_id)
+		VALUES($1,$2,'enrolled',$3)
+		ON CONFLICT (cohort_id, student_id) DO UPDATE
+		SET status='enrolled', enrolled_at=now(), left_at=NULL,
+		    plan_id=COALESCE(cohort_members.plan_id, EXCLUDED.plan_id)
+	`, id, uid, planID); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if _, err := tx.Exec(r.Context(), `INSERT INTO user_roles(user_id, role) VALUES($1,'student') ON CONFLICT DO NOTHING`, uid); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if err := tx.Commit(r.Context()); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	web.JSON(w, 200, map[string]any{"ok": true, "planId": planID})
+}
+
+func (s *Service) Leave(w http.ResponseWriter, r *http.Request) {
+	uid := auth.UserID(r)
+	id, err := web.ParamInt64(r, "id")
+	if err != nil {
+		http.Error(w, "bad id", 400)
+		return
+	}
+	s.setLeft(w, r, id, uid, "left")
+}
+
+// RemoveMember lets a cohort mentor take a student out of the cohort. The
+// student keeps their plan copy.
+func (s *Service) RemoveMember(w http.ResponseWriter, r *http.Request) {
+	id, role, ok := s.cohortRole(w, r)
+	if !ok {
+		return
+	}
+	if !isMentorRole(role) {
+		http.Error(w, "forbidden", 403)
+		return
+	}
+	sid, err := web.ParamInt64(r, "studentId")
+	if err != nil {
+		http.Error(w, "bad studentId", 400)
+		return
+	}
+	s.setLeft(w, r, id, sid, "removed")
+}
+
+func (s *Service) setLeft(w http.ResponseWriter, r *http.Request, cohortID, studentID int64, status string) {
+	res, err := s.db.Exec(r.Context(), `
+		UPDATE cohort_members SET status=$3, left_at=now()
+		WHERE cohort_id=$1 AND student_id=$2 AND status='enrolled'
+	`, cohortID, studentID, status)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if res.RowsAffected() == 0 {
+		http.Error(w, "not found", 404)
+		return
+	}
+	s.revoke(cohortID, studentID)
+	web.JSON(w, 200, map[string]any{"ok": true})
+}
+
+// SetPlan makes one of the caller's plans the cohort plan and copies it to
+// every enrolled member that does not have a copy yet.
+func (s *Service) SetPlan(w http.ResponseWriter, r *http.Request) {
+	uid := auth.UserID(r)
+	id, role, ok := s.cohortRole(w, r)
+	if !ok {
+		return
+	}
+	if !isMentorRole(role) {
+		http.Error(w, "forbidden", 403)
+		return
+	}
+	var in struct {
+		PlanID int64 `json:"planId"`
+	}
+	if err := web.DecodeJSON(r, &in); err != nil || in.PlanID <= 0 {
+		http.Error(w, "bad input", 400)
+		return
+	}
+	var owner int64
+	if err := s.db.QueryRow(r.Context(), `SELECT user_id FROM plans WHERE id=$1`, in.PlanID).Scan(&owner); err != nil {
+		http.Error(w, "plan not found", 404)
+		return
+	}
+	if owner != uid {
+		http.Error(w, "forbidden", 403)
+		return
+	}
+
+	tx, err := s.db.Begin(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	defer tx.Rollback(r.Context())
+
+	// Progress lines member tasks up with the template by order, so the
+	// template is fixed once members hold copies of it.
+	var switching bool
+	if err := tx.QueryRow(r.Context(), `
+		SELECT template_plan_id IS NOT NULL AND template_plan_id <> $2
+		       AND EXISTS(SELECT 1 FROM cohort_members WHERE cohort_id=$1 AND plan_id IS NOT NULL)
+		FROM cohorts WHERE id=$1 FOR UPDATE
+	`, id, in.PlanID).Scan(&switching); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if switching {
+		http.Error(w, "members already have copies of the current plan", 409)
+		return
+	}
+	if _, err := tx.Exec(r.Context(), `UPDATE cohorts SET template_plan_id=$2 WHERE id=$1`, id, in.PlanID); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	rows, err := tx.Query(r.Context(), `
+		SELECT student_id FROM cohort_members
+		WHERE cohort_id=$1 AND status='enrolled' AND plan_id IS NULL
+		FOR UPDATE
+	`, id)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	var students []int64
+	for rows.Next() {
+		var sid int64
+		if err := rows.Scan(&sid); err == nil {
+			students = append(students, sid)
+		}
+	}
+	rows.Close()
+	for _, sid := range students {
+		pid, err := planner.CopyPlan(r.Context(), tx, in.PlanID, sid)
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		if _, err := tx.Exec(r.Context(), `UPDATE cohort_members SET plan_id=$3 WHERE cohort_id=$1 AND student_id=$2`, id, sid, pid); err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+	}
+	if err := tx.Commit(r.Context()); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	web.JSON(w, 200, map[string]any{"ok": true, "copied": len(students)})
+}
+
+// Progress returns a members x tasks grid for cohort mentors. Columns are the
+// cohort plan tasks; member copies are matched by task order.
+func (s *Service) Progress(w http.ResponseWriter, r *http.Request) {
+	id, role, ok := s.cohortRole(w, r)
+	if !ok {
+		return
+	}
+	if !isMentorRole(role) {
+		http.Error(w, "forbidden", 403)
+		return
+	}
+	var templateID *int64
+	if err := s.db.QueryRow(r.Context(), `SELECT template_plan_id FROM cohorts WHERE id=$1`, id).Scan(&templateID); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	type Column struct {
+		Order int    `json:"order"`
+		Title string `json:"title"`
+	}
+	type Row struct {
+		StudentID int64             `json:"studentId"`
+		Name      string            `json:"name"`
+		PlanID    *int64            `json:"planId,omitempty"`
+		Completed int               `json:"completed"`
+		Cells     map[string]string `json:"cells"`
+	}
+	columns := []Column{}
+	if templateID != nil {
+		rows, err := s.db.Query(r.Context(), `SELECT order_no, title FROM plan_tasks WHERE plan_id=$1 ORDER BY order_no, id`, *templateID)
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		for rows.Next() {
+			var c Column
+			if err := rows.Scan(&c.Order, &c.Title); err == nil {
+				columns = append(columns, c)
+			}
+		}
+		rows.Close()
+	}
+
+	rows, err := s.db.Query(r.Context(), `
+		SELECT cm.student_id, COALESCE(u.first_name,'')||' '||COALESCE(u.last_name,''), cm.plan_id,
+		       t.order_no, t.status
+		FROM cohort_members cm
+		LEFT JOIN users u ON u.id = cm.student_id
+		LEFT JOIN plan_tasks t ON t.plan_id = cm.plan_id
+		WHERE cm.cohort_id=$1 AND cm.status='enrolled'
+		ORDER BY cm.enrolled_at, cm.student_id, t.order_no
+	`, id)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	defer rows.Close()
+	grid := []Row{}
+	for rows.Next() {
+		var sid int64
+		var name string
+		var planID *int64
+		var order *int
+		var status *string
+		if err := rows.Scan(&sid, &name, &planID, &order, &status); err != nil {
+			continue
+		}
+		if n := len(grid); n == 0 || grid[n-1].StudentID != sid {
+			grid = append(grid, Row{StudentID: sid, Name: name, PlanID: planID, Cells: map[string]string{}})
+		}
+		if order != nil && status != nil {
+			row := &grid[len(grid)-1]
+			row.Cells[strconv.Itoa(*order)] = *status
+			if *status == "completed" {
+				row.Completed++
+			}
+		}
+	}
+	web.JSON(w, 200, map[string]any{"columns": columns, "rows": grid})
+}
@@ -18,21 +18,21 @@ func RunDevSeed(pool *pgxpool.Pool) error {
 	}
 	defer tx.Rollback(ctx)
 
-	type U struct{ Email, First, Last, Pass string }
+	type U struct{ Email, First, Last, Pass, Handle string }
 	users := []U{
-		{"student@example.com", "Student", "Demo", "password"},
-		{"mentor@example.com", "Mentor", "Demo", "password"},
+		{"student@example.com", "Student", "Demo", "password", "student"},
+		{"mentor@example.com", "Mentor", "Demo", "password", "mentor"},
 	}
 	ids := make(map[string]int64)
 	for _, u := range users {
 		var id int64
 		hash, _ := bcrypt.GenerateFromPassword([]byte(u.Pass), bcrypt.DefaultCost)
 		err := tx.QueryRow(ctx, `
-			INSERT INTO users(email, password_hash, first_name, last_name)
-			VALUES($1,$2,$3,$4)
-			ON CONFLICT (email) DO UPDATE SET first_name=EXCLUDED.first_name
+			INSERT INTO users(email, password_hash, first_name, last_name, handle)
+			VALUES($1,$2,$3,$4,$5)
+			ON CONFLICT (email) DO UPDATE SET first_name=EXCLUDED.first_name, handle=COALESCE(users.handle, EXCLUDED.handle)
 			RETURNING id
-		`, strings.ToLower(u.Email), string(hash), u.First, u.Last).Scan(&id)
+		`, strings.ToLower(u.Email), string(hash), u.First, u.Last, u.Handle).Scan(&id)
 		if err != nil {
 			log.Printf("seed user %s: %v", u.Email, err)
 			continue
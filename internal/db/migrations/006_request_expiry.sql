@@ -0,0 +1,25 @@
+ALTER TABLE mentorship_requests DROP CONSTRAINT IF EXISTS mentorship_requests_status_check;
+ALTER TABLE mentorship_requests ADD CONSTRAINT mentorship_requests_status_check
+  CHECK (status IN ('pending','approved','declined','cancelled','expired'));
+
+ALTER TABLE mentorship_requests
+  ADD COLUMN IF NOT EXISTS reminders_sent INT NOT NULL DEFAULT 0,
+  ADD COLUMN IF NOT EXISTS last_reminded_at TIMESTAMPTZ;
+
+CREATE INDEX IF NOT EXISTS idx_mentorship_requests_pending
+  ON mentorship_requests(created_at)
+  WHERE status = 'pending';
+
+CREATE INDEX IF NOT EXISTS idx_mentorship_requests_mentor
+  ON mentorship_requests(mentor_id, created_at);
+
+CREATE TABLE IF NOT EXISTS notifications (
+  id BIGSERIAL PRIMARY KEY,
+  user_id BIGINT NOT NULL,
+  kind TEXT NOT NULL,
+  payload JSONB NOT NULL DEFAULT '{}'::jsonb,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  read_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS idx_notifications_user ON notifications(user_id, id DESC);
@@ -0,0 +1,38 @@
+CREATE TABLE IF NOT EXISTS mentorship_goals (
+  id BIGSERIAL PRIMARY KEY,
+  mentorship_id BIGINT NOT NULL REFERENCES mentorships(id) ON DELETE CASCADE,
+  title TEXT NOT NULL,
+  description TEXT,
+  target_date DATE,
+  status TEXT NOT NULL DEFAULT 'proposed' CHECK (status IN ('proposed','accepted','completed','dropped')),
+  proposed_by BIGINT NOT NULL,
+  accepted_at TIMESTAMPTZ,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idx_mentorship_goals_mentorship ON mentorship_goals(mentorship_id);
+
+CREATE TABLE IF NOT EXISTS goal_milestones (
+  id BIGSERIAL PRIMARY KEY,
+  goal_id BIGINT NOT NULL REFERENCES mentorship_goals(id) ON DELETE CASCADE,
+  title TEXT NOT NULL,
+  target_date DATE,
+  status TEXT NOT NULL DEFAULT 'proposed' CHECK (status IN ('proposed','accepted','completed')),
+  progress INT NOT NULL DEFAULT 0 CHECK (progress BETWEEN 0 AND 100),
+  proposed_by BIGINT NOT NULL,
+  accepted_at TIMESTAMPTZ,
+  completed_at TIMESTAMPTZ,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idx_goal_milestones_goal ON goal_milestones(goal_id);
+
+CREATE TABLE IF NOT EXISTS milestone_tasks (
+  milestone_id BIGINT NOT NULL REFERENCES goal_milestones(id) ON DELETE CASCADE,
+  task_id BIGINT NOT NULL REFERENCES plan_tasks(id) ON DELETE CASCADE,
+  PRIMARY KEY (milestone_id, task_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_milestone_tasks_task ON milestone_tasks(task_id);
@@ -0,0 +1,60 @@
+CREATE EXTENSION IF NOT EXISTS btree_gist;
+
+CREATE TABLE IF NOT EXISTS mentor_schedules (
+  mentor_id BIGINT PRIMARY KEY,
+  timezone TEXT NOT NULL DEFAULT 'UTC',
+  slot_minutes INT NOT NULL DEFAULT 60 CHECK (slot_minutes BETWEEN 15 AND 240),
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+-- weekday follows Go/Postgres DOW: 0 = Sunday; times are in the mentor's timezone
+CREATE TABLE IF NOT EXISTS mentor_availability (
+  id BIGSERIAL PRIMARY KEY,
+  mentor_id BIGINT NOT NULL,
+  weekday SMALLINT NOT NULL CHECK (weekday BETWEEN 0 AND 6),
+  start_time TIME NOT NULL,
+  end_time TIME NOT NULL,
+  CHECK (end_time > start_time)
+);
+
+CREATE INDEX IF NOT EXISTS idx_mentor_availability_mentor ON mentor_availability(mentor_id, weekday);
+
+-- one-off changes for a date: 'unavailable' without times blocks the whole day
+CREATE TABLE IF NOT EXISTS availability_exceptions (
+  id BIGSERIAL PRIMARY KEY,
+  mentor_id BIGINT NOT NULL,
+  date DATE NOT NULL,
+  kind TEXT NOT NULL CHECK (kind IN ('unavailable','available')),
+  start_time TIME,
+  end_time TIME,
+  reason TEXT,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  CHECK (
+    (start_time IS NULL AND end_time IS NULL AND kind = 'unavailable')
+    OR (start_time IS NOT NULL AND end_time IS NOT NULL AND end_time > start_time)
+  )
+);
+
+CREATE INDEX IF NOT EXISTS idx_availability_exceptions_mentor ON availability_exceptions(mentor_id, date);
+
+CREATE TABLE IF NOT EXISTS mentor_sessions (
+  id BIGSERIAL PRIMARY KEY,
+  mentorship_id BIGINT NOT NULL REFERENCES mentorships(id) ON DELETE CASCADE,
+  mentor_id BIGINT NOT NULL,
+  student_id BIGINT NOT NULL,
+  starts_at TIMESTAMPTZ NOT NULL,
+  ends_at TIMESTAMPTZ NOT NULL,
+  topic TEXT,
+  status TEXT NOT NULL DEFAULT 'booked' CHECK (status IN ('booked','cancelled','rescheduled')),
+  rescheduled_from BIGINT REFERENCES mentor_sessions(id),
+  cancelled_by BIGINT,
+  cancelled_at TIMESTAMPTZ,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  CHECK (ends_at > starts_at),
+  CONSTRAINT mentor_sessions_mentor_no_overlap
+    EXCLUDE USING gist (mentor_id WITH =, tstzrange(starts_at, ends_at) WITH &&) WHERE (status = 'booked'),
+  CONSTRAINT mentor_sessions_student_no_overlap
+    EXCLUDE USING gist (student_id WITH =, tstzrange(starts_at, ends_at) WITH &&) WHERE (status = 'booked')
+);
+
+CREATE INDEX IF NOT EXISTS idx_mentor_sessions_student ON mentor_sessions(student_id, starts_at);
@@ -0,0 +1,23 @@
+CREATE TABLE IF NOT EXISTS mentor_feedback (
+  id BIGSERIAL PRIMARY KEY,
+  mentor_id BIGINT NOT NULL,
+  student_id BIGINT NOT NULL,
+  mentorship_id BIGINT NOT NULL REFERENCES mentorships(id) ON DELETE CASCADE,
+  session_id BIGINT REFERENCES mentor_sessions(id) ON DELETE CASCADE,
+  -- first day of the month the review belongs to
+  period DATE NOT NULL,
+  rating SMALLINT NOT NULL CHECK (rating BETWEEN 1 AND 5),
+  tags TEXT[] NOT NULL DEFAULT '{}',
+  comment TEXT,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS uniq_feedback_session
+  ON mentor_feedback(session_id)
+  WHERE session_id IS NOT NULL;
+
+CREATE UNIQUE INDEX IF NOT EXISTS uniq_feedback_period
+  ON mentor_feedback(student_id, mentor_id, period)
+  WHERE session_id IS NULL;
+
+CREATE INDEX IF NOT EXISTS idx_mentor_feedback_mentor ON mentor_feedback(mentor_id, period);
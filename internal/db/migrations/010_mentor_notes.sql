@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS mentor_notes (
+  id BIGSERIAL PRIMARY KEY,
+  mentor_id BIGINT NOT NULL,
+  student_id BIGINT NOT NULL,
+  body TEXT NOT NULL,
+  tags TEXT[] NOT NULL DEFAULT '{}',
+  pinned BOOLEAN NOT NULL DEFAULT false,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idx_mentor_notes_pair ON mentor_notes(mentor_id, student_id, updated_at DESC);
@@ -0,0 +1,22 @@
+CREATE TABLE IF NOT EXISTS plan_shares (
+  plan_id BIGINT NOT NULL REFERENCES plans(id) ON DELETE CASCADE,
+  mentor_id BIGINT NOT NULL,
+  permission TEXT NOT NULL CHECK (permission IN ('read','comment')),
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  PRIMARY KEY (plan_id, mentor_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_plan_shares_mentor ON plan_shares(mentor_id);
+
+CREATE TABLE IF NOT EXISTS task_comments (
+  id BIGSERIAL PRIMARY KEY,
+  task_id BIGINT NOT NULL REFERENCES plan_tasks(id) ON DELETE CASCADE,
+  author_id BIGINT NOT NULL,
+  body TEXT NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idx_task_comments_task ON task_comments(task_id, created_at);
+
+-- NULL for generated tasks; set when a mentor adds a task to a shared plan
+ALTER TABLE plan_tasks ADD COLUMN IF NOT EXISTS created_by BIGINT;
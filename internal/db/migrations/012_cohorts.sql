@@ -0,0 +1,42 @@
+CREATE TABLE IF NOT EXISTS cohorts (
+  id BIGSERIAL PRIMARY KEY,
+  title TEXT NOT NULL,
+  description TEXT,
+  capacity INT NOT NULL DEFAULT 15 CHECK (capacity BETWEEN 1 AND 100),
+  enroll_opens_at TIMESTAMPTZ,
+  enroll_closes_at TIMESTAMPTZ,
+  template_plan_id BIGINT REFERENCES plans(id) ON DELETE SET NULL,
+  created_by BIGINT NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  CHECK (enroll_closes_at IS NULL OR enroll_opens_at IS NULL OR enroll_closes_at > enroll_opens_at)
+);
+
+CREATE TABLE IF NOT EXISTS cohort_mentors (
+  cohort_id BIGINT NOT NULL REFERENCES cohorts(id) ON DELETE CASCADE,
+  mentor_id BIGINT NOT NULL,
+  role TEXT NOT NULL CHECK (role IN ('lead','co')),
+  added_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  PRIMARY KEY (cohort_id, mentor_id)
+);
+
+CREATE TABLE IF NOT EXISTS cohort_members (
+  cohort_id BIGINT NOT NULL REFERENCES cohorts(id) ON DELETE CASCADE,
+  student_id BIGINT NOT NULL,
+  status TEXT NOT NULL DEFAULT 'enrolled' CHECK (status IN ('enrolled','left','removed')),
+  plan_id BIGINT REFERENCES plans(id) ON DELETE SET NULL,
+  enrolled_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  left_at TIMESTAMPTZ,
+  PRIMARY KEY (cohort_id, student_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_cohort_members_student ON cohort_members(student_id);
+
+CREATE TABLE IF NOT EXISTS cohort_messages (
+  id BIGSERIAL PRIMARY KEY,
+  cohort_id BIGINT NOT NULL REFERENCES cohorts(id) ON DELETE CASCADE,
+  author_id BIGINT NOT NULL,
+  body TEXT NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idx_cohort_messages_cohort ON cohort_messages(cohort_id, id);
@@ -0,0 +1,28 @@
+CREATE TABLE IF NOT EXISTS mentorship_invites (
+  id BIGSERIAL PRIMARY KEY,
+  mentor_id BIGINT NOT NULL,
+  kind TEXT NOT NULL CHECK (kind IN ('user','email','code')),
+  invitee_id BIGINT,
+  invitee_email TEXT,
+  code TEXT UNIQUE NOT NULL,
+  message TEXT,
+  max_uses INT NOT NULL DEFAULT 1 CHECK (max_uses >= 1),
+  uses INT NOT NULL DEFAULT 0,
+  expires_at TIMESTAMPTZ,
+  status TEXT NOT NULL DEFAULT 'active' CHECK (status IN ('active','revoked','declined','used')),
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  CHECK (kind <> 'user' OR invitee_id IS NOT NULL),
+  CHECK (kind <> 'email' OR invitee_email IS NOT NULL)
+);
+
+CREATE INDEX IF NOT EXISTS idx_mentorship_invites_mentor ON mentorship_invites(mentor_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_mentorship_invites_invitee ON mentorship_invites(invitee_id) WHERE invitee_id IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_mentorship_invites_email ON mentorship_invites(invitee_email) WHERE invitee_email IS NOT NULL;
+
+CREATE TABLE IF NOT EXISTS invite_redemptions (
+  invite_id BIGINT NOT NULL REFERENCES mentorship_invites(id) ON DELETE CASCADE,
+  student_id BIGINT NOT NULL,
+  mentorship_id BIGINT NOT NULL,
+  redeemed_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  PRIMARY KEY (invite_id, student_id)
+);
@@ -0,0 +1,27 @@
+ALTER TABLE user_roles DROP CONSTRAINT IF EXISTS user_roles_role_check;
+ALTER TABLE user_roles ADD CONSTRAINT user_roles_role_check
+  CHECK (role IN ('student','mentor','admin'));
+
+CREATE TABLE IF NOT EXISTS mentorship_transfers (
+  id BIGSERIAL PRIMARY KEY,
+  mentorship_id BIGINT NOT NULL REFERENCES mentorships(id) ON DELETE CASCADE,
+  student_id BIGINT NOT NULL,
+  from_mentor_id BIGINT NOT NULL,
+  to_mentor_id BIGINT NOT NULL,
+  initiated_by BIGINT NOT NULL,
+  summary TEXT,
+  carry_plans BOOLEAN NOT NULL DEFAULT false,
+  carry_goals BOOLEAN NOT NULL DEFAULT false,
+  status TEXT NOT NULL DEFAULT 'pending' CHECK (status IN ('pending','accepted','declined','cancelled')),
+  new_mentorship_id BIGINT REFERENCES mentorships(id) ON DELETE SET NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  decided_at TIMESTAMPTZ,
+  CHECK (from_mentor_id <> to_mentor_id)
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS uniq_pending_transfer
+  ON mentorship_transfers(mentorship_id)
+  WHERE status = 'pending';
+
+CREATE INDEX IF NOT EXISTS idx_mentorship_transfers_to ON mentorship_transfers(to_mentor_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_mentorship_transfers_from ON mentorship_transfers(from_mentor_id, created_at DESC);
@@ -0,0 +1,10 @@
+-- client-generated ids let a client retry a send without creating duplicates
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS client_id TEXT;
+CREATE UNIQUE INDEX IF NOT EXISTS uniq_messages_client_id
+  ON messages(conversation_id, author_id, client_id)
+  WHERE client_id IS NOT NULL;
+
+ALTER TABLE global_messages ADD COLUMN IF NOT EXISTS client_id TEXT;
+CREATE UNIQUE INDEX IF NOT EXISTS uniq_global_messages_client_id
+  ON global_messages(author_id, client_id)
+  WHERE client_id IS NOT NULL;
@@ -0,0 +1,9 @@
+-- broadcasts too large for a NOTIFY payload; listeners fetch them by id
+CREATE TABLE IF NOT EXISTS chat_broker_payloads (
+  id BIGSERIAL PRIMARY KEY,
+  room TEXT NOT NULL,
+  payload BYTEA NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idx_chat_broker_payloads_created ON chat_broker_payloads(created_at);
@@ -0,0 +1,24 @@
+ALTER TABLE user_roles DROP CONSTRAINT IF EXISTS user_roles_role_check;
+ALTER TABLE user_roles ADD CONSTRAINT user_roles_role_check
+  CHECK (role IN ('student','mentor','admin','moderator'));
+
+-- edits keep the current body on the message; earlier bodies go to
+-- message_revisions. Deleted messages keep their row but lose their body.
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS edited_at TIMESTAMPTZ;
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMPTZ;
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS deleted_by BIGINT;
+
+ALTER TABLE global_messages ADD COLUMN IF NOT EXISTS edited_at TIMESTAMPTZ;
+ALTER TABLE global_messages ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMPTZ;
+ALTER TABLE global_messages ADD COLUMN IF NOT EXISTS deleted_by BIGINT;
+
+CREATE TABLE IF NOT EXISTS message_revisions (
+  id BIGSERIAL PRIMARY KEY,
+  scope TEXT NOT NULL CHECK (scope IN ('conversation','global')),
+  message_id BIGINT NOT NULL,
+  body TEXT NOT NULL,
+  edited_by BIGINT NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idx_message_revisions_message ON message_revisions(scope, message_id, id);
@@ -0,0 +1,8 @@
+-- full-text search; 'simple' keeps matching language-neutral (no stemming)
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS body_tsv tsvector
+  GENERATED ALWAYS AS (to_tsvector('simple', body)) STORED;
+CREATE INDEX IF NOT EXISTS idx_messages_body_tsv ON messages USING GIN (body_tsv);
+
+ALTER TABLE global_messages ADD COLUMN IF NOT EXISTS body_tsv tsvector
+  GENERATED ALWAYS AS (to_tsvector('simple', body)) STORED;
+CREATE INDEX IF NOT EXISTS idx_global_messages_body_tsv ON global_messages USING GIN (body_tsv);
@@ -0,0 +1,4 @@
+-- last message per conversation and unread counts for the conversation list
+CREATE INDEX IF NOT EXISTS idx_messages_conv_id ON messages(conversation_id, id DESC);
+CREATE INDEX IF NOT EXISTS idx_messages_unread ON messages(conversation_id, author_id)
+  WHERE read_at IS NULL AND deleted_at IS NULL;
@@ -0,0 +1,51 @@
+CREATE TABLE IF NOT EXISTS message_reports (
+  id BIGSERIAL PRIMARY KEY,
+  message_id BIGINT NOT NULL REFERENCES global_messages(id) ON DELETE CASCADE,
+  reporter_id BIGINT NOT NULL,
+  reason TEXT,
+  status TEXT NOT NULL DEFAULT 'open' CHECK (status IN ('open','resolved','dismissed')),
+  resolved_by BIGINT,
+  resolved_at TIMESTAMPTZ,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  UNIQUE (message_id, reporter_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_message_reports_status ON message_reports(status, created_at DESC);
+
+-- mutes stop posting, bans also reading; expires_at NULL means permanent
+CREATE TABLE IF NOT EXISTS chat_sanctions (
+  id BIGSERIAL PRIMARY KEY,
+  user_id BIGINT NOT NULL,
+  kind TEXT NOT NULL CHECK (kind IN ('mute','ban')),
+  reason TEXT,
+  created_by BIGINT NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  expires_at TIMESTAMPTZ,
+  revoked_by BIGINT,
+  revoked_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS idx_chat_sanctions_user ON chat_sanctions(user_id) WHERE revoked_at IS NULL;
+
+-- single row of global chat rules, edited by moderators
+CREATE TABLE IF NOT EXISTS global_chat_settings (
+  id INT PRIMARY KEY DEFAULT 1 CHECK (id = 1),
+  slow_mode_seconds INT NOT NULL DEFAULT 0 CHECK (slow_mode_seconds >= 0),
+  blocked_words TEXT[] NOT NULL DEFAULT '{}',
+  updated_by BIGINT,
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+INSERT INTO global_chat_settings(id) VALUES(1) ON CONFLICT DO NOTHING;
+
+CREATE TABLE IF NOT EXISTS moderation_log (
+  id BIGSERIAL PRIMARY KEY,
+  moderator_id BIGINT NOT NULL,
+  action TEXT NOT NULL,
+  target_user_id BIGINT,
+  message_id BIGINT,
+  details JSONB NOT NULL DEFAULT '{}',
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idx_moderation_log_created ON moderation_log(created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_global_messages_author ON global_messages(author_id, created_at DESC);
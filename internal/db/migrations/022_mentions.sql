@@ -0,0 +1,51 @@
+-- handles are lowercase [a-z0-9_]{3,30}; existing users get one derived
+-- from their email, oldest first, suffixed with their id when taken (and
+-- with a counter on top if even that is taken, e.g. by a user whose email
+-- already reads bob_7). The unique index allows the NULLs still pending.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS handle TEXT;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_handle ON users(handle);
+
+DO $$
+DECLARE
+  u RECORD;
+  b TEXT;
+  h TEXT;
+  n INT;
+BEGIN
+  FOR u IN SELECT id, email FROM users WHERE handle IS NULL ORDER BY id LOOP
+    b := left(regexp_replace(lower(split_part(u.email,'@',1)), '[^a-z0-9_]', '', 'g'), 24);
+    IF length(b) < 3 THEN
+      b := b || '_user';
+    END IF;
+    h := b;
+    n := 1;
+    WHILE EXISTS (SELECT 1 FROM users WHERE handle = h) LOOP
+      h := u.id::text;
+      IF n > 1 THEN
+        h := h || '_' || n;
+      END IF;
+      h := left(b, 29 - length(h)) || '_' || h;
+      n := n + 1;
+    END LOOP;
+    UPDATE users SET handle = h WHERE id = u.id;
+  END LOOP;
+END;
+$$;
+
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_handle_check;
+ALTER TABLE users ADD CONSTRAINT users_handle_check CHECK (handle ~ '^[a-z0-9_]{3,30}$');
+
+-- offset and length count Unicode code points of the message body
+CREATE TABLE IF NOT EXISTS message_mentions (
+  id BIGSERIAL PRIMARY KEY,
+  scope TEXT NOT NULL CHECK (scope IN ('global','cohort')),
+  message_id BIGINT NOT NULL,
+  user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  handle TEXT NOT NULL,
+  "offset" INT NOT NULL,
+  length INT NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idx_message_mentions_message ON message_mentions(scope, message_id);
+CREATE INDEX IF NOT EXISTS idx_message_mentions_user ON message_mentions(user_id, created_at DESC);
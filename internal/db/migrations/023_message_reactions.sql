@@ -0,0 +1,9 @@
+-- one row per user per emoji per message; scope tells messages from global_messages
+CREATE TABLE IF NOT EXISTS message_reactions (
+  scope TEXT NOT NULL CHECK (scope IN ('conversation','global')),
+  message_id BIGINT NOT NULL,
+  user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  emoji TEXT NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  PRIMARY KEY (scope, message_id, emoji, user_id)
+);
@@ -0,0 +1,5 @@
+-- replies point at the first message of their thread; threads are one level deep
+ALTER TABLE global_messages ADD COLUMN IF NOT EXISTS parent_id BIGINT REFERENCES global_messages(id);
+
+CREATE INDEX IF NOT EXISTS idx_global_messages_parent ON global_messages(parent_id, id) WHERE parent_id IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_global_messages_top ON global_messages(id) WHERE parent_id IS NULL;
@@ -0,0 +1,12 @@
+-- system messages carry a structured event for timeline cards; author_id is
+-- the user whose action produced it
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS event_type TEXT;
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS metadata JSONB;
+
+-- the only system messages before this were transfer handovers
+UPDATE messages SET event_type='mentorship.transferred', metadata=COALESCE(metadata, '{}')
+WHERE author_type='system' AND event_type IS NULL;
+
+ALTER TABLE messages DROP CONSTRAINT IF EXISTS messages_system_event_check;
+ALTER TABLE messages ADD CONSTRAINT messages_system_event_check
+  CHECK ((author_type = 'system') = (event_type IS NOT NULL));
@@ -0,0 +1,12 @@
+-- announce new notifications so the realtime socket can push them; the
+-- payload is delivered on commit, like the row itself
+CREATE OR REPLACE FUNCTION notifications_announce() RETURNS trigger AS $$
+BEGIN
+  PERFORM pg_notify('notifications', json_build_object('id', NEW.id, 'userId', NEW.user_id)::text);
+  RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS notifications_announce ON notifications;
+CREATE TRIGGER notifications_announce AFTER INSERT ON notifications
+  FOR EACH ROW EXECUTE FUNCTION notifications_announce();
@@ -0,0 +1,6 @@
+-- sanctions must name a real user; drop any issued against unknown ids
+DELETE FROM chat_sanctions s WHERE NOT EXISTS (SELECT 1 FROM users u WHERE u.id = s.user_id);
+
+ALTER TABLE chat_sanctions DROP CONSTRAINT IF EXISTS chat_sanctions_user_id_fkey;
+ALTER TABLE chat_sanctions ADD CONSTRAINT chat_sanctions_user_id_fkey
+  FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE;
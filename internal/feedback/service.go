@@ -0,0 +1,291 @@
+package feedback
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"upskill/internal/auth"
+	"upskill/internal/web"
+)
+
+// Tags students can attach to a review.
+var Tags = []string{
+	"helpful", "clear", "patient", "knowledgeable", "responsive", "well-prepared",
+	"practical", "encouraging", "unprepared", "unclear", "unresponsive", "late",
+}
+
+var tagSet = func() map[string]struct{} {
+	m := make(map[string]struct{}, len(Tags))
+	for _, t := range Tags {
+		m[t] = struct{}{}
+	}
+	return m
+}()
+
+type Service struct{ db *pgxpool.Pool }
+
+func NewService(db *pgxpool.Pool) *Service { return &Service{db: db} }
+
+// Create stores a review of mentor {id} by the caller. A review either
+// belongs to a finished session (one per session) or to the mentorship in
+// general (one per mentor per calendar month).
+func (s *Service) Create(w http.ResponseWriter, r *http.Request) {
+	uid := auth.UserID(r)
+	mentorID, err := web.ParamInt64(r, "id")
+	if err != nil {
+		http.Error(w, "bad id", 400)
+		return
+	}
+	var in struct {
+		Rating    int      `json:"rating"`
+		Tags      []string `json:"tags"`
+		Comment   string   `json:"comment"`
+		SessionID *int64   `json:"sessionId,omitempty"`
+	}
+	if err := web.DecodeJSON(r, &in); err != nil || in.Rating < 1 || in.Rating > 5 {
+		http.Error(w, "bad input", 400)
+		return
+	}
+	tags, ok := normalizeTags(in.Tags)
+	if !ok {
+		http.Error(w, "unknown tag", 400)
+		return
+	}
+
+	var mentorshipID int64
+	err = s.db.QueryRow(r.Context(), `
+		SELECT id FROM mentorships
+		WHERE student_id=$1 AND mentor_id=$2
+		ORDER BY (status='active') DESC, created_at DESC
+		LIMIT 1
+	`, uid, mentorID).Scan(&mentorshipID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			http.Error(w, "no mentorship with this mentor", 403)
+			return
+		}
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	var sessionID any
+	if in.SessionID != nil {
+		var ends time.Time
+		var status string
+		err := s.db.QueryRow(r.Context(), `
+			SELECT mentorship_id, ends_at, status FROM mentor_sessions
+			WHERE id=$1 AND student_id=$2 AND mentor_id=$3
+		`, *in.SessionID, uid, mentorID).Scan(&mentorshipID, &ends, &status)
+		if err != nil {
+			http.Error(w, "session not found", 404)
+			return
+		}
+		if status != "booked" || ends.After(time.Now()) {
+			http.Error(w, "session has not taken place", 409)
+			return
+		}
+		sessionID = *in.SessionID
+	}
+
+	var id int64
+	err = s.db.QueryRow(r.Context(), `
+		INSERT INTO mentor_feedback(mentor_id, student_id, mentorship_id, session_id, period, rating, tags, comment)
+		VALUES($1,$2,$3,$4,date_trunc('month', now())::date,$5,$6,$7) RETURNING id
+	`, mentorID, uid, mentorshipID, sessionID, in.Rating, tags, strings.TrimSpace(in.Comment)).Scan(&id)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			http.Error(w, "already reviewed for this period", 409)
+			return
+		}
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	web.JSON(w, 201, map[string]any{"feedbackId": id})
+}
+
+func (s *Service) TagList(w http.ResponseWriter, r *http.Request) {
+	web.JSON(w, 200, map[string]any{"items": Tags})
+}
+
+// minCommentMonth is how many reviews a month needs before Mine shows their
+// comments; with fewer, a mentor could tell who wrote one.
+const minCommentMonth = 3
+
+// Mine shows a mentor the feedback they received, without reviewer identity
+// and with dates reduced to the month. Comments of thin months are left out.
+func (s *Service) Mine(w http.ResponseWriter, r *http.Request) {
+	mid := auth.UserID(r)
+	rows, err := s.db.Query(r.Context(), `
+		SELECT to_char(period,'YYYY-MM'), rating, tags, COALESCE(comment,''), session_id IS NOT NULL
+		FROM mentor_feedback
+		WHERE mentor_id=$1
+		ORDER BY period DESC, random()
+	`, mid)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	defer rows.Close()
+
+	type Item struct {
+		Period  string   `json:"period"`
+		Rating  int      `json:"rating"`
+		Tags    []string `json:"tags"`
+		Comment string   `json:"comment,omitempty"`
+		Session bool     `json:"session"`
+	}
+	type Month struct {
+		Period  string  `json:"period"`
+		Count   int     `json:"count"`
+		Average float64 `json:"average"`
+	}
+	items := []Item{}
+	var months []Month
+	for rows.Next() {
+		var it Item
+		if err := rows.Scan(&it.Period, &it.Rating, &it.Tags, &it.Comment, &it.Session); err != nil {
+			continue
+		}
+		items = append(items, it)
+		if n := len(months); n == 0 || months[n-1].Period != it.Period {
+			months = append(months, Month{Period: it.Period})
+		}
+		m := &months[len(months)-1]
+		m.Average = (m.Average*float64(m.Count) + float64(it.Rating)) / float64(m.Count+1)
+		m.Count++
+	}
+	counts := make(map[string]int, len(months))
+	for _, m := range months {
+		counts[m.Period] = m.Count
+	}
+	for i := range items {
+		if counts[items[i].Period] < minCommentMonth {
+			items[i].Comment = ""
+		}
+	}
+	sum, err := Summaries(r.Context(), s.db, []int64{mid})
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	web.JSON(w, 200, map[string]any{"summary": sum[mid], "months": months, "items": items})
+}
+
+type TagCount struct {
+	Tag   string `json:"tag"`
+	Count int    `json:"count"`
+}
+
+// Summary is the public aggregate of a mentor's ratings.
+type Summary struct {
+	Count        int        `json:"count"`
+	Average      *float64   `json:"average,omitempty"`
+	Distribution [5]int     `json:"distribution"`
+	TopTags      []TagCount `json:"topTags"`
+}
+
+type querier interface {
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+}
+
+// Summaries aggregates ratings for the given mentors. Mentors without
+// feedback get an empty summary.
+func Summaries(ctx context.Context, q querier, mentorIDs []int64) (map[int64]Summary, error) {
+	res := make(map[int64]Summary, len(mentorIDs))
+	for _, id := range mentorIDs {
+		res[id] = Summary{TopTags: []TagCount{}}
+	}
+	rows, err := q.Query(ctx, `
+		SELECT mentor_id, rating, COUNT(*)
+		FROM mentor_feedback WHERE mentor_id = ANY($1)
+		GROUP BY mentor_id, rating
+	`, mentorIDs)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var mid int64
+		var rating, cnt int
+		if err := rows.Scan(&mid, &rating, &cnt); err != nil || rating < 1 || rating > 5 {
+			continue
+		}
+		sm := res[mid]
+		sm.Distribution[rating-1] += cnt
+		sm.Count += cnt
+		res[mid] = sm
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for id, sm := range res {
+		if sm.Count == 0 {
+			continue
+		}
+		total := 0
+		for i, n := range sm.Distribution {
+			total += (i + 1) * n
+		}
+		avg := float64(total) / float64(sm.Count)
+		sm.Average = &avg
+		res[id] = sm
+	}
+
+	rows, err = q.Query(ctx, `
+		SELECT f.mentor_id, t.tag, COUNT(*)
+		FROM mentor_feedback f, unnest(f.tags) AS t(tag)
+		WHERE f.mentor_id = ANY($1)
+		GROUP BY f.mentor_id, t.tag
+	`, mentorIDs)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var mid int64
+		var tc TagCount
+		if err := rows.Scan(&mid, &tc.Tag, &tc.Count); err == nil {
+			sm := res[mid]
+			sm.TopTags = append(sm.TopTags, tc)
+			res[mid] = sm
+		}
+	}
+	for id, sm := range res {
+		sort.Slice(sm.TopTags, func(i, j int) bool {
+			if sm.TopTags[i].Count != sm.TopTags[j].Count {
+				return sm.TopTags[i].Count > sm.TopTags[j].Count
+			}
+			return sm.TopTags[i].Tag < sm.TopTags[j].Tag
+		})
+		if len(sm.TopTags) > 5 {
+			sm.TopTags = sm.TopTags[:5]
+		}
+		res[id] = sm
+	}
+	return res, rows.Err()
+}
+
+func normalizeTags(in []string) ([]string, bool) {
+	res := make([]string, 0, len(in))
+	seen := map[string]struct{}{}
+	for _, t := range in {
+		t = strings.ToLower(strings.TrimSpace(t))
+		if _, ok := tagSet[t]; !ok {
+			return nil, false
+		}
+		if _, dup := seen[t]; !dup {
+			seen[t] = struct{}{}
+			res = append(res, t)
+		}
+	}
+	return res, true
+}
@@ -0,0 +1,190 @@
+package mentorship
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/jackc/pgx/v5"
+
+	"upskill/internal/feedback"
+	"upskill/internal/web"
+)
+
+// ResponseStats describes how a mentor handles incoming requests. Hours are
+// measured from request creation to approve/decline; expired requests count
+// as unanswered.
+type ResponseStats struct {
+	Received            int      `json:"received"`
+	Responded           int      `json:"responded"`
+	Expired             int      `json:"expired"`
+	Pending             int      `json:"pending"`
+	ResponseRate        *float64 `json:"responseRate,omitempty"`
+	AvgResponseHours    *float64 `json:"avgResponseHours,omitempty"`
+	MedianResponseHours *float64 `json:"medianResponseHours,omitempty"`
+}
+
+// responseStatsCols aggregates mentorship_requests joined as "mr"; the
+// columns line up with ResponseStats.dest.
+const responseStatsCols = `
+	COUNT(mr.id),
+	COUNT(mr.id) FILTER (WHERE mr.status IN ('approved','declined')),
+	COUNT(mr.id) FILTER (WHERE mr.status = 'expired'),
+	COUNT(mr.id) FILTER (WHERE mr.status = 'pending'),
+	(AVG(EXTRACT(EPOCH FROM mr.decided_at - mr.created_at)/3600)
+		FILTER (WHERE mr.status IN ('approved','declined')))::float8,
+	(percentile_cont(0.5) WITHIN GROUP (ORDER BY EXTRACT(EPOCH FROM mr.decided_at - mr.created_at)/3600)
+		FILTER (WHERE mr.status IN ('approved','declined')))::float8
+`
+
+func (st *ResponseStats) dest() []any {
+	return []any{&st.Received, &st.Responded, &st.Expired, &st.Pending, &st.AvgResponseHours, &st.MedianResponseHours}
+}
+
+func (st *ResponseStats) finish() {
+	if answered := st.Responded + st.Expired; answered > 0 {
+		rate := float64(st.Responded) / float64(answered)
+		st.ResponseRate = &rate
+	}
+}
+
+var directorySpec = web.ListSpec{
+	IDColumn: "u.id",
+	Sorts: map[string]web.SortKey{
+		"id":   {Column: "u.id", Type: web.SortInt},
+		"name": {Column: "COALESCE(u.first_name,'')||' '||COALESCE(u.last_name,'')", Type: web.SortText},
+	},
+	DefaultSort:  "id",
+	SearchColumn: "COALESCE(u.first_name,'')||' '||COALESCE(u.last_name,'')",
+	DefaultLimit: 50,
+	MaxLimit:     200,
+}
+
+// Directory lists every mentor together with their request response stats
+// and rating summary.
+func (s *Service) Directory(w http.ResponseWriter, r *http.Request) {
+	lq, err := web.ParseList(r, directorySpec)
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	var args web.Args
+	where := lq.Where(&args)
+	rows, err := s.db.Query(r.Context(), `
+		SELECT u.id,
+		       COALESCE(u.first_name,'')||' '||COALESCE(u.last_name,'') as name,
+		       COALESCE(u.avatar_url,''),
+		       `+responseStatsCols+`
+		FROM user_roles ur
+		JOIN users u ON u.id = ur.user_id
+		LEFT JOIN mentorship_requests mr ON mr.mentor_id = u.id
+		WHERE ur.role='mentor'`+where+`
+		GROUP BY u.id`+lq.OrderLimit(&args), args...)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	defer rows.Close()
+
+	type Item struct {
+		MentorID  int64            `json:"mentorId"`
+		Name      string           `json:"name"`
+		AvatarURL string           `json:"avatarUrl,omitempty"`
+		Stats     ResponseStats    `json:"responseStats"`
+		Ratings   feedback.Summary `json:"ratings"`
+	}
+	items := []Item{}
+	var ids []int64
+	for rows.Next() {
+		var it Item
+		dest := append([]any{&it.MentorID, &it.Name, &it.AvatarURL}, it.Stats.dest()...)
+		if err := rows.Scan(dest...); err == nil {
+			it.Stats.finish()
+			items = append(items, it)
+			ids = append(ids, it.MentorID)
+		}
+	}
+	rows.Close()
+	ratings, err := feedback.Summaries(r.Context(), s.db, ids)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	for i := range items {
+		items[i].Ratings = ratings[items[i].MentorID]
+	}
+	items, next := web.Page(w, r, lq, items, func(it Item, sort string) (any, int64) {
+		if sort == "name" {
+			return it.Name, it.MentorID
+		}
+		return it.MentorID, it.MentorID
+	})
+	web.JSON(w, 200, map[string]any{"items": items, "nextCursor": next})
+}
+
+// MentorStats returns the response stats of a single mentor.
+func (s *Service) MentorStats(w http.ResponseWriter, r *http.Request) {
+	mentorID, err := web.ParamInt64(r, "id")
+	if err != nil {
+		http.Error(w, "bad id", 400)
+		return
+	}
+	var st ResponseStats
+	err = s.db.QueryRow(r.Context(), `
+		SELECT `+responseStatsCols+`
+		FROM user_roles ur
+		LEFT JOIN mentorship_requests mr ON mr.mentor_id = ur.user_id
+		WHERE ur.user_id=$1 AND ur.role='mentor'
+		GROUP BY ur.user_id
+	`, mentorID).Scan(st.dest()...)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			http.Error(w, "not found", 404)
+			return
+		}
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	st.finish()
+	web.JSON(w, 200, map[string]any{"mentorId": mentorID, "responseStats": st})
+}
+
+// Profile is the public mentor profile: identity, response stats and the
+// aggregated ratings left by former and current mentees.
+func (s *Service) Profile(w http.ResponseWriter, r *http.Request) {
+	mentorID, err := web.ParamInt64(r, "id")
+	if err != nil {
+		http.Error(w, "bad id", 400)
+		return
+	}
+	var name, avatar string
+	var st ResponseStats
+	dest := append([]any{&name, &avatar}, st.dest()...)
+	err = s.db.QueryRow(r.Context(), `
+		SELECT COALESCE(u.first_name,'')||' '||COALESCE(u.last_name,''),
+		       COALESCE(u.avatar_url,''),
+		       `+responseStatsCols+`
+		FROM user_roles ur
+		JOIN users u ON u.id = ur.user_id
+		LEFT JOIN mentorship_requests mr ON mr.mentor_id = u.id
+		WHERE ur.user_id=$1 AND ur.role='mentor'
+		GROUP BY u.id
+	`, mentorID).Scan(dest...)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			http.Error(w, "not found", 404)
+			return
+		}
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	st.finish()
+	ratings, err := feedback.Summaries(r.Context(), s.db, []int64{mentorID})
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	web.JSON(w, 200, map[string]any{
+		"mentorId": mentorID, "name": name, "avatarUrl": avatar,
+		"responseStats": st, "ratings": ratings[mentorID],
+	})
+}
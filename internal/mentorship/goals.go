@@ -0,0 +1,717 @@
+package mentorship
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+
+	"upskill/internal/auth"
+	"upskill/internal/web"
+)
+
+// Goals and milestones form the mentorship agreement. Either party proposes,
+// the other party accepts; changing the terms of an item (title, description,
+// target date) sends it back to "proposed" so the other side re-confirms.
+
+type execer interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+}
+
+type mentorshipRow struct {
+	ID        int64
+	StudentID int64
+	MentorID  int64
+	Status    string
+}
+
+func (m mentorshipRow) isParty(uid int64) bool {
+	return uid == m.StudentID || uid == m.MentorID
+}
+
+// open reports whether the agreement may still be changed.
+func (m mentorshipRow) open() bool { return m.Status == "active" || m.Status == "paused" }
+
+func (s *Service) loadMentorship(ctx context.Context, id int64) (mentorshipRow, error) {
+	var m mentorshipRow
+	err := s.db.QueryRow(ctx, `SELECT id, student_id, mentor_id, status FROM mentorships WHERE id=$1`, id).
+		Scan(&m.ID, &m.StudentID, &m.MentorID, &m.Status)
+	return m, err
+}
+
+// partyMentorship resolves {id} and checks the caller belongs to it, writing
+// the error response itself when it returns false.
+func (s *Service) partyMentorship(w http.ResponseWriter, r *http.Request) (mentorshipRow, bool) {
+	uid := auth.UserID(r)
+	id, err := web.ParamInt64(r, "id")
+	if err != nil {
+		http.Error(w, "bad id", 400)
+		return mentorshipRow{}, false
+	}
+	m, err := s.loadMentorship(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			http.Error(w, "not found", 404)
+			return m, false
+		}
+		http.Error(w, err.Error(), 500)
+		return m, false
+	}
+	if !m.isParty(uid) {
+		http.Error(w, "forbidden", 403)
+		return m, false
+	}
+	return m, true
+}
+
+type milestoneOut struct {
+	ID          int64      `json:"id"`
+	Title       string     `json:"title"`
+	TargetDate  string     `json:"targetDate,omitempty"`
+	Status      string     `json:"status"`
+	Progress    int        `json:"progress"`
+	ProposedBy  int64      `json:"proposedBy"`
+	AcceptedAt  *time.Time `json:"acceptedAt,omitempty"`
+	CompletedAt *time.Time `json:"completedAt,omitempty"`
+	TaskIDs     []int64    `json:"taskIds"`
+}
+
+type goalOut struct {
+	ID          int64          `json:"id"`
+	Title       string         `json:"title"`
+	Description string         `json:"description"`
+	TargetDate  string         `json:"targetDate,omitempty"`
+	Status      string         `json:"status"`
+	Progress    int            `json:"progress"`
+	ProposedBy  int64          `json:"proposedBy"`
+	AcceptedAt  *time.Time     `json:"acceptedAt,omitempty"`
+	CreatedAt   time.Time      `json:"createdAt"`
+	UpdatedAt   time.Time      `json:"updatedAt"`
+	Milestones  []milestoneOut `json:"milestones"`
+}
+
+var goalsSpec = web.ListSpec{
+	IDColumn: "id",
+	Sorts: map[string]web.SortKey{
+		"createdAt": {Column: "created_at", Type: web.SortTime},
+		"updatedAt": {Column: "updated_at", Type: web.SortTime},
+	},
+	DefaultSort:  "createdAt",
+	StatusColumn: "status",
+	Statuses:     []string{"proposed", "accepted", "completed", "dropped"},
+	DateColumn:   "created_at",
+	SearchColumn: "title",
+	DefaultLimit: 50,
+	MaxLimit:     200,
+}
+
+func (s *Service) ListGoals(w http.ResponseWriter, r *http.Request) {
+	m, ok := s.partyMentorship(w, r)
+	if !ok {
+		return
+	}
+	lq, err := web.ParseList(r, goalsSpec)
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	goals, err := s.loadGoals(r.Context(), m.ID, lq)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	goals, next := web.Page(w, r, lq, goals, func(g goalOut, sort string) (any, int64) {
+		if sort == "updatedAt" {
+			return g.UpdatedAt, g.ID
+		}
+		return g.CreatedAt, g.ID
+	})
+	web.JSON(w, 200, map[string]any{"mentorshipId": m.ID, "status": m.Status, "items": goals, "nextCursor": next})
+}
+
+// loadGoals loads a page of the mentorship's goals, as asked for by lq, with
+// their milestones.
+func (s *Service) loadGoals(ctx context.Context, mentorshipID int64, lq web.ListQuery) ([]goalOut, error) {
+	args := web.Args{mentorshipID}
+	rows, err := s.db.Query(ctx, `
+		SELECT id, title, COALESCE(description,''), target_date, status, proposed_by, accepted_at, created_at, updated_at
+		FROM mentorship_goals WHERE mentorship_id=$1`+lq.Where(&args)+lq.OrderLimit(&args), args...)
+	if err != nil {
+		return nil, err
+	}
+	goals := []goalOut{}
+	index := map[int64]int{}
+	var ids []int64
+	for rows.Next() {
+		var g goalOut
+		var target *time.Time
+		if err := rows.Scan(&g.ID, &g.Title, &g.Description, &target, &g.Status, &g.ProposedBy, &g.AcceptedAt, &g.CreatedAt, &g.UpdatedAt); err == nil {
+			g.TargetDate = formatDate(target)
+			g.Milestones = []milestoneOut{}
+			index[g.ID] = len(goals)
+			ids = append(ids, g.ID)
+			goals = append(goals, g)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = s.db.Query(ctx, `
+		SELECT gm.goal_id, gm.id, gm.title, gm.target_date, gm.status, gm.progress, gm.proposed_by,
+		       gm.accepted_at, gm.completed_at,
+		       COALESCE(array_agg(mt.task_id ORDER BY mt.task_id) FILTER (WHERE mt.task_id IS NOT NULL), '{}')
+		FROM goal_milestones gm
+		LEFT JOIN milestone_tasks mt ON mt.milestone_id = gm.id
+		WHERE gm.goal_id = ANY($1)
+		GROUP BY gm.id
+		ORDER BY gm.target_date NULLS LAST, gm.id
+	`, ids)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var goalID int64
+		var ms milestoneOut
+		var target *time.Time
+		if err := rows.Scan(&goalID, &ms.ID, &ms.Title, &target, &ms.Status, &ms.Progress, &ms.ProposedBy,
+			&ms.AcceptedAt, &ms.CompletedAt, &ms.TaskIDs); err != nil {
+			continue
+		}
+		ms.TargetDate = formatDate(target)
+		if i, ok := index[goalID]; ok {
+			goals[i].Milestones = append(goals[i].Milestones, ms)
+		}
+	}
+	for i := range goals {
+		goals[i].Progress = goalProgress(goals[i])
+	}
+	return goals, rows.Err()
+}
+
+// goalProgress averages milestone progress; a completed goal is 100%.
+func goalProgress(g goalOut) int {
+	if g.Status == "completed" {
+		return 100
+	}
+	if len(g.Milestones) == 0 {
+		return 0
+	}
+	sum := 0
+	for _, ms := range g.Milestones {
+		sum += ms.Progress
+	}
+	return sum / len(g.Milestones)
+}
+
+type milestoneIn struct {
+	Title      string  `json:"title"`
+	TargetDate string  `json:"targetDate"`
+	TaskIDs    []int64 `json:"taskIds"`
+}
+
+func (s *Service) ProposeGoal(w http.ResponseWriter, r *http.Request) {
+	uid := auth.UserID(r)
+	m, ok := s.partyMentorship(w, r)
+	if !ok {
+		return
+	}
+	if !m.open() {
+		http.Error(w, "mentorship ended", 409)
+		return
+	}
+	var in struct {
+		Title       string        `json:"title"`
+		Description string        `json:"description"`
+		TargetDate  string        `json:"targetDate"`
+		Milestones  []milestoneIn `json:"milestones"`
+	}
+	if err := web.DecodeJSON(r, &in); err != nil || strings.TrimSpace(in.Title) == "" {
+		http.Error(w, "bad input", 400)
+		return
+	}
+	target, err := parseDate(in.TargetDate)
+	if err != nil {
+		http.Error(w, "bad targetDate", 400)
+		return
+	}
+
+	tx, err := s.db.Begin(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	defer tx.Rollback(r.Context())
+
+	var goalID int64
+	if err := tx.QueryRow(r.Context(), `
+		INSERT INTO mentorship_goals(mentorship_id, title, description, target_date, status, proposed_by)
+		VALUES($1,$2,$3,$4,'proposed',$5) RETURNING id
+	`, m.ID, strings.TrimSpace(in.Title), in.Description, target, uid).Scan(&goalID); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	for _, ms := range in.Milestones {
+		if _, status, err := s.insertMilestone(r.Context(), tx, m, goalID, uid, ms); err != nil {
+			http.Error(w, err.Error(), status)
+			return
+		}
+	}
+	if err := tx.Commit(r.Context()); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	web.JSON(w, 201, map[string]any{"goalId": goalID, "status": "proposed"})
+}
+
+func (s *Service) UpdateGoal(w http.ResponseWriter, r *http.Request) {
+	uid := auth.UserID(r)
+	m, ok := s.partyMentorship(w, r)
+	if !ok {
+		return
+	}
+	if !m.open() {
+		http.Error(w, "mentorship ended", 409)
+		return
+	}
+	goalID, err := web.ParamInt64(r, "goalId")
+	if err != nil {
+		http.Error(w, "bad goalId", 400)
+		return
+	}
+	var in struct {
+		Title       *string `json:"title"`
+		Description *string `json:"description"`
+		TargetDate  *string `json:"targetDate"`
+		Status      *string `json:"status"`
+	}
+	if err := web.DecodeJSON(r, &in); err != nil {
+		http.Error(w, "bad input", 400)
+		return
+	}
+
+	tx, err := s.db.Begin(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	defer tx.Rollback(r.Context())
+
+	var title, desc, status string
+	var target, acceptedAt *time.Time
+	var proposedBy int64
+	err = tx.QueryRow(r.Context(), `
+		SELECT title, COALESCE(description,''), target_date, status, proposed_by, accepted_at
+		FROM mentorship_goals WHERE id=$1 AND mentorship_id=$2
+		FOR UPDATE
+	`, goalID, m.ID).Scan(&title, &desc, &target, &status, &proposedBy, &acceptedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			http.Error(w, "not found", 404)
+			return
+		}
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	termsChanged := in.Title != nil || in.Description != nil || in.TargetDate != nil
+	if termsChanged {
+		if status != "proposed" && status != "accepted" {
+			http.Error(w, "bad state", 409)
+			return
+		}
+		if in.Title != nil {
+			if strings.TrimSpace(*in.Title) == "" {
+				http.Error(w, "bad title", 400)
+				return
+			}
+			title = strings.TrimSpace(*in.Title)
+		}
+		if in.Description != nil {
+			desc = *in.Description
+		}
+		if in.TargetDate != nil {
+			if target, err = parseDate(*in.TargetDate); err != nil {
+				http.Error(w, "bad targetDate", 400)
+				return
+			}
+		}
+		status, proposedBy, acceptedAt = "proposed", uid, nil
+	}
+	if in.Status != nil {
+		next, code, msg := nextStatus(status, *in.Status, proposedBy, uid, termsChanged)
+		if code != 0 {
+			http.Error(w, msg, code)
+			return
+		}
+		if next == "accepted" && status != "accepted" {
+			now := time.Now()
+			acceptedAt = &now
+		}
+		status = next
+	}
+
+	if _, err := tx.Exec(r.Context(), `
+		UPDATE mentorship_goals
+		SET title=$2, description=$3, target_date=$4, status=$5, proposed_by=$6, accepted_at=$7, updated_at=now()
+		WHERE id=$1
+	`, goalID, title, desc, target, status, proposedBy, acceptedAt); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if err := tx.Commit(r.Context()); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	web.JSON(w, 200, map[string]any{"ok": true, "status": status})
+}
+
+func (s *Service) AddMilestone(w http.ResponseWriter, r *http.Request) {
+	uid := auth.UserID(r)
+	m, ok := s.partyMentorship(w, r)
+	if !ok {
+		return
+	}
+	if !m.open() {
+		http.Error(w, "mentorship ended", 409)
+		return
+	}
+	goalID, err := web.ParamInt64(r, "goalId")
+	if err != nil {
+		http.Error(w, "bad goalId", 400)
+		return
+	}
+	var in milestoneIn
+	if err := web.DecodeJSON(r, &in); err != nil {
+		http.Error(w, "bad input", 400)
+		return
+	}
+	var goalStatus string
+	if err := s.db.QueryRow(r.Context(), `SELECT status FROM mentorship_goals WHERE id=$1 AND mentorship_id=$2`, goalID, m.ID).Scan(&goalStatus); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			http.Error(w, "not found", 404)
+			return
+		}
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if goalStatus != "proposed" && goalStatus != "accepted" {
+		http.Error(w, "bad state", 409)
+		return
+	}
+
+	tx, err := s.db.Begin(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	defer tx.Rollback(r.Context())
+	id, status, err := s.insertMilestone(r.Context(), tx, m, goalID, uid, in)
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+	if err := tx.Commit(r.Context()); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	web.JSON(w, 201, map[string]any{"milestoneId": id, "status": "proposed"})
+}
+
+// insertMilestone returns the HTTP status to use alongside a non-nil error.
+func (s *Service) insertMilestone(ctx context.Context, tx pgx.Tx, m mentorshipRow, goalID, uid int64, in milestoneIn) (int64, int, error) {
+	if strings.TrimSpace(in.Title) == "" {
+		return 0, 400, errors.New("bad milestone title")
+	}
+	target, err := parseDate(in.TargetDate)
+	if err != nil {
+		return 0, 400, errors.New("bad milestone targetDate")
+	}
+	var id int64
+	if err := tx.QueryRow(ctx, `
+		INSERT INTO goal_milestones(goal_id, title, target_date, status, proposed_by)
+		VALUES($1,$2,$3,'proposed',$4) RETURNING id
+	`, goalID, strings.TrimSpace(in.Title), target, uid).Scan(&id); err != nil {
+		return 0, 500, err
+	}
+	if len(in.TaskIDs) > 0 {
+		if status, err := s.linkTasks(ctx, tx, m, id, in.TaskIDs); err != nil {
+			return 0, status, err
+		}
+	}
+	return id, 0, nil
+}
+
+func (s *Service) UpdateMilestone(w http.ResponseWriter, r *http.Request) {
+	uid := auth.UserID(r)
+	m, ok := s.partyMentorship(w, r)
+	if !ok {
+		return
+	}
+	if !m.open() {
+		http.Error(w, "mentorship ended", 409)
+		return
+	}
+	goalID, err := web.ParamInt64(r, "goalId")
+	if err != nil {
+		http.Error(w, "bad goalId", 400)
+		return
+	}
+	msID, err := web.ParamInt64(r, "milestoneId")
+	if err != nil {
+		http.Error(w, "bad milestoneId", 400)
+		return
+	}
+	var in struct {
+		Title      *string  `json:"title"`
+		TargetDate *string  `json:"targetDate"`
+		Status     *string  `json:"status"`
+		Progress   *int     `json:"progress"`
+		TaskIDs    *[]int64 `json:"taskIds"`
+	}
+	if err := web.DecodeJSON(r, &in); err != nil {
+		http.Error(w, "bad input", 400)
+		return
+	}
+
+	tx, err := s.db.Begin(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	defer tx.Rollback(r.Context())
+
+	var title, status string
+	var target, acceptedAt, completedAt *time.Time
+	var progress int
+	var proposedBy int64
+	var linked bool
+	err = tx.QueryRow(r.Context(), `
+		SELECT gm.title, gm.target_date, gm.status, gm.progress, gm.proposed_by, gm.accepted_at, gm.completed_at,
+		       EXISTS(SELECT 1 FROM milestone_tasks mt WHERE mt.milestone_id = gm.id)
+		FROM goal_milestones gm
+		JOIN mentorship_goals g ON g.id = gm.goal_id
+		WHERE gm.id=$1 AND gm.goal_id=$2 AND g.mentorship_id=$3
+		FOR UPDATE OF gm
+	`, msID, goalID, m.ID).Scan(&title, &target, &status, &progress, &proposedBy, &acceptedAt, &completedAt, &linked)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			http.Error(w, "not found", 404)
+			return
+		}
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	termsChanged := in.Title != nil || in.TargetDate != nil
+	if termsChanged {
+		if status == "completed" {
+			http.Error(w, "bad state", 409)
+			return
+		}
+		if in.Title != nil {
+			if strings.TrimSpace(*in.Title) == "" {
+				http.Error(w, "bad title", 400)
+				return
+			}
+			title = strings.TrimSpace(*in.Title)
+		}
+		if in.TargetDate != nil {
+			if target, err = parseDate(*in.TargetDate); err != nil {
+				http.Error(w, "bad targetDate", 400)
+				return
+			}
+		}
+		status, proposedBy, acceptedAt = "proposed", uid, nil
+	}
+	if in.TaskIDs != nil {
+		linked = len(*in.TaskIDs) > 0
+	}
+	if in.Progress != nil {
+		if linked {
+			http.Error(w, "progress follows linked tasks", 409)
+			return
+		}
+		if *in.Progress < 0 || *in.Progress > 100 {
+			http.Error(w, "bad progress", 400)
+			return
+		}
+		if status != "accepted" {
+			http.Error(w, "bad state", 409)
+			return
+		}
+		progress = *in.Progress
+	}
+	if in.Status != nil {
+		if *in.Status == "dropped" {
+			http.Error(w, "bad status", 400)
+			return
+		}
+		next, code, msg := nextStatus(status, *in.Status, proposedBy, uid, termsChanged)
+		if code != 0 {
+			http.Error(w, msg, code)
+			return
+		}
+		if next == "accepted" && status != "accepted" {
+			now := time.Now()
+			acceptedAt = &now
+		}
+		status = next
+	}
+	if status == "completed" && completedAt == nil {
+		now := time.Now()
+		completedAt, progress = &now, 100
+	}
+
+	if _, err := tx.Exec(r.Context(), `
+		UPDATE goal_milestones
+		SET title=$2, target_date=$3, status=$4, progress=$5, proposed_by=$6,
+		    accepted_at=$7, completed_at=$8, updated_at=now()
+		WHERE id=$1
+	`, msID, title, target, status, progress, proposedBy, acceptedAt, completedAt); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if in.TaskIDs != nil {
+		if _, err := tx.Exec(r.Context(), `DELETE FROM milestone_tasks WHERE milestone_id=$1`, msID); err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		if len(*in.TaskIDs) > 0 {
+			if code, err := s.linkTasks(r.Context(), tx, m, msID, *in.TaskIDs); err != nil {
+				http.Error(w, err.Error(), code)
+				return
+			}
+		}
+	}
+	// Tasks may all be done by the time the milestone is accepted.
+	if linked && status == "accepted" {
+		if err := syncMilestoneProgress(r.Context(), tx, `mt.milestone_id = $1`, msID); err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		if err := tx.QueryRow(r.Context(), `SELECT status FROM goal_milestones WHERE id=$1`, msID).Scan(&status); err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+	}
+	if err := tx.Commit(r.Context()); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	web.JSON(w, 200, map[string]any{"ok": true, "status": status})
+}
+
+// linkTasks attaches plan tasks to a milestone. Only tasks from the student's
+// own plans can be linked. Progress is recalculated right away.
+func (s *Service) linkTasks(ctx context.Context, tx pgx.Tx, m mentorshipRow, milestoneID int64, taskIDs []int64) (int, error) {
+	res, err := tx.Exec(ctx, `
+		INSERT INTO milestone_tasks(milestone_id, task_id)
+		SELECT $1, pt.id
+		FROM plan_tasks pt JOIN plans p ON p.id = pt.plan_id
+		WHERE pt.id = ANY($2) AND p.user_id=$3
+		ON CONFLICT DO NOTHING
+	`, milestoneID, taskIDs, m.StudentID)
+	if err != nil {
+		return 500, err
+	}
+	if int(res.RowsAffected()) != len(uniqueIDs(taskIDs)) {
+		return 400, errors.New("unknown task")
+	}
+	if err := syncMilestoneProgress(ctx, tx, `mt.milestone_id = $1`, milestoneID); err != nil {
+		return 500, err
+	}
+	return 0, nil
+}
+
+// SyncTaskMilestones moves milestones linked to taskID forward after the task
+// status changed. Accepted milestones whose linked tasks are all done become
+// completed.
+func SyncTaskMilestones(ctx context.Context, q execer, taskID int64) error {
+	return syncMilestoneProgress(ctx, q, `mt.milestone_id IN (SELECT milestone_id FROM milestone_tasks WHERE task_id=$1)`, taskID)
+}
+
+func syncMilestoneProgress(ctx context.Context, q execer, cond string, arg any) error {
+	_, err := q.Exec(ctx, `
+		UPDATE goal_milestones gm
+		SET progress = sub.pct,
+		    status = CASE WHEN sub.pct = 100 AND gm.status = 'accepted' THEN 'completed' ELSE gm.status END,
+		    completed_at = CASE WHEN sub.pct = 100 AND gm.status = 'accepted' THEN now() ELSE gm.completed_at END,
+		    updated_at = now()
+		FROM (
+			SELECT mt.milestone_id,
+			       (100 * COUNT(*) FILTER (WHERE pt.status = 'completed') / COUNT(*))::int AS pct
+			FROM milestone_tasks mt
+			JOIN plan_tasks pt ON pt.id = mt.task_id
+			WHERE `+cond+`
+			GROUP BY mt.milestone_id
+		) sub
+		WHERE gm.id = sub.milestone_id AND gm.status <> 'completed'
+	`, arg)
+	return err
+}
+
+// nextStatus validates a requested status transition. A non-zero code means
+// the transition is rejected.
+func nextStatus(cur, want string, proposedBy, uid int64, termsChanged bool) (string, int, string) {
+	switch want {
+	case cur:
+		return cur, 0, ""
+	case "accepted":
+		if cur != "proposed" || termsChanged {
+			return "", 409, "bad state"
+		}
+		if proposedBy == uid {
+			return "", 403, "the other party must accept"
+		}
+		return want, 0, ""
+	case "completed":
+		if cur != "accepted" {
+			return "", 409, "bad state"
+		}
+		return want, 0, ""
+	case "dropped":
+		if cur == "completed" {
+			return "", 409, "bad state"
+		}
+		return want, 0, ""
+	default:
+		return "", 400, "bad status"
+	}
+}
+
+func parseDate(v string) (*time.Time, error) {
+	if v == "" {
+		return nil, nil
+	}
+	t, err := time.Parse("2006-01-02", v)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+func formatDate(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.Format("2006-01-02")
+}
+
+func uniqueIDs(ids []int64) []int64 {
+	seen := make(map[int64]struct{}, len(ids))
+	res := make([]int64, 0, len(ids))
+	for _, id := range ids {
+		if _, ok := seen[id]; !ok {
+			seen[id] = struct{}{}
+			res = append(res, id)
+		}
+	}
+	return res
+}
@@ -0,0 +1,553 @@
+package mentorship
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
+
+	"upskill/internal/auth"
+	"upskill/internal/chat"
+	"upskill/internal/notify"
+	"upskill/internal/web"
+)
+
+// Invites are the mentor-initiated counterpart of requests. An invite is
+// addressed to a user, to an email address (which may not have an account
+// yet), or is a bare shareable code with a usage limit. Accepting one
+// activates the mentorship exactly like Approve does.
+
+const (
+	maxInviteUses = 500
+	maxInviteDays = 90
+)
+
+var (
+	errInviteNotFound = errors.New("invite not found")
+	errInviteClosed   = errors.New("invite is no longer valid")
+	errInviteNotYours = errors.New("invite is addressed to someone else")
+	errInviteOwn      = errors.New("cannot accept your own invite")
+	errInviteUsed     = errors.New("invite already redeemed")
+	errAlreadyActive  = errors.New("already active")
+)
+
+func inviteErrorCode(err error) int {
+	switch {
+	case errors.Is(err, errInviteNotFound):
+		return 404
+	case errors.Is(err, errInviteNotYours), errors.Is(err, errInviteOwn):
+		return 403
+	case errors.Is(err, errInviteClosed), errors.Is(err, errInviteUsed), errors.Is(err, errAlreadyActive):
+		return 409
+	}
+	return 500
+}
+
+type invite struct {
+	ID           int64      `json:"id"`
+	Kind         string     `json:"kind"`
+	InviteeID    *int64     `json:"inviteeId,omitempty"`
+	InviteeEmail *string    `json:"inviteeEmail,omitempty"`
+	Code         string     `json:"code"`
+	Message      *string    `json:"message,omitempty"`
+	MaxUses      int        `json:"maxUses"`
+	Uses         int        `json:"uses"`
+	ExpiresAt    *time.Time `json:"expiresAt,omitempty"`
+	Status       string     `json:"status"`
+	CreatedAt    time.Time  `json:"createdAt"`
+}
+
+const inviteCols = `id, kind, invitee_id, invitee_email, code, message, max_uses, uses, expires_at, status, created_at`
+
+func scanInvite(row pgx.Row) (invite, error) {
+	var it invite
+	err := row.Scan(&it.ID, &it.Kind, &it.InviteeID, &it.InviteeEmail, &it.Code, &it.Message,
+		&it.MaxUses, &it.Uses, &it.ExpiresAt, &it.Status, &it.CreatedAt)
+	return it, err
+}
+
+func newInviteCode() (string, error) {
+	b := make([]byte, 10)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b), nil
+}
+
+func (s *Service) CreateInvite(w http.ResponseWriter, r *http.Request) {
+	mid := auth.UserID(r)
+	var in struct {
+		UserID        int64  `json:"userId"`
+		Email         string `json:"email"`
+		MaxUses       int    `json:"maxUses"`
+		ExpiresInDays int    `json:"expiresInDays"`
+		Message       string `json:"message"`
+	}
+	if err := web.DecodeJSON(r, &in); err != nil {
+		http.Error(w, "bad input", 400)
+		return
+	}
+	in.Email = strings.ToLower(strings.TrimSpace(in.Email))
+	if (in.UserID != 0 && in.Email != "") || in.UserID < 0 ||
+		in.MaxUses < 0 || in.MaxUses > maxInviteUses ||
+		in.ExpiresInDays < 0 || in.ExpiresInDays > maxInviteDays ||
+		(in.Email != "" && !strings.Contains(in.Email, "@")) {
+		http.Error(w, "bad input", 400)
+		return
+	}
+	if in.UserID == mid {
+		http.Error(w, "cannot invite yourself", 400)
+		return
+	}
+
+	var isMentor bool
+	if err := s.db.QueryRow(r.Context(), `
+		SELECT EXISTS(SELECT 1 FROM user_roles WHERE user_id=$1 AND role='mentor')
+	`, mid).Scan(&isMentor); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if !isMentor {
+		http.Error(w, "mentor role required", 403)
+		return
+	}
+
+	kind, maxUses := "code", in.MaxUses
+	var inviteeID *int64
+	var inviteeEmail *string
+	switch {
+	case in.UserID != 0:
+		kind, maxUses, inviteeID = "user", 1, &in.UserID
+	case in.Email != "":
+		kind, maxUses, inviteeEmail = "email", 1, &in.Email
+	}
+	if maxUses == 0 {
+		maxUses = 1
+	}
+	var expiresAt *time.Time
+	if in.ExpiresInDays > 0 {
+		t := time.Now().UTC().AddDate(0, 0, in.ExpiresInDays)
+		expiresAt = &t
+	}
+	code, err := newInviteCode()
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	tx, err := s.db.Begin(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	defer tx.Rollback(r.Context())
+
+	// The invitee, if any, must be able to find the invite in GET /invites.
+	var notifyID int64
+	if inviteeID != nil {
+		if err := tx.QueryRow(r.Context(), `SELECT id FROM users WHERE id=$1`, *inviteeID).Scan(&notifyID); err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				http.Error(w, "user not found", 404)
+				return
+			}
+			http.Error(w, err.Error(), 500)
+			return
+		}
+	} else if inviteeEmail != nil {
+		if err := tx.QueryRow(r.Context(), `SELECT id FROM users WHERE email=$1`, *inviteeEmail).Scan(&notifyID); err != nil && !errors.Is(err, pgx.ErrNoRows) {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+	}
+
+	it, err := scanInvite(tx.QueryRow(r.Context(), `
+		INSERT INTO mentorship_invites(mentor_id, kind, invitee_id, invitee_email, code, message, max_uses, expires_at)
+		VALUES($1,$2,$3,$4,$5,NULLIF($6,''),$7,$8)
+		RETURNING `+inviteCols, mid, kind, inviteeID, inviteeEmail, code, in.Message, maxUses, expiresAt))
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if notifyID != 0 {
+		if _, err := notify.Create(r.Context(), tx, notifyID, "mentorship.invite.received", map[string]any{
+			"inviteId": it.ID, "mentorId": mid,
+		}); err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+	}
+	if err := tx.Commit(r.Context()); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	web.JSON(w, 201, it)
+}
+
+var invitesSpec = web.ListSpec{
+	IDColumn: "id",
+	Sorts: map[string]web.SortKey{
+		"createdAt": {Column: "created_at", Type: web.SortTime},
+	},
+	DefaultSort:  "-createdAt",
+	StatusColumn: "status",
+	Statuses:     []string{"active", "revoked", "declined", "used"},
+	DateColumn:   "created_at",
+	DefaultLimit: 50,
+	MaxLimit:     200,
+}
+
+func (s *Service) ListInvites(w http.ResponseWriter, r *http.Request) {
+	mid := auth.UserID(r)
+	lq, err := web.ParseList(r, invitesSpec)
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	args := web.Args{mid}
+	rows, err := s.db.Query(r.Context(), `
+		SELECT `+inviteCols+`
+		FROM mentorship_invites
+		WHERE mentor_id=$1`+lq.Where(&args)+lq.OrderLimit(&args), args...)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	defer rows.Close()
+	items := []invite{}
+	for rows.Next() {
+		if it, err := scanInvite(rows); err == nil {
+			items = append(items, it)
+		}
+	}
+	items, next := web.Page(w, r, lq, items, func(it invite, _ string) (any, int64) { return it.CreatedAt, it.ID })
+	web.JSON(w, 200, map[string]any{"items": items, "nextCursor": next})
+}
+
+func (s *Service) RevokeInvite(w http.ResponseWriter, r *http.Request) {
+	mid := auth.UserID(r)
+	id, err := web.ParamInt64(r, "id")
+	if err != nil {
+		http.Error(w, "bad id", 400)
+		return
+	}
+	res, err := s.db.Exec(r.Context(), `
+		UPDATE mentorship_invites SET status='revoked'
+		WHERE id=$1 AND mentor_id=$2 AND status='active'
+	`, id, mid)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if res.RowsAffected() == 0 {
+		http.Error(w, "not found or bad state", 409)
+		return
+	}
+	web.JSON(w, 200, map[string]any{"ok": true})
+}
+
+var myInvitesSpec = web.ListSpec{
+	IDColumn: "i.id",
+	Sorts: map[string]web.SortKey{
+		"createdAt": {Column: "i.created_at", Type: web.SortTime},
+		"mentor":    {Column: "COALESCE(u.first_name,'')||' '||COALESCE(u.last_name,'')", Type: web.SortText},
+	},
+	DefaultSort:  "-createdAt",
+	DateColumn:   "i.created_at",
+	SearchColumn: "COALESCE(u.first_name,'')||' '||COALESCE(u.last_name,'')",
+	DefaultLimit: 50,
+	MaxLimit:     200,
+}
+
+// MyInvites lists open invites addressed to the caller, by id or by email.
+func (s *Service) MyInvites(w http.ResponseWriter, r *http.Request) {
+	uid := auth.UserID(r)
+	lq, err := web.ParseList(r, myInvitesSpec)
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	args := web.Args{uid}
+	rows, err := s.db.Query(r.Context(), `
+		SELECT i.id, i.mentor_id, COALESCE(u.first_name,'')||' '||COALESCE(u.last_name,'') as mentor,
+		       i.message, i.expires_at, i.created_at
+		FROM mentorship_invites i
+		LEFT JOIN users u ON u.id = i.mentor_id
+		WHERE i.status='active' AND (i.expires_at IS NULL OR i.expires_at > now())
+		  AND (i.invitee_id=$1 OR i.invitee_email=(SELECT email FROM users WHERE id=$1))`+lq.Where(&args)+lq.OrderLimit(&args), args...)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	defer rows.Close()
+	type Item struct {
+		ID        int64      `json:"id"`
+		MentorID  int64      `json:"mentorId"`
+		Mentor    string     `json:"mentor"`
+		Message   *string    `json:"message,omitempty"`
+		ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+		CreatedAt time.Time  `json:"createdAt"`
+	}
+	items := []Item{}
+	for rows.Next() {
+		var it Item
+		if err := rows.Scan(&it.ID, &it.MentorID, &it.Mentor, &it.Message, &it.ExpiresAt, &it.CreatedAt); err == nil {
+			items = append(items, it)
+		}
+	}
+	items, next := web.Page(w, r, lq, items, func(it Item, sort string) (any, int64) {
+		if sort == "mentor" {
+			return it.Mentor, it.ID
+		}
+		return it.CreatedAt, it.ID
+	})
+	web.JSON(w, 200, map[string]any{"items": items, "nextCursor": next})
+}
+
+// PreviewInvite is public so a sign-up page can show who is inviting before
+// the visitor has an account. It only reveals the mentor and the message.
+func (s *Service) PreviewInvite(w http.ResponseWriter, r *http.Request) {
+	code := chi.URLParam(r, "code")
+	var out struct {
+		MentorID  int64      `json:"mentorId"`
+		Mentor    string     `json:"mentor"`
+		Kind      string     `json:"kind"`
+		Message   *string    `json:"message,omitempty"`
+		ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+	}
+	err := s.db.QueryRow(r.Context(), `
+		SELECT i.mentor_id, COALESCE(u.first_name,'')||' '||COALESCE(u.last_name,''), i.kind, i.message, i.expires_at
+		FROM mentorship_invites i
+		LEFT JOIN users u ON u.id = i.mentor_id
+		WHERE i.code=$1 AND i.status='active' AND i.uses < i.max_uses
+		  AND (i.expires_at IS NULL OR i.expires_at > now())
+	`, code).Scan(&out.MentorID, &out.Mentor, &out.Kind, &out.Message, &out.ExpiresAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			http.Error(w, "not found", 404)
+			return
+		}
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	web.JSON(w, 200, out)
+}
+
+func (s *Service) AcceptInvite(w http.ResponseWriter, r *http.Request) {
+	id, err := web.ParamInt64(r, "id")
+	if err != nil {
+		http.Error(w, "bad id", 400)
+		return
+	}
+	s.acceptInvite(w, r, `id=$1`, id)
+}
+
+func (s *Service) AcceptInviteCode(w http.ResponseWriter, r *http.Request) {
+	s.acceptInvite(w, r, `code=$1`, chi.URLParam(r, "code"))
+}
+
+func (s *Service) acceptInvite(w http.ResponseWriter, r *http.Request, where string, key any) {
+	uid := auth.UserID(r)
+	tx, err := s.db.Begin(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	defer tx.Rollback(r.Context())
+
+	rd, err := redeem(r.Context(), tx, where, key, uid)
+	if err != nil {
+		http.Error(w, err.Error(), inviteErrorCode(err))
+		return
+	}
+	if err := tx.Commit(r.Context()); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	s.postStarted(r.Context(), uid, rd)
+	web.JSON(w, 200, map[string]any{"ok": true, "mentorshipId": rd.mentorshipID, "conversationId": rd.convID})
+}
+
+// postStarted opens the conversation of a mentorship started from an invite
+// with the same card an approved request gets.
+func (s *Service) postStarted(ctx context.Context, studentID int64, rd redemption) {
+	s.postSystem(ctx, studentID, rd.mentorID, studentID, chat.EventMentorshipApproved, "Mentorship started",
+		map[string]any{"mentorshipId": rd.mentorshipID, "inviteId": rd.inviteID})
+}
+
+func (s *Service) DeclineInvite(w http.ResponseWriter, r *http.Request) {
+	uid := auth.UserID(r)
+	id, err := web.ParamInt64(r, "id")
+	if err != nil {
+		http.Error(w, "bad id", 400)
+		return
+	}
+	// Codes are shared, so only personal invites can be declined.
+	res, err := s.db.Exec(r.Context(), `
+		UPDATE mentorship_invites SET status='declined'
+		WHERE id=$1 AND status='active' AND kind IN ('user','email')
+		  AND (invitee_id=$2 OR invitee_email=(SELECT email FROM users WHERE id=$2))
+	`, id, uid)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if res.RowsAffected() == 0 {
+		http.Error(w, "not found or bad state", 409)
+		return
+	}
+	web.JSON(w, 200, map[string]any{"ok": true})
+}
+
+// RegisterWithInvite creates a student account and redeems an invite code in
+// one transaction, so a failed redemption does not leave a stray account.
+func (s *Service) RegisterWithInvite(w http.ResponseWriter, r *http.Request) {
+	var in struct {
+		Code      string `json:"code"`
+		Email     string `json:"email"`
+		Password  string `json:"password"`
+		FirstName string `json:"firstName"`
+		LastName  string `json:"lastName"`
+	}
+	if err := web.DecodeJSON(r, &in); err != nil || in.Code == "" || in.Email == "" || in.Password == "" {
+		http.Error(w, "bad input", 400)
+		return
+	}
+	tx, err := s.db.Begin(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	defer tx.Rollback(r.Context())
+
+	uid, err := auth.CreateUser(r.Context(), tx, in.Email, in.Password, in.FirstName, in.LastName)
+	if err != nil {
+		http.Error(w, "email exists?", 409)
+		return
+	}
+	rd, err := redeem(r.Context(), tx, `code=$1`, in.Code, uid)
+	if err != nil {
+		http.Error(w, err.Error(), inviteErrorCode(err))
+		return
+	}
+	if err := tx.Commit(r.Context()); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	s.postStarted(r.Context(), uid, rd)
+	tok, err := s.auth.IssueToken(uid)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	web.JSON(w, 200, map[string]any{
+		"accessToken":    tok,
+		"user":           map[string]any{"id": uid, "email": strings.ToLower(in.Email), "firstName": in.FirstName, "lastName": in.LastName},
+		"mentorshipId":   rd.mentorshipID,
+		"conversationId": rd.convID,
+	})
+}
+
+// redemption is what redeem started.
+type redemption struct {
+	inviteID, mentorID, mentorshipID, convID int64
+}
+
+// redeem locks the invite matched by where/key, checks studentID may use it
+// and activates the mentorship. The caller owns tx and commits it.
+func redeem(ctx context.Context, tx pgx.Tx, where string, key any, studentID int64) (redemption, error) {
+	var (
+		inviteID, mentorID int64
+		kind, status       string
+		inviteeID          *int64
+		inviteeEmail       *string
+		maxUses, uses      int
+		expiresAt          *time.Time
+	)
+	err := tx.QueryRow(ctx, `
+		SELECT id, mentor_id, kind, invitee_id, invitee_email, max_uses, uses, expires_at, status
+		FROM mentorship_invites WHERE `+where+` FOR UPDATE
+	`, key).Scan(&inviteID, &mentorID, &kind, &inviteeID, &inviteeEmail, &maxUses, &uses, &expiresAt, &status)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return redemption{}, errInviteNotFound
+	}
+	if err != nil {
+		return redemption{}, err
+	}
+	if status != "active" || uses >= maxUses || (expiresAt != nil && !expiresAt.After(time.Now())) {
+		return redemption{}, errInviteClosed
+	}
+	if studentID == mentorID {
+		return redemption{}, errInviteOwn
+	}
+	switch kind {
+	case "user":
+		if inviteeID == nil || *inviteeID != studentID {
+			return redemption{}, errInviteNotYours
+		}
+	case "email":
+		var email string
+		if err := tx.QueryRow(ctx, `SELECT email FROM users WHERE id=$1`, studentID).Scan(&email); err != nil {
+			return redemption{}, err
+		}
+		if inviteeEmail == nil || !strings.EqualFold(*inviteeEmail, email) {
+			return redemption{}, errInviteNotYours
+		}
+	}
+
+	var active, redeemed bool
+	if err := tx.QueryRow(ctx, `
+		SELECT EXISTS(SELECT 1 FROM mentorships WHERE student_id=$1 AND mentor_id=$2 AND status='active'),
+		       EXISTS(SELECT 1 FROM invite_redemptions WHERE invite_id=$3 AND student_id=$1)
+	`, studentID, mentorID, inviteID).Scan(&active, &redeemed); err != nil {
+		return redemption{}, err
+	}
+	if active {
+		return redemption{}, errAlreadyActive
+	}
+	if redeemed {
+		return redemption{}, errInviteUsed
+	}
+
+	mentorshipID, convID, err := activate(ctx, tx, studentID, mentorID)
+	if err != nil {
+		return redemption{}, err
+	}
+	if _, err := tx.Exec(ctx, `
+		INSERT INTO invite_redemptions(invite_id, student_id, mentorship_id) VALUES($1,$2,$3)
+	`, inviteID, studentID, mentorshipID); err != nil {
+		return redemption{}, err
+	}
+	if _, err := tx.Exec(ctx, `
+		UPDATE mentorship_invites
+		SET uses = uses + 1,
+		    status = CASE WHEN uses + 1 >= max_uses THEN 'used' ELSE status END
+		WHERE id=$1
+	`, inviteID); err != nil {
+		return redemption{}, err
+	}
+	if _, err := tx.Exec(ctx, `
+		INSERT INTO user_roles(user_id, role) VALUES($1,'student')
+		ON CONFLICT (user_id, role) DO NOTHING
+	`, studentID); err != nil {
+		return redemption{}, err
+	}
+	// A request the student sent before the invite is now moot.
+	if _, err := tx.Exec(ctx, `
+		UPDATE mentorship_requests SET status='approved', decided_at=now()
+		WHERE student_id=$1 AND mentor_id=$2 AND status='pending'
+	`, studentID, mentorID); err != nil {
+		return redemption{}, err
+	}
+	if _, err := notify.Create(ctx, tx, mentorID, "mentorship.invite.accepted", map[string]any{
+		"inviteId": inviteID, "studentId": studentID, "mentorshipId": mentorshipID,
+	}); err != nil {
+		return redemption{}, err
+	}
+	return redemption{inviteID, mentorID, mentorshipID, convID}, nil
+}
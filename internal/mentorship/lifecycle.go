@@ -0,0 +1,153 @@
+package mentorship
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+
+	"upskill/internal/auth"
+	"upskill/internal/chat"
+	"upskill/internal/notify"
+	"upskill/internal/web"
+)
+
+// Either party can pause an active mentorship, resume a paused one, or end
+// it for good. The other party is notified and the change shows up as a
+// system message in their conversation. Ending it also cuts the mentor off
+// from the live events of plans the student shared with them.
+
+type transition struct {
+	from   []string
+	to     string
+	kind   string // notification kind and chat event type
+	body   string // fallback text of the system message
+	ending bool
+}
+
+var (
+	pauseTransition  = transition{[]string{"active"}, "paused", chat.EventMentorshipPaused, "Mentorship paused", false}
+	resumeTransition = transition{[]string{"paused"}, "active", chat.EventMentorshipResumed, "Mentorship resumed", false}
+	endTransition    = transition{[]string{"active", "paused"}, "ended", chat.EventMentorshipEnded, "Mentorship ended", true}
+)
+
+func (s *Service) Pause(w http.ResponseWriter, r *http.Request) {
+	s.transition(w, r, pauseTransition)
+}
+
+func (s *Service) Resume(w http.ResponseWriter, r *http.Request) {
+	s.transition(w, r, resumeTransition)
+}
+
+func (s *Service) End(w http.ResponseWriter, r *http.Request) {
+	s.transition(w, r, endTransition)
+}
+
+func (s *Service) transition(w http.ResponseWriter, r *http.Request, t transition) {
+	uid := auth.UserID(r)
+	m, ok := s.partyMentorship(w, r)
+	if !ok {
+		return
+	}
+	var in struct {
+		Reason string `json:"reason"`
+	}
+	if r.ContentLength != 0 {
+		if err := web.DecodeJSON(r, &in); err != nil || len(in.Reason) > 500 {
+			http.Error(w, "bad input", 400)
+			return
+		}
+	}
+
+	tx, err := s.db.Begin(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	defer tx.Rollback(r.Context())
+
+	res, err := tx.Exec(r.Context(), `
+		UPDATE mentorships SET status=$2, ended_at=CASE WHEN $3 THEN now() ELSE ended_at END
+		WHERE id=$1 AND status = ANY($4)
+	`, m.ID, t.to, t.ending, t.from)
+	if err != nil {
+		// resuming while the pair already has another active mentorship
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			http.Error(w, "already active", 409)
+			return
+		}
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if res.RowsAffected() == 0 {
+		http.Error(w, "bad state", 409)
+		return
+	}
+	var revoked []int64
+	if t.ending {
+		if revoked, err = unsharedPlans(r.Context(), tx, m.StudentID, m.MentorID); err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+	}
+
+	payload := map[string]any{"mentorshipId": m.ID, "by": uid}
+	if in.Reason != "" {
+		payload["reason"] = in.Reason
+	}
+	peer := m.StudentID
+	if uid == m.StudentID {
+		peer = m.MentorID
+	}
+	if _, err := notify.Create(r.Context(), tx, peer, t.kind, payload); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if err := tx.Commit(r.Context()); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	s.revokePlans(m.MentorID, revoked)
+	s.postSystem(r.Context(), m.StudentID, m.MentorID, uid, t.kind, t.body, payload)
+	web.JSON(w, 200, map[string]any{"ok": true, "status": t.to})
+}
+
+// unsharedPlans lists the student's plans shared with mentorID that the
+// mentor can no longer read, because no active or paused mentorship is left
+// between the two. Call it after ending the mentorship, inside the same tx.
+func unsharedPlans(ctx context.Context, tx pgx.Tx, studentID, mentorID int64) ([]int64, error) {
+	rows, err := tx.Query(ctx, `
+		SELECT ps.plan_id
+		FROM plan_shares ps
+		JOIN plans p ON p.id = ps.plan_id
+		WHERE ps.mentor_id=$2 AND p.user_id=$1
+		  AND NOT EXISTS (SELECT 1 FROM mentorships m WHERE m.student_id=$1 AND m.mentor_id=$2 AND m.status IN ('active','paused'))
+	`, studentID, mentorID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// revokePlans drops the mentor's realtime subscriptions to plans they lost
+// access to.
+func (s *Service) revokePlans(mentorID int64, planIDs []int64) {
+	if s.chat == nil {
+		return
+	}
+	for _, pid := range planIDs {
+		s.chat.Revoke("plan", pid, mentorID)
+	}
+}
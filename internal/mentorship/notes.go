@@ -0,0 +1,257 @@
+package mentorship
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	"upskill/internal/auth"
+	"upskill/internal/web"
+)
+
+// Mentor notes are private: every query is scoped to the calling mentor, so
+// a note is never visible to the mentee or to other mentors.
+
+const (
+	maxNoteTags   = 20
+	maxNoteTagLen = 32
+)
+
+type note struct {
+	ID        int64     `json:"id"`
+	StudentID int64     `json:"studentId"`
+	Body      string    `json:"body"`
+	Tags      []string  `json:"tags"`
+	Pinned    bool      `json:"pinned"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+const noteCols = `id, student_id, body, tags, pinned, created_at, updated_at`
+
+func scanNote(row pgx.Row) (note, error) {
+	var n note
+	err := row.Scan(&n.ID, &n.StudentID, &n.Body, &n.Tags, &n.Pinned, &n.CreatedAt, &n.UpdatedAt)
+	return n, err
+}
+
+// menteeParam reads {studentId} and checks the caller mentors or mentored
+// that student, writing the error response itself when it returns false.
+func (s *Service) menteeParam(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	mid := auth.UserID(r)
+	sid, err := web.ParamInt64(r, "studentId")
+	if err != nil {
+		http.Error(w, "bad studentId", 400)
+		return 0, false
+	}
+	ok, err := s.hadMentorship(r.Context(), sid, mid)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return 0, false
+	}
+	if !ok {
+		http.Error(w, "not your mentee", 403)
+		return 0, false
+	}
+	return sid, true
+}
+
+func (s *Service) hadMentorship(ctx context.Context, studentID, mentorID int64) (bool, error) {
+	var ok bool
+	err := s.db.QueryRow(ctx, `
+		SELECT EXISTS(SELECT 1 FROM mentorships WHERE student_id=$1 AND mentor_id=$2)
+	`, studentID, mentorID).Scan(&ok)
+	return ok, err
+}
+
+var notesSpec = web.ListSpec{
+	IDColumn: "id",
+	Sorts: map[string]web.SortKey{
+		"createdAt": {Column: "created_at", Type: web.SortTime},
+		"updatedAt": {Column: "updated_at", Type: web.SortTime},
+	},
+	DefaultSort:  "-updatedAt",
+	DateColumn:   "created_at",
+	SearchColumn: "body",
+	DefaultLimit: 50,
+	MaxLimit:     200,
+}
+
+// ListNotes lists the caller's notes on a mentee. Besides the list query
+// format it takes ?tag=<tag> and ?pinned=true|false.
+func (s *Service) ListNotes(w http.ResponseWriter, r *http.Request) {
+	mid := auth.UserID(r)
+	sid, ok := s.menteeParam(w, r)
+	if !ok {
+		return
+	}
+	lq, err := web.ParseList(r, notesSpec)
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	tag := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("tag")))
+	args := web.Args{mid, sid, tag}
+	q := `
+		SELECT ` + noteCols + `
+		FROM mentor_notes
+		WHERE mentor_id=$1 AND student_id=$2 AND ($3 = '' OR $3 = ANY(tags))`
+	switch r.URL.Query().Get("pinned") {
+	case "":
+	case "true":
+		q += ` AND pinned`
+	case "false":
+		q += ` AND NOT pinned`
+	default:
+		http.Error(w, "pinned must be true or false", 400)
+		return
+	}
+	rows, err := s.db.Query(r.Context(), q+lq.Where(&args)+lq.OrderLimit(&args), args...)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	defer rows.Close()
+	items := []note{}
+	for rows.Next() {
+		if n, err := scanNote(rows); err == nil {
+			items = append(items, n)
+		}
+	}
+	items, next := web.Page(w, r, lq, items, func(n note, sort string) (any, int64) {
+		if sort == "createdAt" {
+			return n.CreatedAt, n.ID
+		}
+		return n.UpdatedAt, n.ID
+	})
+	web.JSON(w, 200, map[string]any{"items": items, "nextCursor": next})
+}
+
+func (s *Service) CreateNote(w http.ResponseWriter, r *http.Request) {
+	mid := auth.UserID(r)
+	sid, ok := s.menteeParam(w, r)
+	if !ok {
+		return
+	}
+	var in struct {
+		Body   string   `json:"body"`
+		Tags   []string `json:"tags"`
+		Pinned bool     `json:"pinned"`
+	}
+	if err := web.DecodeJSON(r, &in); err != nil || strings.TrimSpace(in.Body) == "" {
+		http.Error(w, "bad input", 400)
+		return
+	}
+	tags, err := normalizeNoteTags(in.Tags)
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	n, err := scanNote(s.db.QueryRow(r.Context(), `
+		INSERT INTO mentor_notes(mentor_id, student_id, body, tags, pinned)
+		VALUES($1,$2,$3,$4,$5)
+		RETURNING `+noteCols, mid, sid, in.Body, tags, in.Pinned))
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	web.JSON(w, 201, n)
+}
+
+func (s *Service) UpdateNote(w http.ResponseWriter, r *http.Request) {
+	mid := auth.UserID(r)
+	sid, ok := s.menteeParam(w, r)
+	if !ok {
+		return
+	}
+	noteID, err := web.ParamInt64(r, "noteId")
+	if err != nil {
+		http.Error(w, "bad noteId", 400)
+		return
+	}
+	var in struct {
+		Body   *string   `json:"body"`
+		Tags   *[]string `json:"tags"`
+		Pinned *bool     `json:"pinned"`
+	}
+	if err := web.DecodeJSON(r, &in); err != nil || (in.Body != nil && strings.TrimSpace(*in.Body) == "") {
+		http.Error(w, "bad input", 400)
+		return
+	}
+	var tags []string
+	if in.Tags != nil {
+		if tags, err = normalizeNoteTags(*in.Tags); err != nil {
+			http.Error(w, err.Error(), 400)
+			return
+		}
+	}
+	n, err := scanNote(s.db.QueryRow(r.Context(), `
+		UPDATE mentor_notes
+		SET body = COALESCE($4, body),
+		    tags = CASE WHEN $5 THEN $6::text[] ELSE tags END,
+		    pinned = COALESCE($7, pinned),
+		    updated_at = now()
+		WHERE id=$1 AND mentor_id=$2 AND student_id=$3
+		RETURNING `+noteCols, noteID, mid, sid, in.Body, in.Tags != nil, tags, in.Pinned))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			http.Error(w, "not found", 404)
+			return
+		}
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	web.JSON(w, 200, n)
+}
+
+func (s *Service) DeleteNote(w http.ResponseWriter, r *http.Request) {
+	mid := auth.UserID(r)
+	sid, err := web.ParamInt64(r, "studentId")
+	if err != nil {
+		http.Error(w, "bad studentId", 400)
+		return
+	}
+	noteID, err := web.ParamInt64(r, "noteId")
+	if err != nil {
+		http.Error(w, "bad noteId", 400)
+		return
+	}
+	res, err := s.db.Exec(r.Context(), `
+		DELETE FROM mentor_notes WHERE id=$1 AND mentor_id=$2 AND student_id=$3
+	`, noteID, mid, sid)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if res.RowsAffected() == 0 {
+		http.Error(w, "not found", 404)
+		return
+	}
+	web.JSON(w, 200, map[string]any{"ok": true})
+}
+
+func normalizeNoteTags(in []string) ([]string, error) {
+	res := make([]string, 0, len(in))
+	seen := map[string]struct{}{}
+	for _, t := range in {
+		t = strings.ToLower(strings.TrimSpace(t))
+		if t == "" {
+			continue
+		}
+		if len(t) > maxNoteTagLen {
+			return nil, errors.New("tag too long")
+		}
+		if _, dup := seen[t]; !dup {
+			seen[t] = struct{}{}
+			res = append(res, t)
+		}
+	}
+	if len(res) > maxNoteTags {
+		return nil, errors.New("too many tags")
+	}
+	return res, nil
+}
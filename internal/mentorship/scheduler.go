@@ -0,0 +1,164 @@
+package mentorship
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"upskill/internal/config"
+	"upskill/internal/notify"
+)
+
+// Scheduler periodically reminds mentors about pending requests and expires
+// requests that stayed pending longer than the configured limit. Every step
+// is a conditional UPDATE, so several app instances can run it side by side.
+type Scheduler struct {
+	db          *pgxpool.Pool
+	interval    time.Duration
+	reminders   []time.Duration
+	expireAfter time.Duration
+}
+
+func NewScheduler(cfg config.Config, db *pgxpool.Pool) *Scheduler {
+	return &Scheduler{
+		db:          db,
+		interval:    cfg.SchedulerInterval,
+		reminders:   cfg.RequestReminders,
+		expireAfter: cfg.RequestExpireAfter,
+	}
+}
+
+func (s *Scheduler) Run(ctx context.Context) {
+	if s.interval <= 0 {
+		log.Printf("mentorship scheduler disabled")
+		return
+	}
+	t := time.NewTicker(s.interval)
+	defer t.Stop()
+	for {
+		s.tick(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+func (s *Scheduler) tick(ctx context.Context) {
+	if s.expireAfter > 0 {
+		if err := s.expire(ctx); err != nil {
+			log.Printf("scheduler: expire requests: %v", err)
+		}
+	}
+	if len(s.reminders) > 0 {
+		if err := s.remind(ctx); err != nil {
+			log.Printf("scheduler: remind mentors: %v", err)
+		}
+	}
+}
+
+func (s *Scheduler) expire(ctx context.Context) error {
+	rows, err := s.db.Query(ctx, `
+		UPDATE mentorship_requests SET status='expired', decided_at=now()
+		WHERE status='pending' AND created_at <= now() - make_interval(secs => $1)
+		RETURNING id, student_id, mentor_id
+	`, s.expireAfter.Seconds())
+	if err != nil {
+		return err
+	}
+	type expired struct{ ID, StudentID, MentorID int64 }
+	var items []expired
+	for rows.Next() {
+		var it expired
+		if err := rows.Scan(&it.ID, &it.StudentID, &it.MentorID); err == nil {
+			items = append(items, it)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, it := range items {
+		payload := map[string]any{"requestId": it.ID, "mentorId": it.MentorID}
+		if _, err := notify.Create(ctx, s.db, it.StudentID, "mentorship.request.expired", payload); err != nil {
+			log.Printf("scheduler: notify student %d: %v", it.StudentID, err)
+		}
+	}
+	return nil
+}
+
+func (s *Scheduler) remind(ctx context.Context) error {
+	secs := make([]float64, len(s.reminders))
+	for i, d := range s.reminders {
+		secs[i] = d.Seconds()
+	}
+	rows, err := s.db.Query(ctx, `
+		SELECT id, student_id, mentor_id, created_at, reminders_sent
+		FROM mentorship_requests
+		WHERE status='pending'
+		  AND reminders_sent < cardinality($1::float8[])
+		  AND created_at + make_interval(secs => ($1::float8[])[reminders_sent+1]) <= now()
+		ORDER BY id
+	`, secs)
+	if err != nil {
+		return err
+	}
+	type due struct {
+		ID, StudentID, MentorID int64
+		CreatedAt               time.Time
+		Sent                    int
+	}
+	var items []due
+	for rows.Next() {
+		var it due
+		if err := rows.Scan(&it.ID, &it.StudentID, &it.MentorID, &it.CreatedAt, &it.Sent); err == nil {
+			items = append(items, it)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, it := range items {
+		if err := s.sendReminder(ctx, it.ID, it.StudentID, it.MentorID, it.CreatedAt, it.Sent); err != nil {
+			log.Printf("scheduler: reminder for request %d: %v", it.ID, err)
+		}
+	}
+	return nil
+}
+
+func (s *Scheduler) sendReminder(ctx context.Context, reqID, studentID, mentorID int64, createdAt time.Time, sent int) error {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	// Guard on the counter we read: another instance may have sent it already.
+	res, err := tx.Exec(ctx, `
+		UPDATE mentorship_requests SET reminders_sent=reminders_sent+1, last_reminded_at=now()
+		WHERE id=$1 AND status='pending' AND reminders_sent=$2
+	`, reqID, sent)
+	if err != nil {
+		return err
+	}
+	if res.RowsAffected() == 0 {
+		return nil
+	}
+	payload := map[string]any{
+		"requestId":    reqID,
+		"studentId":    studentID,
+		"pendingSince": createdAt,
+		"reminder":     sent + 1,
+	}
+	if s.expireAfter > 0 {
+		payload["expiresAt"] = createdAt.Add(s.expireAfter)
+	}
+	if _, err := notify.Create(ctx, tx, mentorID, "mentorship.request.reminder", payload); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
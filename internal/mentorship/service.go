@@ -1,7 +1,9 @@
 package mentorship
 
 import (
+	"context"
 	"errors"
+	"log"
 	"net/http"
 	"time"
 
@@ -10,12 +12,19 @@ import (
 	"github.com/jackc/pgx/v5/pgxpool"
 
 	"upskill/internal/auth"
+	"upskill/internal/chat"
 	"upskill/internal/web"
 )
 
-type Service struct{ db *pgxpool.Pool }
+type Service struct {
+	db   *pgxpool.Pool
+	auth *auth.Service
+	chat *chat.Service
+}
 
-func NewService(db *pgxpool.Pool) *Service { return &Service{db: db} }
+func NewService(db *pgxpool.Pool, authSvc *auth.Service, chatSvc *chat.Service) *Service {
+	return &Service{db: db, auth: authSvc, chat: chatSvc}
+}
 
 func (s *Service) Routes() http.Handler {
 	r := chi.NewRouter()
@@ -55,13 +64,38 @@ func (s *Service) RequestCreate(w http.ResponseWriter, r *http.Request) {
 	web.JSON(w, 201, map[string]any{"requestId": id, "status": "pending"})
 }
 
+var requestStatuses = []string{"pending", "approved", "declined", "cancelled", "expired"}
+
+var myRequestsSpec = web.ListSpec{
+	IDColumn: "mr.id",
+	Sorts: map[string]web.SortKey{
+		"createdAt": {Column: "mr.created_at", Type: web.SortTime},
+		"mentor":    {Column: "COALESCE(u.first_name,'')||' '||COALESCE(u.last_name,'')", Type: web.SortText},
+	},
+	DefaultSort:  "-createdAt",
+	StatusColumn: "mr.status",
+	Statuses:     requestStatuses,
+	DateColumn:   "mr.created_at",
+	SearchColumn: "COALESCE(u.first_name,'')||' '||COALESCE(u.last_name,'')",
+	DefaultLimit: 50,
+	MaxLimit:     200,
+}
+
 func (s *Service) MyRequests(w http.ResponseWriter, r *http.Request) {
 	uid := auth.UserID(r)
-	rows, err := s.db.Query(r.Context(), `
-		SELECT id, mentor_id, message, status, created_at, decided_at
-		FROM mentorship_requests WHERE student_id=$1
-		ORDER BY created_at DESC
-	`, uid)
+	lq, err := web.ParseList(r, myRequestsSpec)
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	args := web.Args{uid}
+	q := `
+		SELECT mr.id, mr.mentor_id, COALESCE(u.first_name,'')||' '||COALESCE(u.last_name,'') as mentor_name,
+		       COALESCE(mr.message,''), mr.status, mr.created_at, mr.decided_at
+		FROM mentorship_requests mr
+		LEFT JOIN users u ON u.id = mr.mentor_id
+		WHERE mr.student_id=$1` + lq.Where(&args) + lq.OrderLimit(&args)
+	rows, err := s.db.Query(r.Context(), q, args...)
 	if err != nil {
 		http.Error(w, err.Error(), 500)
 		return
@@ -70,38 +104,58 @@ func (s *Service) MyRequests(w http.ResponseWriter, r *http.Request) {
 	type Req struct {
 		ID        int64      `json:"id"`
 		MentorID  int64      `json:"mentorId"`
+		Mentor    string     `json:"mentor"`
 		Message   string     `json:"message"`
 		Status    string     `json:"status"`
 		CreatedAt time.Time  `json:"createdAt"`
 		DecidedAt *time.Time `json:"decidedAt,omitempty"`
 	}
-	var items []Req
+	items := []Req{}
 	for rows.Next() {
 		var it Req
-		var decidedAt *time.Time
-		if err := rows.Scan(&it.ID, &it.MentorID, &it.Message, &it.Status, &it.CreatedAt, &decidedAt); err == nil {
-			it.DecidedAt = decidedAt
+		if err := rows.Scan(&it.ID, &it.MentorID, &it.Mentor, &it.Message, &it.Status, &it.CreatedAt, &it.DecidedAt); err == nil {
 			items = append(items, it)
 		}
 	}
-	web.JSON(w, 200, map[string]any{"items": items})
+	items, next := web.Page(w, r, lq, items, func(it Req, sort string) (any, int64) {
+		if sort == "mentor" {
+			return it.Mentor, it.ID
+		}
+		return it.CreatedAt, it.ID
+	})
+	web.JSON(w, 200, map[string]any{"items": items, "nextCursor": next})
+}
+
+var mentorRequestsSpec = web.ListSpec{
+	IDColumn: "mr.id",
+	Sorts: map[string]web.SortKey{
+		"createdAt": {Column: "mr.created_at", Type: web.SortTime},
+		"student":   {Column: "COALESCE(u.first_name,'')||' '||COALESCE(u.last_name,'')", Type: web.SortText},
+	},
+	DefaultSort:  "-createdAt",
+	StatusColumn: "mr.status",
+	Statuses:     requestStatuses,
+	DateColumn:   "mr.created_at",
+	SearchColumn: "COALESCE(u.first_name,'')||' '||COALESCE(u.last_name,'')",
+	DefaultLimit: 50,
+	MaxLimit:     200,
 }
 
 func (s *Service) MentorRequests(w http.ResponseWriter, r *http.Request) {
 	mid := auth.UserID(r)
-	status := web.QueryString(r, "status", "")
+	lq, err := web.ParseList(r, mentorRequestsSpec)
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	args := web.Args{mid}
 	q := `
 		SELECT mr.id, mr.student_id, COALESCE(u.first_name,'')||' '||COALESCE(u.last_name,'') as student_name,
-		       mr.message, mr.status, mr.created_at
+		       COALESCE(mr.message,''), mr.status, mr.created_at
 		FROM mentorship_requests mr
 		LEFT JOIN users u ON u.id = mr.student_id
-		WHERE mr.mentor_id=$1
-	`
-	if status != "" {
-		q += " AND mr.status = '" + status + "'"
-	}
-	q += " ORDER BY mr.created_at DESC"
-	rows, err := s.db.Query(r.Context(), q, mid)
+		WHERE mr.mentor_id=$1` + lq.Where(&args) + lq.OrderLimit(&args)
+	rows, err := s.db.Query(r.Context(), q, args...)
 	if err != nil {
 		http.Error(w, err.Error(), 500)
 		return
@@ -115,14 +169,20 @@ func (s *Service) MentorRequests(w http.ResponseWriter, r *http.Request) {
 		Status    string    `json:"status"`
 		CreatedAt time.Time `json:"createdAt"`
 	}
-	var items []Req
+	items := []Req{}
 	for rows.Next() {
 		var it Req
 		if err := rows.Scan(&it.ID, &it.StudentID, &it.Student, &it.Message, &it.Status, &it.CreatedAt); err == nil {
 			items = append(items, it)
 		}
 	}
-	web.JSON(w, 200, map[string]any{"items": items})
+	items, next := web.Page(w, r, lq, items, func(it Req, sort string) (any, int64) {
+		if sort == "student" {
+			return it.Student, it.ID
+		}
+		return it.CreatedAt, it.ID
+	})
+	web.JSON(w, 200, map[string]any{"items": items, "nextCursor": next})
 }
 
 func (s *Service) Approve(w http.ResponseWriter, r *http.Request) {
@@ -164,29 +224,59 @@ func (s *Service) Approve(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if _, err := tx.Exec(r.Context(), `
-		INSERT INTO mentorships(student_id, mentor_id, status)
-		VALUES($1,$2,'active')
-		ON CONFLICT (student_id, mentor_id) WHERE status='active' DO NOTHING
-	`, studentID, mid); err != nil {
+	mentorshipID, _, err := activate(r.Context(), tx, studentID, mid)
+	if err != nil {
 		http.Error(w, err.Error(), 500)
 		return
 	}
 
-	if _, err := tx.Exec(r.Context(), `
-		INSERT INTO conversations(student_id, mentor_id)
-		VALUES($1,$2)
-		ON CONFLICT (student_id, mentor_id) DO NOTHING
-	`, studentID, mid); err != nil {
+	if err := tx.Commit(r.Context()); err != nil {
 		http.Error(w, err.Error(), 500)
 		return
 	}
+	s.postSystem(r.Context(), studentID, mid, mid, chat.EventMentorshipApproved, "Mentorship started",
+		map[string]any{"mentorshipId": mentorshipID, "requestId": reqID})
+	web.JSON(w, 200, map[string]any{"ok": true})
+}
 
-	if err := tx.Commit(r.Context()); err != nil {
-		http.Error(w, err.Error(), 500)
+// postSystem posts a timeline entry into the pair's conversation. The change
+// it describes has already committed, so a failure is only logged.
+func (s *Service) postSystem(ctx context.Context, studentID, mentorID, actorID int64, event, body string, metadata map[string]any) {
+	if s.chat == nil {
 		return
 	}
-	web.JSON(w, 200, map[string]any{"ok": true})
+	if _, err := s.chat.PostSystem(ctx, studentID, mentorID, actorID, event, body, metadata); err != nil {
+		log.Printf("mentorship: system message %s: %v", event, err)
+	}
+}
+
+// activate starts an active mentorship for the pair and makes sure they have
+// a conversation. An already active mentorship is reused.
+func activate(ctx context.Context, tx pgx.Tx, studentID, mentorID int64) (int64, int64, error) {
+	var mentorshipID, convID int64
+	err := tx.QueryRow(ctx, `
+		INSERT INTO mentorships(student_id, mentor_id, status)
+		VALUES($1,$2,'active')
+		ON CONFLICT (student_id, mentor_id) WHERE status='active' DO NOTHING
+		RETURNING id
+	`, studentID, mentorID).Scan(&mentorshipID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		err = tx.QueryRow(ctx, `
+			SELECT id FROM mentorships WHERE student_id=$1 AND mentor_id=$2 AND status='active'
+		`, studentID, mentorID).Scan(&mentorshipID)
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+	if err := tx.QueryRow(ctx, `
+		INSERT INTO conversations(student_id, mentor_id)
+		VALUES($1,$2)
+		ON CONFLICT (student_id, mentor_id) DO UPDATE SET student_id=EXCLUDED.student_id
+		RETURNING id
+	`, studentID, mentorID).Scan(&convID); err != nil {
+		return 0, 0, err
+	}
+	return mentorshipID, convID, nil
 }
 
 func (s *Service) Decline(w http.ResponseWriter, r *http.Request) {
@@ -212,19 +302,44 @@ func (s *Service) Decline(w http.ResponseWriter, r *http.Request) {
 	web.JSON(w, 200, map[string]any{"ok": true})
 }
 
+var mentorshipStatuses = []string{"active", "paused", "ended"}
+
+var menteesSpec = web.ListSpec{
+	IDColumn: "m.id",
+	Sorts: map[string]web.SortKey{
+		"since": {Column: "m.created_at", Type: web.SortTime},
+		"name":  {Column: "COALESCE(u.first_name,'')||' '||COALESCE(u.last_name,'')", Type: web.SortText},
+	},
+	DefaultSort:     "-since",
+	StatusColumn:    "m.status",
+	Statuses:        mentorshipStatuses,
+	DefaultStatuses: []string{"active"},
+	DateColumn:      "m.created_at",
+	SearchColumn:    "COALESCE(u.first_name,'')||' '||COALESCE(u.last_name,'')",
+	DefaultLimit:    50,
+	MaxLimit:        200,
+}
+
 func (s *Service) ListMentees(w http.ResponseWriter, r *http.Request) {
 	mid := auth.UserID(r)
-	rows, err := s.db.Query(r.Context(), `
-		SELECT m.student_id,
+	lq, err := web.ParseList(r, menteesSpec)
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	args := web.Args{mid}
+	q := `
+		SELECT m.id, m.student_id,
 		       COALESCE(u.first_name,'')||' '||COALESCE(u.last_name,'') as name,
 		       COALESCE(c.id,0) as conversation_id,
-		       m.created_at
+		       m.status, m.created_at,
+		       (SELECT MAX(n.updated_at) FROM mentor_notes n
+		        WHERE n.mentor_id = m.mentor_id AND n.student_id = m.student_id) as last_note_at
 		FROM mentorships m
 		LEFT JOIN users u ON u.id = m.student_id
 		LEFT JOIN conversations c ON c.student_id = m.student_id AND c.mentor_id = m.mentor_id
-		WHERE m.mentor_id=$1 AND m.status='active'
-		ORDER BY m.created_at DESC
-	`, mid)
+		WHERE m.mentor_id=$1` + lq.Where(&args) + lq.OrderLimit(&args)
+	rows, err := s.db.Query(r.Context(), q, args...)
 	if err != nil {
 		http.Error(w, err.Error(), 500)
 		return
@@ -232,34 +347,64 @@ func (s *Service) ListMentees(w http.ResponseWriter, r *http.Request) {
 	defer rows.Close()
 
 	type Item struct {
-		StudentID      int64     `json:"studentId"`
-		Name           string    `json:"name"`
-		ConversationID int64     `json:"conversationId"`
-		Since          time.Time `json:"since"`
+		MentorshipID   int64      `json:"mentorshipId"`
+		StudentID      int64      `json:"studentId"`
+		Name           string     `json:"name"`
+		ConversationID int64      `json:"conversationId"`
+		Status         string     `json:"status"`
+		Since          time.Time  `json:"since"`
+		LastNoteAt     *time.Time `json:"lastNoteAt,omitempty"`
 	}
-	var items []Item
+	items := []Item{}
 	for rows.Next() {
 		var it Item
-		if err := rows.Scan(&it.StudentID, &it.Name, &it.ConversationID, &it.Since); err == nil {
+		if err := rows.Scan(&it.MentorshipID, &it.StudentID, &it.Name, &it.ConversationID, &it.Status, &it.Since, &it.LastNoteAt); err == nil {
 			items = append(items, it)
 		}
 	}
-	web.JSON(w, 200, map[string]any{"items": items})
+	items, next := web.Page(w, r, lq, items, func(it Item, sort string) (any, int64) {
+		if sort == "name" {
+			return it.Name, it.MentorshipID
+		}
+		return it.Since, it.MentorshipID
+	})
+	web.JSON(w, 200, map[string]any{"items": items, "nextCursor": next})
+}
+
+var mentorsSpec = web.ListSpec{
+	IDColumn: "m.id",
+	Sorts: map[string]web.SortKey{
+		"since": {Column: "m.created_at", Type: web.SortTime},
+		"name":  {Column: "COALESCE(u.first_name,'')||' '||COALESCE(u.last_name,'')", Type: web.SortText},
+	},
+	DefaultSort:     "-since",
+	StatusColumn:    "m.status",
+	Statuses:        mentorshipStatuses,
+	DefaultStatuses: []string{"active"},
+	DateColumn:      "m.created_at",
+	SearchColumn:    "COALESCE(u.first_name,'')||' '||COALESCE(u.last_name,'')",
+	DefaultLimit:    50,
+	MaxLimit:        200,
 }
 
 func (s *Service) ListMentors(w http.ResponseWriter, r *http.Request) {
 	sid := auth.UserID(r)
-	rows, err := s.db.Query(r.Context(), `
-		SELECT m.mentor_id,
+	lq, err := web.ParseList(r, mentorsSpec)
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	args := web.Args{sid}
+	q := `
+		SELECT m.id, m.mentor_id,
 		       COALESCE(u.first_name,'')||' '||COALESCE(u.last_name,'') as name,
 		       COALESCE(c.id,0) as conversation_id,
-		       m.created_at
+		       m.status, m.created_at
 		FROM mentorships m
 		LEFT JOIN users u ON u.id = m.mentor_id
 		LEFT JOIN conversations c ON c.student_id = m.student_id AND c.mentor_id = m.mentor_id
-		WHERE m.student_id=$1 AND m.status='active'
-		ORDER BY m.created_at DESC
-	`, sid)
+		WHERE m.student_id=$1` + lq.Where(&args) + lq.OrderLimit(&args)
+	rows, err := s.db.Query(r.Context(), q, args...)
 	if err != nil {
 		http.Error(w, err.Error(), 500)
 		return
@@ -267,17 +412,25 @@ func (s *Service) ListMentors(w http.ResponseWriter, r *http.Request) {
 	defer rows.Close()
 
 	type Item struct {
+		MentorshipID   int64     `json:"mentorshipId"`
 		MentorID       int64     `json:"mentorId"`
 		Name           string    `json:"name"`
 		ConversationID int64     `json:"conversationId"`
+		Status         string    `json:"status"`
 		Since          time.Time `json:"since"`
 	}
-	var items []Item
+	items := []Item{}
 	for rows.Next() {
 		var it Item
-		if err := rows.Scan(&it.MentorID, &it.Name, &it.ConversationID, &it.Since); err == nil {
+		if err := rows.Scan(&it.MentorshipID, &it.MentorID, &it.Name, &it.ConversationID, &it.Status, &it.Since); err == nil {
 			items = append(items, it)
 		}
 	}
-	web.JSON(w, 200, map[string]any{"items": items})
+	items, next := web.Page(w, r, lq, items, func(it Item, sort string) (any, int64) {
+		if sort == "name" {
+			return it.Name, it.MentorshipID
+		}
+		return it.Since, it.MentorshipID
+	})
+	web.JSON(w, 200, map[string]any{"items": items, "nextCursor": next})
 }
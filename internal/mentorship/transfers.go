@@ -0,0 +1,383 @@
+package mentorship
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	"upskill/internal/auth"
+	"upskill/internal/chat"
+	"upskill/internal/notify"
+	"upskill/internal/web"
+)
+
+// A transfer moves a mentee to another mentor. The current mentor or an admin
+// proposes it; nothing changes until the target mentor accepts, at which point
+// the old mentorship ends and a new one starts in the same transaction.
+// The new conversation opens with a handover message carrying the summary
+// given with the request or, without one, the previous mentor's latest note
+// on the mentee tagged "summary".
+
+type transfer struct {
+	ID              int64      `json:"id"`
+	MentorshipID    int64      `json:"mentorshipId"`
+	StudentID       int64      `json:"studentId"`
+	Student         string     `json:"student"`
+	FromMentorID    int64      `json:"fromMentorId"`
+	ToMentorID      int64      `json:"toMentorId"`
+	InitiatedBy     int64      `json:"initiatedBy"`
+	Summary         *string    `json:"summary,omitempty"`
+	CarryPlans      bool       `json:"carryPlans"`
+	CarryGoals      bool       `json:"carryGoals"`
+	Status          string     `json:"status"`
+	NewMentorshipID *int64     `json:"newMentorshipId,omitempty"`
+	CreatedAt       time.Time  `json:"createdAt"`
+	DecidedAt       *time.Time `json:"decidedAt,omitempty"`
+}
+
+const transferCols = `t.id, t.mentorship_id, t.student_id,
+	COALESCE(u.first_name,'')||' '||COALESCE(u.last_name,''),
+	t.from_mentor_id, t.to_mentor_id, t.initiated_by, t.summary, t.carry_plans, t.carry_goals,
+	t.status, t.new_mentorship_id, t.created_at, t.decided_at`
+
+func scanTransfer(row pgx.Row) (transfer, error) {
+	var t transfer
+	err := row.Scan(&t.ID, &t.MentorshipID, &t.StudentID, &t.Student, &t.FromMentorID, &t.ToMentorID,
+		&t.InitiatedBy, &t.Summary, &t.CarryPlans, &t.CarryGoals, &t.Status, &t.NewMentorshipID,
+		&t.CreatedAt, &t.DecidedAt)
+	return t, err
+}
+
+func (s *Service) hasRole(ctx context.Context, uid int64, role string) (bool, error) {
+	var ok bool
+	err := s.db.QueryRow(ctx, `
+		SELECT EXISTS(SELECT 1 FROM user_roles WHERE user_id=$1 AND role=$2)
+	`, uid, role).Scan(&ok)
+	return ok, err
+}
+
+func (s *Service) RequestTransfer(w http.ResponseWriter, r *http.Request) {
+	uid := auth.UserID(r)
+	id, err := web.ParamInt64(r, "id")
+	if err != nil {
+		http.Error(w, "bad id", 400)
+		return
+	}
+	var in struct {
+		ToMentorID int64  `json:"toMentorId"`
+		Summary    string `json:"summary"`
+		CarryPlans bool   `json:"carryPlans"`
+		CarryGoals bool   `json:"carryGoals"`
+	}
+	if err := web.DecodeJSON(r, &in); err != nil || in.ToMentorID <= 0 {
+		http.Error(w, "bad input", 400)
+		return
+	}
+	m, err := s.loadMentorship(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			http.Error(w, "not found", 404)
+			return
+		}
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if uid != m.MentorID {
+		admin, err := s.hasRole(r.Context(), uid, "admin")
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		if !admin {
+			http.Error(w, "forbidden", 403)
+			return
+		}
+	}
+	if !m.open() {
+		http.Error(w, "mentorship is not active", 409)
+		return
+	}
+	if in.ToMentorID == m.MentorID || in.ToMentorID == m.StudentID {
+		http.Error(w, "bad target mentor", 400)
+		return
+	}
+	isMentor, err := s.hasRole(r.Context(), in.ToMentorID, "mentor")
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if !isMentor {
+		http.Error(w, "target is not a mentor", 400)
+		return
+	}
+
+	tx, err := s.db.Begin(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	defer tx.Rollback(r.Context())
+
+	var tid int64
+	if err := tx.QueryRow(r.Context(), `
+		INSERT INTO mentorship_transfers(mentorship_id, student_id, from_mentor_id, to_mentor_id, initiated_by,
+		                                 summary, carry_plans, carry_goals)
+		VALUES($1,$2,$3,$4,$5,NULLIF($6,''),$7,$8)
+		RETURNING id
+	`, m.ID, m.StudentID, m.MentorID, in.ToMentorID, uid, strings.TrimSpace(in.Summary), in.CarryPlans, in.CarryGoals).Scan(&tid); err != nil {
+		http.Error(w, "transfer already pending?", 409)
+		return
+	}
+	payload := map[string]any{"transferId": tid, "mentorshipId": m.ID, "studentId": m.StudentID,
+		"fromMentorId": m.MentorID, "toMentorId": in.ToMentorID}
+	for _, to := range []int64{in.ToMentorID, m.StudentID} {
+		if _, err := notify.Create(r.Context(), tx, to, "mentorship.transfer.requested", payload); err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+	}
+	if err := tx.Commit(r.Context()); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	web.JSON(w, 201, map[string]any{"transferId": tid, "status": "pending"})
+}
+
+var transfersSpec = web.ListSpec{
+	IDColumn: "t.id",
+	Sorts: map[string]web.SortKey{
+		"createdAt": {Column: "t.created_at", Type: web.SortTime},
+	},
+	DefaultSort:  "-createdAt",
+	StatusColumn: "t.status",
+	Statuses:     []string{"pending", "accepted", "declined", "cancelled"},
+	DateColumn:   "t.created_at",
+	SearchColumn: "COALESCE(u.first_name,'')||' '||COALESCE(u.last_name,'')",
+	DefaultLimit: 50,
+	MaxLimit:     200,
+}
+
+// ListTransfers returns transfers the caller is the source, target or
+// initiator of. ?direction=incoming|outgoing narrows the list.
+func (s *Service) ListTransfers(w http.ResponseWriter, r *http.Request) {
+	uid := auth.UserID(r)
+	dir := web.QueryString(r, "direction", "")
+	if dir != "" && dir != "incoming" && dir != "outgoing" {
+		http.Error(w, "bad direction", 400)
+		return
+	}
+	lq, err := web.ParseList(r, transfersSpec)
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	args := web.Args{uid, dir}
+	rows, err := s.db.Query(r.Context(), `
+		SELECT `+transferCols+`
+		FROM mentorship_transfers t
+		LEFT JOIN users u ON u.id = t.student_id
+		WHERE (($2 <> 'outgoing' AND t.to_mentor_id=$1)
+		    OR ($2 <> 'incoming' AND (t.from_mentor_id=$1 OR t.initiated_by=$1)))`+
+		lq.Where(&args)+lq.OrderLimit(&args), args...)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	defer rows.Close()
+	items := []transfer{}
+	for rows.Next() {
+		if t, err := scanTransfer(rows); err == nil {
+			items = append(items, t)
+		}
+	}
+	items, next := web.Page(w, r, lq, items, func(t transfer, _ string) (any, int64) { return t.CreatedAt, t.ID })
+	web.JSON(w, 200, map[string]any{"items": items, "nextCursor": next})
+}
+
+func (s *Service) AcceptTransfer(w http.ResponseWriter, r *http.Request) {
+	uid := auth.UserID(r)
+	id, err := web.ParamInt64(r, "id")
+	if err != nil {
+		http.Error(w, "bad id", 400)
+		return
+	}
+	tx, err := s.db.Begin(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	defer tx.Rollback(r.Context())
+
+	t, err := scanTransfer(tx.QueryRow(r.Context(), `
+		SELECT `+transferCols+`
+		FROM mentorship_transfers t
+		LEFT JOIN users u ON u.id = t.student_id
+		WHERE t.id=$1 AND t.to_mentor_id=$2
+		FOR UPDATE OF t
+	`, id, uid))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			http.Error(w, "not found", 404)
+			return
+		}
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if t.Status != "pending" {
+		http.Error(w, "bad state", 409)
+		return
+	}
+
+	res, err := tx.Exec(r.Context(), `
+		UPDATE mentorships SET status='ended', ended_at=now()
+		WHERE id=$1 AND status IN ('active','paused')
+	`, t.MentorshipID)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if res.RowsAffected() == 0 {
+		http.Error(w, "mentorship is not active", 409)
+		return
+	}
+	revoked, err := unsharedPlans(r.Context(), tx, t.StudentID, t.FromMentorID)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	var already bool
+	if err := tx.QueryRow(r.Context(), `
+		SELECT EXISTS(SELECT 1 FROM mentorships WHERE student_id=$1 AND mentor_id=$2 AND status='active')
+	`, t.StudentID, uid).Scan(&already); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if already {
+		http.Error(w, "already active", 409)
+		return
+	}
+	mentorshipID, convID, err := activate(r.Context(), tx, t.StudentID, uid)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	if t.CarryPlans {
+		if _, err := tx.Exec(r.Context(), `
+			INSERT INTO plan_shares(plan_id, mentor_id, permission)
+			SELECT ps.plan_id, $3, ps.permission
+			FROM plan_shares ps
+			JOIN plans p ON p.id = ps.plan_id
+			WHERE ps.mentor_id=$2 AND p.user_id=$1
+			ON CONFLICT (plan_id, mentor_id) DO NOTHING
+		`, t.StudentID, t.FromMentorID, uid); err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		if _, err := tx.Exec(r.Context(), `
+			DELETE FROM plan_shares ps USING plans p
+			WHERE p.id = ps.plan_id AND ps.mentor_id=$2 AND p.user_id=$1
+		`, t.StudentID, t.FromMentorID); err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+	}
+	if t.CarryGoals {
+		// Finished and dropped goals stay with the mentorship they belong to.
+		if _, err := tx.Exec(r.Context(), `
+			UPDATE mentorship_goals SET mentorship_id=$2, updated_at=now()
+			WHERE mentorship_id=$1 AND status IN ('proposed','accepted')
+		`, t.MentorshipID, mentorshipID); err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+	}
+
+	var summary string
+	if t.Summary != nil {
+		summary = *t.Summary
+	} else if err := tx.QueryRow(r.Context(), `
+		SELECT body FROM mentor_notes
+		WHERE mentor_id=$1 AND student_id=$2 AND 'summary' = ANY(tags)
+		ORDER BY updated_at DESC, id DESC LIMIT 1
+	`, t.FromMentorID, t.StudentID).Scan(&summary); err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if _, err := tx.Exec(r.Context(), `
+		UPDATE mentorship_transfers SET status='accepted', decided_at=now(), new_mentorship_id=$2 WHERE id=$1
+	`, t.ID, mentorshipID); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	payload := map[string]any{"transferId": t.ID, "mentorshipId": mentorshipID, "studentId": t.StudentID, "toMentorId": uid}
+	for _, to := range uniqueIDs([]int64{t.StudentID, t.FromMentorID, t.InitiatedBy}) {
+		if _, err := notify.Create(r.Context(), tx, to, "mentorship.transfer.accepted", payload); err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+	}
+	if err := tx.Commit(r.Context()); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	s.revokePlans(t.FromMentorID, revoked)
+	s.postSystem(r.Context(), t.StudentID, uid, uid, chat.EventMentorshipTransferred, handoverMessage(summary),
+		map[string]any{"transferId": t.ID, "mentorshipId": mentorshipID, "previousMentorshipId": t.MentorshipID, "fromMentorId": t.FromMentorID})
+	web.JSON(w, 200, map[string]any{"ok": true, "mentorshipId": mentorshipID, "conversationId": convID})
+}
+
+func (s *Service) DeclineTransfer(w http.ResponseWriter, r *http.Request) {
+	s.closeTransfer(w, r, "declined", `to_mentor_id=$2`)
+}
+
+// CancelTransfer withdraws a pending transfer; the initiator and the current
+// mentor may both do so.
+func (s *Service) CancelTransfer(w http.ResponseWriter, r *http.Request) {
+	s.closeTransfer(w, r, "cancelled", `(initiated_by=$2 OR from_mentor_id=$2)`)
+}
+
+func (s *Service) closeTransfer(w http.ResponseWriter, r *http.Request, status, who string) {
+	uid := auth.UserID(r)
+	id, err := web.ParamInt64(r, "id")
+	if err != nil {
+		http.Error(w, "bad id", 400)
+		return
+	}
+	var initiator, toMentor int64
+	err = s.db.QueryRow(r.Context(), `
+		UPDATE mentorship_transfers SET status=$3, decided_at=now()
+		WHERE id=$1 AND `+who+` AND status='pending'
+		RETURNING initiated_by, to_mentor_id
+	`, id, uid, status).Scan(&initiator, &toMentor)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			http.Error(w, "not found or bad state", 409)
+			return
+		}
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	// Tell whoever did not make the decision.
+	to := initiator
+	if uid == initiator {
+		to = toMentor
+	}
+	if _, err := notify.Create(r.Context(), s.db, to, "mentorship.transfer."+status, map[string]any{"transferId": id}); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	web.JSON(w, 200, map[string]any{"ok": true})
+}
+
+func handoverMessage(summary string) string {
+	msg := "This mentorship was transferred from your previous mentor."
+	if summary = strings.TrimSpace(summary); summary != "" {
+		msg += "\n\nHandover note:\n" + summary
+	}
+	return msg
+}
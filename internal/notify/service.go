@@ -0,0 +1,100 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"upskill/internal/auth"
+	"upskill/internal/web"
+)
+
+// Querier is satisfied by both *pgxpool.Pool and pgx.Tx, so notifications
+// can be written inside the caller's transaction.
+type Querier interface {
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+// Create stores a notification for uid and returns its id.
+func Create(ctx context.Context, q Querier, uid int64, kind string, payload map[string]any) (int64, error) {
+	if payload == nil {
+		payload = map[string]any{}
+	}
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		return 0, err
+	}
+	var id int64
+	err = q.QueryRow(ctx, `
+		INSERT INTO notifications(user_id, kind, payload)
+		VALUES($1,$2,$3) RETURNING id
+	`, uid, kind, raw).Scan(&id)
+	return id, err
+}
+
+type Service struct{ db *pgxpool.Pool }
+
+func NewService(db *pgxpool.Pool) *Service { return &Service{db: db} }
+
+func (s *Service) List(w http.ResponseWriter, r *http.Request) {
+	uid := auth.UserID(r)
+	limit := web.QueryInt(r, "limit", 50)
+	if limit <= 0 || limit > 200 {
+		limit = 50
+	}
+	unread := web.QueryString(r, "unread", "") == "1"
+	rows, err := s.db.Query(r.Context(), `
+		SELECT id, kind, payload, created_at, read_at
+		FROM notifications
+		WHERE user_id=$1 AND ($2 = false OR read_at IS NULL)
+		ORDER BY id DESC
+		LIMIT $3
+	`, uid, unread, limit)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	defer rows.Close()
+
+	type Item struct {
+		ID        int64           `json:"id"`
+		Kind      string          `json:"kind"`
+		Payload   json.RawMessage `json:"payload"`
+		CreatedAt time.Time       `json:"createdAt"`
+		ReadAt    *time.Time      `json:"readAt,omitempty"`
+	}
+	var items []Item
+	for rows.Next() {
+		var it Item
+		if err := rows.Scan(&it.ID, &it.Kind, &it.Payload, &it.CreatedAt, &it.ReadAt); err == nil {
+			items = append(items, it)
+		}
+	}
+	web.JSON(w, 200, map[string]any{"items": items})
+}
+
+func (s *Service) MarkRead(w http.ResponseWriter, r *http.Request) {
+	uid := auth.UserID(r)
+	id, err := web.ParamInt64(r, "id")
+	if err != nil {
+		http.Error(w, "bad id", 400)
+		return
+	}
+	res, err := s.db.Exec(r.Context(), `
+		UPDATE notifications SET read_at=COALESCE(read_at, now())
+		WHERE id=$1 AND user_id=$2
+	`, id, uid)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if res.RowsAffected() == 0 {
+		http.Error(w, "not found", 404)
+		return
+	}
+	web.JSON(w, 200, map[string]any{"ok": true})
+}
@@ -0,0 +1,28 @@
+package planner
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// CopyPlan duplicates plan srcID with all its tasks for ownerID and returns
+// the new plan id. Task statuses are reset to pending.
+func CopyPlan(ctx context.Context, tx pgx.Tx, srcID, ownerID int64) (int64, error) {
+	var planID int64
+	err := tx.QueryRow(ctx, `
+		INSERT INTO plans(user_id, topic, level, hours_per_week, start_date, weeks)
+		SELECT $2, topic, level, hours_per_week, start_date, weeks FROM plans WHERE id=$1
+		RETURNING id
+	`, srcID, ownerID).Scan(&planID)
+	if err != nil {
+		return 0, err
+	}
+	_, err = tx.Exec(ctx, `
+		INSERT INTO plan_tasks(plan_id, title, description, start_time, end_time, status, order_no)
+		SELECT $2, title, description, start_time, end_time, 'pending', order_no
+		FROM plan_tasks WHERE plan_id=$1
+		ORDER BY order_no, id
+	`, srcID, planID)
+	return planID, err
+}
@@ -4,21 +4,25 @@ import (
 	"time"
 	"net/http"
 	"fmt"
+	"log"
 
 	"github.com/jackc/pgx/v5/pgxpool"
 
 	"upskill/internal/auth"
+	"upskill/internal/chat"
 	"upskill/internal/config"
+	"upskill/internal/mentorship"
 	"upskill/internal/web"
 )
 
 type Service struct {
 	cfg  config.Config
 	db   *pgxpool.Pool
+	chat *chat.Service
 }
 
-func NewService(cfg config.Config, db *pgxpool.Pool) *Service {
-	return &Service{cfg: cfg, db: db}
+func NewService(cfg config.Config, db *pgxpool.Pool, chatSvc *chat.Service) *Service {
+	return &Service{cfg: cfg, db: db, chat: chatSvc}
 }
 
 func (s *Service) Generate(w http.ResponseWriter, r *http.Request) {
@@ -107,11 +111,14 @@ func (s *Service) Get(w http.ResponseWriter, r *http.Request) {
 		FROM plans WHERE id=$1
 	`, pid).Scan(&owner, &topic, &level, &hpw, &start, &weeks)
 	if err != nil { http.Error(w, "not found", 404); return }
-	if owner != uid { http.Error(w, "forbidden", 403); return }
+	access, err := s.access(r.Context(), pid, owner, uid)
+	if err != nil { http.Error(w, err.Error(), 500); return }
+	if access == "" { http.Error(w, "forbidden", 403); return }
 
 	rows, err := s.db.Query(r.Context(), `
-		SELECT id, title, description, start_time, end_time, status, order_no
-		FROM plan_tasks WHERE plan_id=$1 ORDER BY start_time ASC
+		SELECT t.id, t.title, COALESCE(t.description,''), t.start_time, t.end_time, t.status, t.order_no, t.created_by,
+		       (SELECT COUNT(*) FROM task_comments c WHERE c.task_id = t.id)
+		FROM plan_tasks t WHERE t.plan_id=$1 ORDER BY t.start_time ASC
 	`, pid)
 	if err != nil { http.Error(w, err.Error(), 500); return }
 	defer rows.Close()
@@ -124,12 +131,14 @@ func (s *Service) Get(w http.ResponseWriter, r *http.Request) {
 		End   string `json:"end"`
 		Status string `json:"status"`
 		Order int `json:"order"`
+		CreatedBy *int64 `json:"createdBy,omitempty"`
+		Comments int `json:"comments"`
 	}
 	var tasks []T
 	for rows.Next() {
 		var t T
 		var st, en time.Time
-		if err := rows.Scan(&t.ID, &t.Title, &t.Description, &st, &en, &t.Status, &t.Order); err == nil {
+		if err := rows.Scan(&t.ID, &t.Title, &t.Description, &st, &en, &t.Status, &t.Order, &t.CreatedBy, &t.Comments); err == nil {
 			t.Start = st.UTC().Format(time.RFC3339)
 			t.End = en.UTC().Format(time.RFC3339)
 			tasks = append(tasks, t)
@@ -138,8 +147,9 @@ func (s *Service) Get(w http.ResponseWriter, r *http.Request) {
 	web.JSON(w, 200, map[string]any{
 		"plan": map[string]any{
 			"id": pid, "topic": topic, "level": level, "hoursPerWeek": hpw,
-			"startDate": start.Format("2006-01-02"), "weeks": weeks,
+			"startDate": start.Format("2006-01-02"), "weeks": weeks, "ownerId": owner,
 		},
+		"access": access,
 		"tasks": tasks,
 	})
 }
@@ -160,6 +170,10 @@ func (s *Service) CompleteTask(w http.ResponseWriter, r *http.Request) {
 	ct, err := s.db.Exec(r.Context(), `UPDATE plan_tasks SET status='completed' WHERE id=$1 AND plan_id=$2`, tid, pid)
 	if err != nil { http.Error(w, err.Error(), 500); return }
 	if ct.RowsAffected() == 0 { http.Error(w, "not found", 404); return }
+	if err := mentorship.SyncTaskMilestones(r.Context(), s.db, tid); err != nil {
+		log.Printf("sync milestones for task %d: %v", tid, err)
+	}
+	s.publish(pid, "task.completed", map[string]any{"taskId": tid, "by": uid})
 	web.JSON(w, 200, map[string]any{"ok": true})
 }
 
@@ -0,0 +1,427 @@
+package planner
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	"upskill/internal/auth"
+	"upskill/internal/chat"
+	"upskill/internal/web"
+)
+
+// Access levels on a plan.
+const (
+	AccessOwner   = "owner"
+	AccessComment = "comment"
+	AccessRead    = "read"
+)
+
+// access resolves what uid may do with a plan owned by owner. Shares only
+// count while the mentorship between owner and mentor is active or paused,
+// so access ends together with the mentorship.
+func (s *Service) access(ctx context.Context, pid, owner, uid int64) (string, error) {
+	if owner == uid {
+		return AccessOwner, nil
+	}
+	var perm string
+	err := s.db.QueryRow(ctx, `
+		SELECT ps.permission
+		FROM plan_shares ps
+		JOIN mentorships m ON m.student_id=$3 AND m.mentor_id=ps.mentor_id AND m.status IN ('active','paused')
+		WHERE ps.plan_id=$1 AND ps.mentor_id=$2
+		LIMIT 1
+	`, pid, uid, owner).Scan(&perm)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", nil
+	}
+	return perm, err
+}
+
+// CanView reports whether uid may read plan pid.
+func (s *Service) CanView(ctx context.Context, uid, pid int64) (bool, error) {
+	var owner int64
+	if err := s.db.QueryRow(ctx, `SELECT user_id FROM plans WHERE id=$1`, pid).Scan(&owner); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return false, nil
+		}
+		return false, err
+	}
+	acc, err := s.access(ctx, pid, owner, uid)
+	return acc != "", err
+}
+
+// publish sends a plan event to the subscribers of topic plan:<pid> on the
+// realtime socket:
+//
+//	{"v":1,"type":"plan","planId":5,"event":"task.completed","taskId":9,"by":4}
+func (s *Service) publish(pid int64, event string, fields map[string]any) {
+	if s.chat == nil {
+		return
+	}
+	fields["type"], fields["planId"], fields["event"] = "plan", pid, event
+	s.chat.Publish("plan", pid, fields)
+}
+
+// planAccess loads {id} and the caller's access, writing the error response
+// itself when it returns false.
+func (s *Service) planAccess(w http.ResponseWriter, r *http.Request) (int64, int64, string, bool) {
+	uid := auth.UserID(r)
+	pid, err := web.ParamInt64(r, "id")
+	if err != nil {
+		http.Error(w, "bad id", 400)
+		return 0, 0, "", false
+	}
+	var owner int64
+	if err := s.db.QueryRow(r.Context(), `SELECT user_id FROM plans WHERE id=$1`, pid).Scan(&owner); err != nil {
+		http.Error(w, "not found", 404)
+		return 0, 0, "", false
+	}
+	acc, err := s.access(r.Context(), pid, owner, uid)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return 0, 0, "", false
+	}
+	if acc == "" {
+		http.Error(w, "forbidden", 403)
+		return 0, 0, "", false
+	}
+	return pid, owner, acc, true
+}
+
+func (s *Service) ListShares(w http.ResponseWriter, r *http.Request) {
+	pid, _, acc, ok := s.planAccess(w, r)
+	if !ok {
+		return
+	}
+	if acc != AccessOwner {
+		http.Error(w, "forbidden", 403)
+		return
+	}
+	rows, err := s.db.Query(r.Context(), `
+		SELECT ps.mentor_id, COALESCE(u.first_name,'')||' '||COALESCE(u.last_name,''), ps.permission, ps.created_at,
+		       EXISTS(SELECT 1 FROM mentorships m JOIN plans p ON p.user_id = m.student_id
+		              WHERE p.id = ps.plan_id AND m.mentor_id = ps.mentor_id AND m.status IN ('active','paused'))
+		FROM plan_shares ps
+		LEFT JOIN users u ON u.id = ps.mentor_id
+		WHERE ps.plan_id=$1
+		ORDER BY ps.created_at
+	`, pid)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	defer rows.Close()
+	type Item struct {
+		MentorID   int64     `json:"mentorId"`
+		Mentor     string    `json:"mentor"`
+		Permission string    `json:"permission"`
+		SharedAt   time.Time `json:"sharedAt"`
+		Active     bool      `json:"active"`
+	}
+	items := []Item{}
+	for rows.Next() {
+		var it Item
+		if err := rows.Scan(&it.MentorID, &it.Mentor, &it.Permission, &it.SharedAt, &it.Active); err == nil {
+			items = append(items, it)
+		}
+	}
+	web.JSON(w, 200, map[string]any{"items": items})
+}
+
+// Share grants (or changes) a mentor's access to the caller's plan. Only
+// mentors in an active mentorship with the owner can be added.
+func (s *Service) Share(w http.ResponseWriter, r *http.Request) {
+	uid := auth.UserID(r)
+	pid, _, acc, ok := s.planAccess(w, r)
+	if !ok {
+		return
+	}
+	if acc != AccessOwner {
+		http.Error(w, "forbidden", 403)
+		return
+	}
+	mentorID, err := web.ParamInt64(r, "mentorId")
+	if err != nil {
+		http.Error(w, "bad mentorId", 400)
+		return
+	}
+	var in struct {
+		Permission string `json:"permission"`
+	}
+	if err := web.DecodeJSON(r, &in); err != nil {
+		http.Error(w, "bad input", 400)
+		return
+	}
+	if in.Permission == "" {
+		in.Permission = AccessRead
+	}
+	if in.Permission != AccessRead && in.Permission != AccessComment {
+		http.Error(w, "permission must be read or comment", 400)
+		return
+	}
+	var active bool
+	if err := s.db.QueryRow(r.Context(), `
+		SELECT EXISTS(SELECT 1 FROM mentorships WHERE student_id=$1 AND mentor_id=$2 AND status='active')
+	`, uid, mentorID).Scan(&active); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if !active {
+		http.Error(w, "no active mentorship", 403)
+		return
+	}
+	var before, topic string
+	if err := s.db.QueryRow(r.Context(), `
+		WITH old AS (SELECT permission FROM plan_shares WHERE plan_id=$1 AND mentor_id=$2),
+		up AS (
+			INSERT INTO plan_shares(plan_id, mentor_id, permission)
+			VALUES($1,$2,$3)
+			ON CONFLICT (plan_id, mentor_id) DO UPDATE SET permission=EXCLUDED.permission
+		)
+		SELECT COALESCE((SELECT permission FROM old),''), topic FROM plans WHERE id=$1
+	`, pid, mentorID, in.Permission).Scan(&before, &topic); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if before != in.Permission && s.chat != nil {
+		if _, err := s.chat.PostSystem(r.Context(), uid, mentorID, uid, chat.EventPlanShared, "Shared plan: "+topic,
+			map[string]any{"planId": pid, "topic": topic, "permission": in.Permission}); err != nil {
+			log.Printf("share plan %d: system message: %v", pid, err)
+		}
+	}
+	web.JSON(w, 200, map[string]any{"ok": true, "permission": in.Permission})
+}
+
+func (s *Service) Unshare(w http.ResponseWriter, r *http.Request) {
+	pid, _, acc, ok := s.planAccess(w, r)
+	if !ok {
+		return
+	}
+	if acc != AccessOwner {
+		http.Error(w, "forbidden", 403)
+		return
+	}
+	mentorID, err := web.ParamInt64(r, "mentorId")
+	if err != nil {
+		http.Error(w, "bad mentorId", 400)
+		return
+	}
+	res, err := s.db.Exec(r.Context(), `DELETE FROM plan_shares WHERE plan_id=$1 AND mentor_id=$2`, pid, mentorID)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if res.RowsAffected() == 0 {
+		http.Error(w, "not found", 404)
+		return
+	}
+	if s.chat != nil {
+		s.chat.Revoke("plan", pid, mentorID)
+	}
+	web.JSON(w, 200, map[string]any{"ok": true})
+}
+
+// AddTask lets the owner or a mentor with comment rights add a task.
+func (s *Service) AddTask(w http.ResponseWriter, r *http.Request) {
+	uid := auth.UserID(r)
+	pid, _, acc, ok := s.planAccess(w, r)
+	if !ok {
+		return
+	}
+	if acc == AccessRead {
+		http.Error(w, "read-only access", 403)
+		return
+	}
+	var in struct {
+		Title       string `json:"title"`
+		Description string `json:"description"`
+		Start       string `json:"start"`
+		End         string `json:"end"`
+	}
+	if err := web.DecodeJSON(r, &in); err != nil || strings.TrimSpace(in.Title) == "" {
+		http.Error(w, "bad input", 400)
+		return
+	}
+	start, err1 := time.Parse(time.RFC3339, in.Start)
+	end, err2 := time.Parse(time.RFC3339, in.End)
+	if err1 != nil || err2 != nil || !end.After(start) {
+		http.Error(w, "bad start/end", 400)
+		return
+	}
+	var createdBy any
+	if acc != AccessOwner {
+		createdBy = uid
+	}
+	var id int64
+	err := s.db.QueryRow(r.Context(), `
+		INSERT INTO plan_tasks(plan_id, title, description, start_time, end_time, status, order_no, created_by)
+		VALUES($1,$2,$3,$4,$5,'pending',
+		       (SELECT COALESCE(MAX(order_no),0)+1 FROM plan_tasks WHERE plan_id=$1), $6)
+		RETURNING id
+	`, pid, strings.TrimSpace(in.Title), in.Description, start, end, createdBy).Scan(&id)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	s.publish(pid, "task.added", map[string]any{"taskId": id, "by": uid})
+	web.JSON(w, 201, map[string]any{"taskId": id})
+}
+
+func (s *Service) ListComments(w http.ResponseWriter, r *http.Request) {
+	pid, _, _, ok := s.planAccess(w, r)
+	if !ok {
+		return
+	}
+	tid, err := web.ParamInt64(r, "taskId")
+	if err != nil {
+		http.Error(w, "bad taskId", 400)
+		return
+	}
+	rows, err := s.db.Query(r.Context(), `
+		SELECT c.id, c.author_id, COALESCE(u.first_name,'')||' '||COALESCE(u.last_name,''), c.body, c.created_at
+		FROM task_comments c
+		JOIN plan_tasks t ON t.id = c.task_id
+		LEFT JOIN users u ON u.id = c.author_id
+		WHERE c.task_id=$1 AND t.plan_id=$2
+		ORDER BY c.created_at, c.id
+	`, tid, pid)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	defer rows.Close()
+	type Item struct {
+		ID        int64     `json:"id"`
+		AuthorID  int64     `json:"authorId"`
+		Author    string    `json:"author"`
+		Body      string    `json:"body"`
+		CreatedAt time.Time `json:"createdAt"`
+	}
+	items := []Item{}
+	for rows.Next() {
+		var it Item
+		if err := rows.Scan(&it.ID, &it.AuthorID, &it.Author, &it.Body, &it.CreatedAt); err == nil {
+			items = append(items, it)
+		}
+	}
+	web.JSON(w, 200, map[string]any{"items": items})
+}
+
+func (s *Service) AddComment(w http.ResponseWriter, r *http.Request) {
+	uid := auth.UserID(r)
+	pid, _, acc, ok := s.planAccess(w, r)
+	if !ok {
+		return
+	}
+	if acc == AccessRead {
+		http.Error(w, "read-only access", 403)
+		return
+	}
+	tid, err := web.ParamInt64(r, "taskId")
+	if err != nil {
+		http.Error(w, "bad taskId", 400)
+		return
+	}
+	var in struct {
+		Body string `json:"body"`
+	}
+	if err := web.DecodeJSON(r, &in); err != nil || strings.TrimSpace(in.Body) == "" {
+		http.Error(w, "bad body", 400)
+		return
+	}
+	var id int64
+	err = s.db.QueryRow(r.Context(), `
+		INSERT INTO task_comments(task_id, author_id, body)
+		SELECT id, $3, $4 FROM plan_tasks WHERE id=$1 AND plan_id=$2
+		RETURNING id
+	`, tid, pid, uid, in.Body).Scan(&id)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			http.Error(w, "not found", 404)
+			return
+		}
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	s.publish(pid, "comment.added", map[string]any{"taskId": tid, "commentId": id, "by": uid})
+	web.JSON(w, 201, map[string]any{"commentId": id})
+}
+
+// MenteeProgress summarises task completion of every plan shared with the
+// calling mentor, grouped by mentee.
+func (s *Service) MenteeProgress(w http.ResponseWriter, r *http.Request) {
+	mid := auth.UserID(r)
+	rows, err := s.db.Query(r.Context(), `
+		SELECT p.user_id, COALESCE(u.first_name,'')||' '||COALESCE(u.last_name,''),
+		       p.id, p.topic, ps.permission,
+		       COUNT(t.id),
+		       COUNT(t.id) FILTER (WHERE t.status='completed'),
+		       COUNT(t.id) FILTER (WHERE t.status='pending' AND t.end_time < now())
+		FROM plan_shares ps
+		JOIN plans p ON p.id = ps.plan_id
+		LEFT JOIN users u ON u.id = p.user_id
+		LEFT JOIN plan_tasks t ON t.plan_id = p.id
+		WHERE ps.mentor_id=$1
+		  AND EXISTS (SELECT 1 FROM mentorships m
+		              WHERE m.student_id = p.user_id AND m.mentor_id = ps.mentor_id AND m.status IN ('active','paused'))
+		GROUP BY p.id, u.id, ps.permission
+		ORDER BY p.user_id, p.created_at DESC
+	`, mid)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	defer rows.Close()
+
+	type Plan struct {
+		PlanID     int64  `json:"planId"`
+		Topic      string `json:"topic"`
+		Permission string `json:"permission"`
+		Total      int    `json:"total"`
+		Completed  int    `json:"completed"`
+		Overdue    int    `json:"overdue"`
+		Percent    int    `json:"percent"`
+	}
+	type Mentee struct {
+		StudentID int64  `json:"studentId"`
+		Name      string `json:"name"`
+		Total     int    `json:"total"`
+		Completed int    `json:"completed"`
+		Overdue   int    `json:"overdue"`
+		Percent   int    `json:"percent"`
+		Plans     []Plan `json:"plans"`
+	}
+	items := []Mentee{}
+	for rows.Next() {
+		var sid int64
+		var name string
+		var p Plan
+		if err := rows.Scan(&sid, &name, &p.PlanID, &p.Topic, &p.Permission, &p.Total, &p.Completed, &p.Overdue); err != nil {
+			continue
+		}
+		p.Percent = percent(p.Completed, p.Total)
+		if n := len(items); n == 0 || items[n-1].StudentID != sid {
+			items = append(items, Mentee{StudentID: sid, Name: name})
+		}
+		m := &items[len(items)-1]
+		m.Plans = append(m.Plans, p)
+		m.Total += p.Total
+		m.Completed += p.Completed
+		m.Overdue += p.Overdue
+		m.Percent = percent(m.Completed, m.Total)
+	}
+	web.JSON(w, 200, map[string]any{"items": items})
+}
+
+func percent(done, total int) int {
+	if total == 0 {
+		return 0
+	}
+	return done * 100 / total
+}
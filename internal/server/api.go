@@ -1,36 +1,49 @@
 package server
 
 import (
+	"context"
 	"net/http"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 
 	"upskill/internal/auth"
+	"upskill/internal/booking"
+	"upskill/internal/calendar"
 	"upskill/internal/chat"
+	"upskill/internal/cohort"
 	"upskill/internal/config"
+	"upskill/internal/feedback"
 	"upskill/internal/mentorship"
+	"upskill/internal/notify"
 	"upskill/internal/planner"
 	"upskill/internal/roles"
 )
 
-func newAPI(cfg config.Config, pool *pgxpool.Pool) http.Handler {
+func newAPI(ctx context.Context, cfg config.Config, pool *pgxpool.Pool) http.Handler {
 	r := chi.NewRouter()
 
 	authSvc := auth.NewService(cfg, pool)
 	r.Post("/auth/register", authSvc.Register)
 	r.Post("/auth/login", authSvc.Login)
 
+	ch := chat.NewService(ctx, cfg, pool, authSvc)
+	r.Get("/chat/attachments/{id}/download", ch.DownloadAttachment) // signed URL
+
+	ms := mentorship.NewService(pool, authSvc, ch)
+	r.Post("/auth/invite/register", ms.RegisterWithInvite)
+	r.Get("/invites/code/{code}", ms.PreviewInvite)
+
 	r.Group(func(r chi.Router) {
 		r.Use(authSvc.JWTMiddleware)
 
 		r.Get("/user/me", authSvc.Me)
+		r.Put("/user/me/handle", authSvc.SetHandle)
 
 		roleSvc := roles.NewService(pool)
 		r.Post("/roles", roleSvc.Assign) // <- было Add
 		r.Get("/roles/me", roleSvc.Me)
 
-		ms := mentorship.NewService(pool)
 		r.Post("/mentorship/requests", ms.RequestCreate)    // student
 		r.Get("/mentorship/requests", ms.MyRequests)        // student outgoing
 		r.Get("/mentor/requests", ms.MentorRequests)        // mentor incoming
@@ -38,22 +51,122 @@ func newAPI(cfg config.Config, pool *pgxpool.Pool) http.Handler {
 		r.Post("/mentor/requests/{id}/decline", ms.Decline) // mentor
 		r.Get("/mentor/mentees", ms.ListMentees)            // mentor
 		r.Get("/student/mentors", ms.ListMentors)           // student
+		r.Post("/mentor/invites", ms.CreateInvite)          // mentor
+		r.Get("/mentor/invites", ms.ListInvites)            // mentor
+		r.Delete("/mentor/invites/{id}", ms.RevokeInvite)   // mentor
+		r.Get("/invites", ms.MyInvites)                     // invitee
+		r.Post("/invites/{id}/accept", ms.AcceptInvite)     // invitee
+		r.Post("/invites/{id}/decline", ms.DeclineInvite)   // invitee
+		r.Post("/invites/code/{code}/accept", ms.AcceptInviteCode)
+		r.Post("/mentorships/{id}/pause", ms.Pause)              // either party
+		r.Post("/mentorships/{id}/resume", ms.Resume)            // either party
+		r.Post("/mentorships/{id}/end", ms.End)                  // either party
+		r.Post("/mentorships/{id}/transfer", ms.RequestTransfer) // mentor or admin
+		r.Get("/mentor/transfers", ms.ListTransfers)
+		r.Post("/mentor/transfers/{id}/accept", ms.AcceptTransfer)   // target mentor
+		r.Post("/mentor/transfers/{id}/decline", ms.DeclineTransfer) // target mentor
+		r.Post("/mentor/transfers/{id}/cancel", ms.CancelTransfer)
+		r.Get("/mentor/mentees/{studentId}/notes", ms.ListNotes)
+		r.Post("/mentor/mentees/{studentId}/notes", ms.CreateNote)
+		r.Patch("/mentor/mentees/{studentId}/notes/{noteId}", ms.UpdateNote)
+		r.Delete("/mentor/mentees/{studentId}/notes/{noteId}", ms.DeleteNote)
+		r.Get("/mentors", ms.Directory)
+		r.Get("/mentors/{id}", ms.Profile)
+		r.Get("/mentors/{id}/stats", ms.MentorStats)
+		r.Get("/mentorships/{id}/goals", ms.ListGoals)
+		r.Post("/mentorships/{id}/goals", ms.ProposeGoal)
+		r.Patch("/mentorships/{id}/goals/{goalId}", ms.UpdateGoal)
+		r.Post("/mentorships/{id}/goals/{goalId}/milestones", ms.AddMilestone)
+		r.Patch("/mentorships/{id}/goals/{goalId}/milestones/{milestoneId}", ms.UpdateMilestone)
+
+		ns := notify.NewService(pool)
+		r.Get("/notifications", ns.List)
+		r.Post("/notifications/{id}/read", ns.MarkRead)
 
-		ch := chat.NewService(pool, authSvc)
 		r.Get("/chat/global/messages", ch.GlobalHistory)
 		r.Post("/chat/global/messages", ch.GlobalPost)
+		r.Patch("/chat/global/messages/{messageId}", ch.GlobalEdit)
+		r.Delete("/chat/global/messages/{messageId}", ch.GlobalDelete) // author or moderator
+		r.Get("/chat/global/messages/{messageId}/revisions", ch.GlobalRevisions)
+		r.Get("/chat/global/messages/{messageId}/thread", ch.GlobalThread)
+		r.Put("/chat/global/messages/{messageId}/reactions/{emoji}", ch.GlobalAddReaction)
+		r.Delete("/chat/global/messages/{messageId}/reactions/{emoji}", ch.GlobalRemoveReaction)
+		r.Post("/chat/global/messages/{messageId}/report", ch.ReportGlobal)
+		r.Get("/chat/moderation/reports", ch.ListReports) // moderators only, as below
+		r.Post("/chat/moderation/reports/{id}/resolve", ch.ResolveReport)
+		r.Get("/chat/moderation/sanctions", ch.ListSanctions)
+		r.Post("/chat/moderation/sanctions", ch.CreateSanction)
+		r.Delete("/chat/moderation/sanctions/{id}", ch.RevokeSanction)
+		r.Get("/chat/moderation/settings", ch.GetModerationSettings)
+		r.Put("/chat/moderation/settings", ch.PutModerationSettings)
+		r.Get("/chat/moderation/log", ch.ModerationLog)
+		r.Get("/chat/search", ch.Search)
+		r.Get("/chat/mentions/suggest", ch.SuggestMentions)
 		r.Get("/chat/conversations", ch.ListConversations)
 		r.Post("/chat/conversations", ch.EnsureConversation)
 		r.Get("/chat/conversations/{id}/messages", ch.History)
 		r.Post("/chat/conversations/{id}/messages", ch.PostMessage)
+		r.Patch("/chat/conversations/{id}/messages/{messageId}", ch.EditMessage)
+		r.Delete("/chat/conversations/{id}/messages/{messageId}", ch.DeleteMessage) // author or moderator
+		r.Get("/chat/conversations/{id}/messages/{messageId}/revisions", ch.MessageRevisions)
+		r.Put("/chat/conversations/{id}/messages/{messageId}/reactions/{emoji}", ch.AddReaction)
+		r.Delete("/chat/conversations/{id}/messages/{messageId}/reactions/{emoji}", ch.RemoveReaction)
+		r.Post("/chat/conversations/{id}/read", ch.MarkRead)
+		r.Post("/chat/conversations/{id}/attachments", ch.UploadAttachment)
+		r.Get("/chat/attachments/{id}/url", ch.AttachmentURL)
+		r.Get("/ws", ch.MuxWS) // every topic over one socket
 		r.Get("/ws/chat/global", ch.GlobalWS)
 		r.Get("/ws/chat", ch.ChatWS)
+		r.Get("/ws/chat/user", ch.UserWS)
+		r.Get("/cohorts/{id}/messages", ch.CohortHistory)
+		r.Post("/cohorts/{id}/messages", ch.CohortPost)
+		r.Get("/ws/cohorts/{id}", ch.CohortWS)
+
+		co := cohort.NewService(pool, ch)
+		r.Get("/cohorts", co.List)
+		r.Post("/cohorts", co.Create) // mentor
+		r.Get("/cohorts/{id}", co.Get)
+		r.Patch("/cohorts/{id}", co.Update)           // lead mentor
+		r.Post("/cohorts/{id}/mentors", co.AddMentor) // lead mentor
+		r.Delete("/cohorts/{id}/mentors/{mentorId}", co.RemoveMentor)
+		r.Post("/cohorts/{id}/enroll", co.Enroll) // student
+		r.Post("/cohorts/{id}/leave", co.Leave)   // student
+		r.Delete("/cohorts/{id}/members/{studentId}", co.RemoveMember)
+		r.Put("/cohorts/{id}/plan", co.SetPlan)      // mentor
+		r.Get("/cohorts/{id}/progress", co.Progress) // mentor
 
-		pl := planner.NewService(cfg, pool)
+		pl := planner.NewService(cfg, pool, ch)
+		ch.RegisterTopic("plan", pl.CanView)
 		r.Post("/plans/generate", pl.Generate)
 		r.Get("/plans", pl.List)
 		r.Get("/plans/{id}", pl.Get)
 		r.Post("/plans/{id}/tasks/{taskId}/complete", pl.CompleteTask)
+		r.Post("/plans/{id}/tasks", pl.AddTask)
+		r.Get("/plans/{id}/tasks/{taskId}/comments", pl.ListComments)
+		r.Post("/plans/{id}/tasks/{taskId}/comments", pl.AddComment)
+		r.Get("/plans/{id}/shares", pl.ListShares)
+		r.Put("/plans/{id}/shares/{mentorId}", pl.Share)
+		r.Delete("/plans/{id}/shares/{mentorId}", pl.Unshare)
+		r.Get("/mentor/progress", pl.MenteeProgress) // mentor
+
+		bk := booking.NewService(cfg, pool, ch)
+		r.Get("/mentor/availability", bk.GetAvailability)
+		r.Put("/mentor/availability", bk.PutAvailability)
+		r.Post("/mentor/availability/exceptions", bk.AddException)
+		r.Delete("/mentor/availability/exceptions/{id}", bk.DeleteException)
+		r.Get("/mentors/{id}/slots", bk.Slots)
+		r.Get("/sessions", bk.ListSessions)
+		r.Post("/sessions", bk.Book)
+		r.Post("/sessions/{id}/cancel", bk.Cancel)
+		r.Post("/sessions/{id}/reschedule", bk.Reschedule)
+
+		fb := feedback.NewService(pool)
+		r.Get("/feedback/tags", fb.TagList)
+		r.Post("/mentors/{id}/feedback", fb.Create) // student
+		r.Get("/mentor/feedback", fb.Mine)          // mentor
+
+		cal := calendar.NewService(cfg, pool, authSvc, pl)
+		r.Get("/calendar/ics", cal.ICS)
 
 	})
 
@@ -1,6 +1,8 @@
 package server
 
 import (
+	"context"
+	"expvar"
 	"net/http"
 	"strings"
 	"time"
@@ -13,7 +15,9 @@ import (
 	"upskill/internal/web"
 )
 
-func New(cfg config.Config, pool *pgxpool.Pool) http.Handler {
+// New builds the HTTP handler. Background work it starts, such as the chat
+// listeners, stops when ctx is done.
+func New(ctx context.Context, cfg config.Config, pool *pgxpool.Pool) http.Handler {
 	r := chi.NewRouter()
 
 	r.Use(web.RequestID)
@@ -22,7 +26,7 @@ func New(cfg config.Config, pool *pgxpool.Pool) http.Handler {
 
 	r.Use(cors.Handler(cors.Options{
 		AllowedOrigins:   cfg.AllowedOrigins,
-		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
+		AllowedMethods:   []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
 		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token"},
 		ExposedHeaders:   []string{"Link"},
 		AllowCredentials: true,
@@ -33,9 +37,18 @@ func New(cfg config.Config, pool *pgxpool.Pool) http.Handler {
 		web.JSON(w, http.StatusOK, map[string]any{"status": "ok"})
 	})
 
-	api := newAPI(cfg, pool)
+	api := newAPI(ctx, cfg, pool)
 	r.Mount("/api", api)
 
 	_ = strings.Builder{}
 	return r
 }
+
+// Debug serves expvar (runtime stats plus chat hub gauges: chat_connections,
+// chat_rooms, ...). It exposes the command line and memory stats, so it goes
+// on the internal listener at cfg.DebugAddr, never on the public router.
+func Debug() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/debug/vars", expvar.Handler())
+	return mux
+}
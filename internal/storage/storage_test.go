@@ -0,0 +1,72 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"testing"
+	"time"
+)
+
+func roundTrip(t *testing.T, s Store) {
+	t.Helper()
+	ctx := context.Background()
+	key := fmt.Sprintf("test/%d/hello world.txt", time.Now().UnixNano())
+	data := []byte("hello, storage")
+	if err := s.Put(ctx, key, bytes.NewReader(data), int64(len(data)), "text/plain"); err != nil {
+		t.Fatalf("put: %v", err)
+	}
+	rc, err := s.Open(ctx, key)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	got, _ := io.ReadAll(rc)
+	rc.Close()
+	if !bytes.Equal(got, data) {
+		t.Fatalf("read back %q", got)
+	}
+	if err := s.Delete(ctx, key); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if _, err := s.Open(ctx, key); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("open after delete: %v", err)
+	}
+}
+
+func TestLocal(t *testing.T) {
+	s, err := NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	roundTrip(t, s)
+	if err := s.Put(context.Background(), "../escape", bytes.NewReader(nil), 0, ""); err == nil {
+		t.Fatal("key outside the directory accepted")
+	}
+}
+
+// TestS3 runs against an S3-compatible server, e.g. the MinIO from
+// docker-compose:
+//
+//	docker compose --profile s3 up -d minio minio-init
+//	TEST_S3_ENDPOINT=http://localhost:9000 TEST_S3_BUCKET=upskill \
+//	TEST_S3_ACCESS_KEY=upskill TEST_S3_SECRET_KEY=upskill-secret go test ./internal/storage
+func TestS3(t *testing.T) {
+	endpoint := os.Getenv("TEST_S3_ENDPOINT")
+	if endpoint == "" {
+		t.Skip("TEST_S3_ENDPOINT not set")
+	}
+	s, err := NewS3(S3Config{
+		Endpoint:  endpoint,
+		Region:    os.Getenv("TEST_S3_REGION"),
+		Bucket:    os.Getenv("TEST_S3_BUCKET"),
+		AccessKey: os.Getenv("TEST_S3_ACCESS_KEY"),
+		SecretKey: os.Getenv("TEST_S3_SECRET_KEY"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	roundTrip(t, s)
+}
@@ -0,0 +1,282 @@
+package web
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// List endpoints share one query format:
+//
+//	?status=pending,approved   filter on a whitelisted status column
+//	?from=2024-01-01&to=...    date range (dates are inclusive, RFC 3339 is accepted too)
+//	?q=ann                     case-insensitive name search, or a web-style
+//	                           full-text query on specs with a SearchTSV column
+//	?sort=-createdAt           whitelisted sort key, "-" for descending
+//	?limit=20&cursor=...       keyset pagination
+//
+// Only column expressions declared in a ListSpec ever reach SQL; everything
+// the client sends is bound as an argument.
+
+// Sort key types; they decide how a cursor value is decoded and cast.
+const (
+	SortTime  = "timestamptz"
+	SortText  = "text"
+	SortInt   = "bigint"
+	SortFloat = "float8"
+)
+
+type SortKey struct {
+	Column string // SQL expression, must not be NULL
+	Type   string // SortTime, SortText, SortInt or SortFloat
+}
+
+// ListSpec declares what a list endpoint accepts. Empty columns disable the
+// matching filter.
+type ListSpec struct {
+	IDColumn        string // unique tie-breaker for sorting and cursors
+	Sorts           map[string]SortKey
+	DefaultSort     string
+	StatusColumn    string
+	Statuses        []string
+	DefaultStatuses []string // applied when ?status is absent
+	DateColumn      string
+	SearchColumn    string
+	SearchTSV       string // tsvector column built with the 'simple' configuration
+	DefaultLimit    int
+	MaxLimit        int
+}
+
+// ListQuery is a parsed and validated list request.
+type ListQuery struct {
+	spec     ListSpec
+	Statuses []string
+	From     *time.Time
+	To       *time.Time // exclusive
+	Search   string
+	Sort     string
+	Desc     bool
+	Limit    int
+	after    *cursor
+	afterVal any
+}
+
+type cursor struct {
+	Sort  string `json:"s"`
+	Value string `json:"v"`
+	ID    int64  `json:"id"`
+}
+
+func ParseList(r *http.Request, spec ListSpec) (ListQuery, error) {
+	q := ListQuery{spec: spec}
+	v := r.URL.Query()
+
+	if s := v.Get("status"); s != "" {
+		if spec.StatusColumn == "" {
+			return q, errors.New("status filter not supported")
+		}
+		for _, st := range strings.Split(s, ",") {
+			st = strings.TrimSpace(st)
+			if !contains(spec.Statuses, st) {
+				return q, fmt.Errorf("bad status %q", st)
+			}
+			q.Statuses = append(q.Statuses, st)
+		}
+	} else {
+		q.Statuses = spec.DefaultStatuses
+	}
+
+	if s := v.Get("from"); s != "" {
+		if spec.DateColumn == "" {
+			return q, errors.New("date filter not supported")
+		}
+		t, _, err := parseBound(s)
+		if err != nil {
+			return q, errors.New("bad from")
+		}
+		q.From = &t
+	}
+	if s := v.Get("to"); s != "" {
+		if spec.DateColumn == "" {
+			return q, errors.New("date filter not supported")
+		}
+		t, dateOnly, err := parseBound(s)
+		if err != nil {
+			return q, errors.New("bad to")
+		}
+		if dateOnly {
+			t = t.AddDate(0, 0, 1)
+		}
+		q.To = &t
+	}
+
+	if s := strings.TrimSpace(v.Get("q")); s != "" {
+		if spec.SearchColumn == "" && spec.SearchTSV == "" {
+			return q, errors.New("search not supported")
+		}
+		q.Search = s
+	}
+
+	sort := v.Get("sort")
+	if sort == "" {
+		sort = spec.DefaultSort
+	}
+	q.Desc = strings.HasPrefix(sort, "-")
+	q.Sort = strings.TrimPrefix(sort, "-")
+	if _, ok := spec.Sorts[q.Sort]; !ok {
+		return q, fmt.Errorf("bad sort %q", q.Sort)
+	}
+
+	q.Limit = QueryInt(r, "limit", spec.DefaultLimit)
+	if q.Limit <= 0 || q.Limit > spec.MaxLimit {
+		return q, fmt.Errorf("limit must be between 1 and %d", spec.MaxLimit)
+	}
+
+	if s := v.Get("cursor"); s != "" {
+		raw, err := base64.RawURLEncoding.DecodeString(s)
+		if err != nil {
+			return q, errors.New("bad cursor")
+		}
+		var c cursor
+		if err := json.Unmarshal(raw, &c); err != nil || c.Sort != sort {
+			return q, errors.New("bad cursor")
+		}
+		if q.afterVal, err = decodeValue(spec.Sorts[q.Sort].Type, c.Value); err != nil {
+			return q, errors.New("bad cursor")
+		}
+		q.after = &c
+	}
+	return q, nil
+}
+
+// Args collects positional query arguments.
+type Args []any
+
+// Add appends v and returns its placeholder.
+func (a *Args) Add(v any) string {
+	*a = append(*a, v)
+	return "$" + strconv.Itoa(len(*a))
+}
+
+// Where returns the filter and cursor conditions, each prefixed with AND.
+func (q ListQuery) Where(a *Args) string {
+	var b strings.Builder
+	if len(q.Statuses) > 0 {
+		b.WriteString(" AND " + q.spec.StatusColumn + " = ANY(" + a.Add(q.Statuses) + ")")
+	}
+	if q.From != nil {
+		b.WriteString(" AND " + q.spec.DateColumn + " >= " + a.Add(*q.From))
+	}
+	if q.To != nil {
+		b.WriteString(" AND " + q.spec.DateColumn + " < " + a.Add(*q.To))
+	}
+	if q.Search != "" {
+		if q.spec.SearchTSV != "" {
+			b.WriteString(" AND " + q.spec.SearchTSV + " @@ " + q.TSQuery(a))
+		} else {
+			b.WriteString(" AND " + q.spec.SearchColumn + " ILIKE '%' || " + a.Add(escapeLike(q.Search)) + " || '%'")
+		}
+	}
+	if q.after != nil {
+		key := q.spec.Sorts[q.Sort]
+		op := ">"
+		if q.Desc {
+			op = "<"
+		}
+		b.WriteString(" AND (" + key.Column + ", " + q.spec.IDColumn + ") " + op +
+			" (" + a.Add(q.afterVal) + "::" + key.Type + ", " + a.Add(q.after.ID) + "::bigint)")
+	}
+	return b.String()
+}
+
+// TSQuery returns the tsquery for ?q, for ranking and highlighting on specs
+// with a SearchTSV column.
+func (q ListQuery) TSQuery(a *Args) string {
+	return "websearch_to_tsquery('simple', " + a.Add(q.Search) + ")"
+}
+
+// OrderLimit returns the ORDER BY and LIMIT clauses. It asks for one row more
+// than the page size so Page can tell whether another page follows.
+func (q ListQuery) OrderLimit(a *Args) string {
+	dir := " ASC"
+	if q.Desc {
+		dir = " DESC"
+	}
+	key := q.spec.Sorts[q.Sort]
+	return " ORDER BY " + key.Column + dir + ", " + q.spec.IDColumn + dir + " LIMIT " + a.Add(q.Limit+1)
+}
+
+// Page trims items fetched with OrderLimit to the page size. When more rows
+// exist it sets a Link rel="next" header and returns the next cursor. key
+// returns the sort value for the active sort key (see ListQuery.Sort) and
+// the row id.
+func Page[T any](w http.ResponseWriter, r *http.Request, q ListQuery, items []T, key func(it T, sort string) (any, int64)) ([]T, string) {
+	if len(items) <= q.Limit {
+		return items, ""
+	}
+	items = items[:q.Limit]
+	val, id := key(items[len(items)-1], q.Sort)
+	sort := q.Sort
+	if q.Desc {
+		sort = "-" + sort
+	}
+	raw, _ := json.Marshal(cursor{Sort: sort, Value: encodeValue(val), ID: id})
+	next := base64.RawURLEncoding.EncodeToString(raw)
+
+	v := r.URL.Query()
+	v.Set("cursor", next)
+	w.Header().Add("Link", "<"+r.URL.Path+"?"+v.Encode()+`>; rel="next"`)
+	return items, next
+}
+
+func encodeValue(v any) string {
+	switch x := v.(type) {
+	case time.Time:
+		return x.UTC().Format(time.RFC3339Nano)
+	case int64:
+		return strconv.FormatInt(x, 10)
+	case float64:
+		return strconv.FormatFloat(x, 'g', -1, 64)
+	case string:
+		return x
+	}
+	return fmt.Sprint(v)
+}
+
+func decodeValue(typ, s string) (any, error) {
+	switch typ {
+	case SortTime:
+		return time.Parse(time.RFC3339Nano, s)
+	case SortInt:
+		return strconv.ParseInt(s, 10, 64)
+	case SortFloat:
+		return strconv.ParseFloat(s, 64)
+	}
+	return s, nil
+}
+
+func parseBound(s string) (time.Time, bool, error) {
+	if t, err := time.Parse("2006-01-02", s); err == nil {
+		return t, true, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	return t, false, err
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+func escapeLike(s string) string { return likeEscaper.Replace(s) }
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,192 @@
+package web
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var testSpec = ListSpec{
+	IDColumn: "r.id",
+	Sorts: map[string]SortKey{
+		"createdAt": {Column: "r.created_at", Type: SortTime},
+		"name":      {Column: "u.name", Type: SortText},
+		"seats":     {Column: "r.seats", Type: SortInt},
+		"score":     {Column: "r.score", Type: SortFloat},
+	},
+	DefaultSort:     "-createdAt",
+	StatusColumn:    "r.status",
+	Statuses:        []string{"pending", "approved", "declined"},
+	DefaultStatuses: []string{"pending"},
+	DateColumn:      "r.created_at",
+	SearchColumn:    "u.name",
+	DefaultLimit:    20,
+	MaxLimit:        50,
+}
+
+func parse(t *testing.T, spec ListSpec, query string) (ListQuery, error) {
+	t.Helper()
+	return ParseList(httptest.NewRequest("GET", "/items?"+query, nil), spec)
+}
+
+func TestParseListRejects(t *testing.T) {
+	noFilters := testSpec
+	noFilters.StatusColumn, noFilters.DateColumn, noFilters.SearchColumn = "", "", ""
+	for _, tc := range []struct {
+		spec  ListSpec
+		query string
+	}{
+		{testSpec, "sort=password"},
+		{testSpec, "sort=-password"},
+		{testSpec, "status=pending,deleted"},
+		{testSpec, "status=PENDING"},
+		{testSpec, "limit=0"},
+		{testSpec, "limit=51"},
+		{testSpec, "from=yesterday"},
+		{testSpec, "to=2024-13-01"},
+		{testSpec, "cursor=!!!"},
+		{testSpec, "cursor=bm90IGpzb24"},
+		{noFilters, "status=pending"},
+		{noFilters, "from=2024-01-01"},
+		{noFilters, "q=ann"},
+	} {
+		if _, err := parse(t, tc.spec, tc.query); err == nil {
+			t.Errorf("%q accepted", tc.query)
+		}
+	}
+}
+
+func TestParseListDefaults(t *testing.T) {
+	q, err := parse(t, testSpec, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q.Sort != "createdAt" || !q.Desc || q.Limit != 20 || !reflect.DeepEqual(q.Statuses, []string{"pending"}) {
+		t.Fatalf("defaults: %+v", q)
+	}
+	if q, _ = parse(t, testSpec, "status=approved,+declined&sort=name&limit=5"); q.Desc || q.Sort != "name" || q.Limit != 5 ||
+		!reflect.DeepEqual(q.Statuses, []string{"approved", "declined"}) {
+		t.Fatalf("explicit: %+v", q)
+	}
+}
+
+func TestWhere(t *testing.T) {
+	day := func(s string) time.Time { t, _ := time.Parse("2006-01-02", s); return t }
+	for _, tc := range []struct {
+		query string
+		where string
+		args  Args
+	}{
+		{"", " AND r.status = ANY($2)", Args{int64(7), []string{"pending"}}},
+		{"status=approved&from=2024-01-01&to=2024-01-31",
+			" AND r.status = ANY($2) AND r.created_at >= $3 AND r.created_at < $4",
+			Args{int64(7), []string{"approved"}, day("2024-01-01"), day("2024-02-01")}},
+		{"to=2024-01-31T12:00:00Z", " AND r.status = ANY($2) AND r.created_at < $3",
+			Args{int64(7), []string{"pending"}, time.Date(2024, 1, 31, 12, 0, 0, 0, time.UTC)}},
+		{"q=50%25_a", " AND r.status = ANY($2) AND u.name ILIKE '%' || $3 || '%'",
+			Args{int64(7), []string{"pending"}, `50\%\_a`}},
+	} {
+		q, err := parse(t, testSpec, tc.query)
+		if err != nil {
+			t.Fatalf("%q: %v", tc.query, err)
+		}
+		args := Args{int64(7)}
+		if got := q.Where(&args); got != tc.where {
+			t.Errorf("%q: where %q", tc.query, got)
+		}
+		if !reflect.DeepEqual(args, tc.args) {
+			t.Errorf("%q: args %#v", tc.query, args)
+		}
+	}
+
+	tsv := testSpec
+	tsv.SearchColumn, tsv.SearchTSV, tsv.DefaultStatuses = "", "r.tsv", nil
+	q, _ := parse(t, tsv, "q=%22goroutine+leak%22")
+	var args Args
+	if got := q.Where(&args); got != ` AND r.tsv @@ websearch_to_tsquery('simple', $1)` || args[0] != `"goroutine leak"` {
+		t.Errorf("full-text search: %q %v", got, args)
+	}
+}
+
+func TestOrderLimit(t *testing.T) {
+	for query, want := range map[string]string{
+		"":                   " ORDER BY r.created_at DESC, r.id DESC LIMIT $1",
+		"sort=name&limit=10": " ORDER BY u.name ASC, r.id ASC LIMIT $1",
+		"sort=-score":        " ORDER BY r.score DESC, r.id DESC LIMIT $1",
+	} {
+		q, err := parse(t, testSpec, query)
+		if err != nil {
+			t.Fatalf("%q: %v", query, err)
+		}
+		var args Args
+		if got := q.OrderLimit(&args); got != want || args[0] != q.Limit+1 {
+			t.Errorf("%q: %q %v", query, got, args)
+		}
+	}
+}
+
+func TestCursorRoundTrip(t *testing.T) {
+	type item struct {
+		id    int64
+		value any
+	}
+	at := time.Date(2024, 3, 1, 9, 30, 0, 123456789, time.FixedZone("CET", 3600))
+	for _, tc := range []struct {
+		sort  string
+		value any
+		want  any
+		where string
+	}{
+		{"-createdAt", at, at.UTC(), "(r.created_at, r.id) < ($2::timestamptz, $3::bigint)"},
+		{"name", "Ann, \"the\" 2nd", "Ann, \"the\" 2nd", "(u.name, r.id) > ($2::text, $3::bigint)"},
+		{"-seats", int64(12), int64(12), "(r.seats, r.id) < ($2::bigint, $3::bigint)"},
+		{"score", 0.1 + 0.2, 0.1 + 0.2, "(r.score, r.id) > ($2::float8, $3::bigint)"},
+	} {
+		spec := testSpec
+		spec.DefaultStatuses = nil
+		query := "sort=" + url.QueryEscape(tc.sort) + "&limit=2"
+		q, err := parse(t, spec, query)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.sort, err)
+		}
+		items := []item{{1, nil}, {42, tc.value}, {3, nil}}
+		rec := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/items?"+query, nil)
+		page, next := Page(rec, r, q, items, func(it item, sort string) (any, int64) {
+			if sort != q.Sort {
+				t.Errorf("%s: key asked for %q", tc.sort, sort)
+			}
+			return it.value, it.id
+		})
+		if len(page) != 2 || next == "" || rec.Header().Get("Link") == "" {
+			t.Fatalf("%s: page %v next %q", tc.sort, page, next)
+		}
+
+		q, err = parse(t, spec, query+"&cursor="+next)
+		if err != nil {
+			t.Fatalf("%s: next page: %v", tc.sort, err)
+		}
+		args := Args{int64(7)}
+		if got := q.Where(&args); got != " AND "+tc.where {
+			t.Errorf("%s: where %q", tc.sort, got)
+		}
+		if !reflect.DeepEqual(args, Args{int64(7), tc.want, int64(42)}) {
+			t.Errorf("%s: args %#v", tc.sort, args)
+		}
+
+		other := "sort=createdAt&limit=2&cursor=" + next
+		if tc.sort == "name" {
+			other = "sort=-name&limit=2&cursor=" + next
+		}
+		if _, err := parse(t, spec, other); err == nil {
+			t.Errorf("%s: cursor accepted for another sort", tc.sort)
+		}
+	}
+
+	q, _ := parse(t, testSpec, "limit=3")
+	if page, next := Page(httptest.NewRecorder(), httptest.NewRequest("GET", "/items", nil), q, []item{{1, at}, {2, at}}, nil); len(page) != 2 || next != "" {
+		t.Fatalf("last page: %v %q", page, next)
+	}
+}